@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// AccessEntry describes a served request for a Formatter.
+type AccessEntry struct {
+	Start     time.Time
+	Method    string
+	Pattern   string // pattern of the matched route, "" when none matched
+	Path      string // raw path, escaped as sent
+	Proto     string
+	Status    int
+	Size      int64
+	Duration  time.Duration
+	ClientIP  string // see ClientIP
+	RequestID string // see RequestIDs
+}
+
+// Formatter writes the log line of an entry, newline included.
+type Formatter interface {
+	Format(w io.Writer, e *AccessEntry) error
+}
+
+// FormatterFunc adapts a function to a Formatter.
+type FormatterFunc func(w io.Writer, e *AccessEntry) error
+
+func (f FormatterFunc) Format(w io.Writer, e *AccessEntry) error {
+	return f(w, e)
+}
+
+// entry returns the facts of a for a Formatter.
+func (a access) entry() AccessEntry {
+	return AccessEntry{
+		Start:     a.start,
+		Method:    a.r.Method,
+		Pattern:   RoutePattern(a.r),
+		Path:      a.r.URL.EscapedPath(),
+		Proto:     a.r.Proto,
+		Status:    a.status,
+		Size:      a.size,
+		Duration:  a.duration,
+		ClientIP:  ClientIP(a.r),
+		RequestID: a.id,
+	}
+}
+
+// AccessLogger writes a line per request to an io.Writer, see
+// NewAccessLogger.
+type AccessLogger struct {
+	out io.Writer
+	f   Formatter
+
+	mu   sync.Mutex // serializes the writes
+	line strings.Builder
+
+	queue   sync.RWMutex // guards entries and closed against Close
+	entries chan AccessEntry
+	closed  bool
+	done    chan struct{} // closed once the entries are written
+	dropped atomic.Uint64
+}
+
+// NewAccessLogger returns an AccessLogger writing a line per request to out,
+// in the format of f. With a buffer over 0, the lines are formatted and
+// written by a goroutine the requests hand their entry to, through a channel
+// of buffer entries: when it is full, the entries are dropped rather than
+// delaying the responses, and counted by Dropped. Close writes the entries
+// still queued, it is a ServeOptions.OnShutdown.
+func NewAccessLogger(out io.Writer, f Formatter, buffer int) *AccessLogger {
+	l := &AccessLogger{out: out, f: f}
+	if buffer > 0 {
+		l.entries = make(chan AccessEntry, buffer)
+		l.done = make(chan struct{})
+		go func() {
+			defer close(l.done)
+			for e := range l.entries {
+				l.write(&e)
+			}
+		}()
+	}
+	return l
+}
+
+// AccessLog returns the Middleware of a new AccessLogger, see
+// NewAccessLogger.
+func AccessLog(out io.Writer, f Formatter, buffer int) middleware {
+	return NewAccessLogger(out, f, buffer).Middleware
+}
+
+// Middleware logs the requests.
+func (l *AccessLogger) Middleware(h http.Handler) http.Handler {
+	return logRequests(func(a access) {
+		e := a.entry()
+		l.queue.RLock()
+		defer l.queue.RUnlock()
+		if l.entries == nil || l.closed {
+			l.write(&e)
+			return
+		}
+		select {
+		case l.entries <- e:
+		default: // the writer is behind, drop the entry
+			l.dropped.Add(1)
+		}
+	})(h)
+}
+
+// Close waits for the queued entries to be written and stops the goroutine
+// writing them, the next entries are written by the requests. It can be
+// called more than once.
+func (l *AccessLogger) Close() {
+	l.queue.Lock()
+	if l.entries != nil && !l.closed {
+		l.closed = true
+		close(l.entries)
+	}
+	l.queue.Unlock()
+	if l.done != nil {
+		<-l.done
+	}
+}
+
+// Dropped returns the number of entries dropped because the buffer was full.
+func (l *AccessLogger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+func (l *AccessLogger) write(e *AccessEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.line.Reset()
+	if err := l.f.Format(&l.line, e); err == nil {
+		io.WriteString(l.out, l.line.String())
+	}
+}
+
+// CommonLogFormat writes the Apache common log format, DATE being the start
+// of the request:
+//
+//	IP - - [DATE] "METHOD PATH PROTO" STATUS SIZE
+var CommonLogFormat FormatterFunc = func(w io.Writer, e *AccessEntry) error {
+	size := "-"
+	if e.Size > 0 {
+		size = strconv.FormatInt(e.Size, 10)
+	}
+	_, err := fmt.Fprintf(w, "%s - - [%s] \"%s %s %s\" %d %s\n",
+		e.ClientIP, e.Start.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Path, e.Proto, e.Status, size)
+	return err
+}
+
+// JSONLogFormat writes a JSON object per line, the duration in seconds.
+var JSONLogFormat FormatterFunc = func(w io.Writer, e *AccessEntry) error {
+	return json.NewEncoder(w).Encode(struct {
+		Time      time.Time `json:"time"`
+		Method    string    `json:"method"`
+		Pattern   string    `json:"pattern,omitempty"`
+		Path      string    `json:"path"`
+		Proto     string    `json:"proto"`
+		Status    int       `json:"status"`
+		Size      int64     `json:"size"`
+		Duration  float64   `json:"duration"`
+		ClientIP  string    `json:"ip"`
+		RequestID string    `json:"request_id,omitempty"`
+	}{e.Start, e.Method, e.Pattern, e.Path, e.Proto, e.Status, e.Size, e.Duration.Seconds(), e.ClientIP, e.RequestID})
+}
+
+// TemplateFormat returns a Formatter executing the text/template text with
+// the *AccessEntry, then writing a newline. text is parsed once, here.
+func TemplateFormat(text string) (Formatter, error) {
+	tmpl, err := template.New("access").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("router: invalid access log template: %w", err)
+	}
+	return FormatterFunc(func(w io.Writer, e *AccessEntry) error {
+		if err := tmpl.Execute(w, e); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEntry = AccessEntry{
+	Start:     time.Date(2024, time.March, 5, 14, 7, 9, 0, time.FixedZone("", 3600)),
+	Method:    http.MethodGet,
+	Pattern:   "/book/:id",
+	Path:      "/book/42",
+	Proto:     "HTTP/1.1",
+	Status:    http.StatusOK,
+	Size:      512,
+	Duration:  1500 * time.Microsecond,
+	ClientIP:  "192.0.2.1",
+	RequestID: "abc",
+}
+
+func TestFormatters(t *testing.T) {
+	custom, err := TemplateFormat(`{{.ClientIP}} {{.Method}} {{.Pattern}} {{.Status}} {{.Duration}} id={{.RequestID}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		f    Formatter
+		want string
+	}{
+		{"common", CommonLogFormat, `192.0.2.1 - - [05/Mar/2024:14:07:09 +0100] "GET /book/42 HTTP/1.1" 200 512` + "\n"},
+		{"json", JSONLogFormat, `{"time":"2024-03-05T14:07:09+01:00","method":"GET","pattern":"/book/:id","path":"/book/42","proto":"HTTP/1.1","status":200,"size":512,"duration":0.0015,"ip":"192.0.2.1","request_id":"abc"}` + "\n"},
+		{"template", custom, "192.0.2.1 GET /book/:id 200 1.5ms id=abc\n"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		e := testEntry
+		if err := tt.f.Format(&b, &e); err != nil || b.String() != tt.want {
+			t.Errorf("%s = %q, %v, want %q", tt.name, b.String(), err, tt.want)
+		}
+	}
+
+	var b bytes.Buffer
+	e := testEntry
+	e.Size = 0
+	CommonLogFormat.Format(&b, &e)
+	if !strings.HasSuffix(b.String(), " 200 -\n") {
+		t.Errorf("common with no body = %q, want a - size", b.String())
+	}
+
+	if _, err := TemplateFormat("{{.Nope"); err == nil {
+		t.Error("TemplateFormat accepted an invalid template")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	router := NewRouter()
+	router.Use(AccessLog(&out, CommonLogFormat, 0))
+	router.Get("/book/:id", reply("book"))
+
+	serve(router, http.MethodGet, "/book/42")
+	if line := out.String(); !strings.HasPrefix(line, "192.0.2.1 - - [") || !strings.HasSuffix(line, `] "GET /book/42 HTTP/1.1" 200 4`+"\n") {
+		t.Errorf("logged %q", line)
+	}
+}
+
+// blockingWriter blocks the writes until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	lines   chan string
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	<-w.release
+	w.lines <- string(b)
+	return len(b), nil
+}
+
+func TestAccessLogAsync(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{}), lines: make(chan string, 10)}
+	router := NewRouter()
+	logger := NewAccessLogger(out, CommonLogFormat, 1)
+	router.Use(logger.Middleware)
+	router.Get("/", reply("ok"))
+
+	// the writer is stuck, the requests are served all the same
+	for i := 0; i < 5; i++ {
+		if w := serve(router, http.MethodGet, "/"); w.Code != http.StatusOK {
+			t.Fatalf("GET / = %d", w.Code)
+		}
+	}
+	close(out.release)
+	logged := 0
+	for done := false; !done; {
+		select {
+		case <-out.lines:
+			logged++
+		case <-time.After(50 * time.Millisecond):
+			done = true
+		}
+	}
+	// one entry in the writer, at most one in the channel, the others dropped
+	if logged < 1 || logged > 2 {
+		t.Errorf("%d lines logged, want 1 or 2", logged)
+	}
+	if dropped := logger.Dropped(); dropped != uint64(5-logged) {
+		t.Errorf("Dropped() = %d with %d lines logged, want %d", dropped, logged, 5-logged)
+	}
+}
+
+func TestAccessLogClose(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{}), lines: make(chan string, 10)}
+	logger := NewAccessLogger(out, CommonLogFormat, 10)
+	router := NewRouter()
+	router.Use(logger.Middleware)
+	router.Get("/", reply("ok"))
+
+	for i := 0; i < 5; i++ {
+		serve(router, http.MethodGet, "/")
+	}
+	closed := make(chan struct{})
+	go func() {
+		logger.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the queued lines were written")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(out.release)
+	<-closed
+	if len(out.lines) != 5 {
+		t.Errorf("%d lines written by Close, want 5", len(out.lines))
+	}
+
+	serve(router, http.MethodGet, "/") // written by the request once closed
+	logger.Close()
+	if len(out.lines) != 6 || logger.Dropped() != 0 {
+		t.Errorf("%d lines, %d dropped after Close, want 6 and 0", len(out.lines), logger.Dropped())
+	}
+}
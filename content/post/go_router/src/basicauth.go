@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+)
+
+// BasicAuth returns a middleware letting through the requests whose basic
+// auth credentials pass validate, the user name is then returned by User.
+// Other requests, including those with a malformed Authorization header, get
+// a 401 asking for credentials for realm.
+func BasicAuth(realm string, validate func(user, pass string) bool) middleware {
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok || !validate(user, pass) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+		})
+	}
+}
+
+// User returns the user authenticated by BasicAuth, or "".
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(userKey).(string)
+	return user
+}
+
+// StaticCredentials returns a BasicAuth validate function accepting the
+// users and passwords of accounts. The comparisons take the same time
+// whatever the credentials sent.
+func StaticCredentials(accounts map[string]string) func(user, pass string) bool {
+	sums := map[[sha256.Size]byte][sha256.Size]byte{}
+	for user, pass := range accounts {
+		sums[sha256.Sum256([]byte(user))] = sha256.Sum256([]byte(pass))
+	}
+	return func(user, pass string) bool {
+		userSum, passSum := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(pass))
+		match := 0
+		for u, p := range sums {
+			match |= subtle.ConstantTimeCompare(u[:], userSum[:]) & subtle.ConstantTimeCompare(p[:], passSum[:])
+		}
+		return match == 1
+	}
+}
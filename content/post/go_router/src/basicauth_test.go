@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	router := NewRouter()
+	router.Use(BasicAuth("admin", StaticCredentials(map[string]string{"alice": "s3cret", "bob": "hunter2"})))
+	router.Get("/admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + User(r)))
+	})
+
+	tests := []struct {
+		name, authorization string
+		code                int
+		body                string
+	}{
+		{"valid", "Basic YWxpY2U6czNjcmV0", http.StatusOK, "hello alice"}, // alice:s3cret
+		{"other user", "Basic Ym9iOmh1bnRlcjI=", http.StatusOK, "hello bob"},
+		{"wrong password", "Basic YWxpY2U6aHVudGVyMg==", http.StatusUnauthorized, ""}, // alice:hunter2
+		{"unknown user", "Basic ZXZlOnMzY3JldA==", http.StatusUnauthorized, ""},
+		{"missing", "", http.StatusUnauthorized, ""},
+		{"malformed base64", "Basic !!!", http.StatusUnauthorized, ""},
+		{"missing colon", "Basic YWxpY2U=", http.StatusUnauthorized, ""},
+		{"bearer", "Bearer abc", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="admin", charset="UTF-8"` {
+			t.Errorf("%s: WWW-Authenticate = %q", tt.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
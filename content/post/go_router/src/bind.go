@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bind sets the fields of the struct pointed to by dst from the path vars
+// then the query string of r, see BindPath and BindQuery.
+func Bind(r *http.Request, dst any) error {
+	if err := BindPath(r, dst); err != nil {
+		return err
+	}
+	return BindQuery(r, dst)
+}
+
+// BindPath sets the fields of the struct pointed to by dst from the path vars
+// of r, a field is bound to the var named by its `path:"name"` tag. Fields may
+// be strings, integers, bools, time.Time in RFC 3339, or pointers to these
+// which stay nil when the var is absent. Untagged and unexported fields are
+// skipped.
+func BindPath(r *http.Request, dst any) error {
+	params := RouteParams(r)
+	return bind(dst, "path", func(name string) ([]string, bool) {
+		value, ok := params.Lookup(name)
+		return []string{value}, ok
+	})
+}
+
+// BindQuery sets the fields of the struct pointed to by dst from the query
+// string of r, a field is bound to the key named by its `query:"name"` tag.
+// Fields have the types accepted by BindPath, or are slices of these filled
+// from a repeated key. A `default:"value"` tag is used when the key is
+// absent, and `query:"name,required"` makes an absent key an error wrapping
+// ErrMissingVar. A key present with an empty value is converted as is.
+func BindQuery(r *http.Request, dst any) error {
+	query := r.URL.Query()
+	return bind(dst, "query", func(name string) ([]string, bool) {
+		values, ok := query[name]
+		return values, ok
+	})
+}
+
+func bind(dst any, tag string, lookup func(name string) ([]string, bool)) error {
+	v, fields, err := bindPlan(dst, tag)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		values, ok := lookup(f.name)
+		switch {
+		case ok:
+		case f.required:
+			return &VarError{Field: f.field, Name: f.name, Err: ErrMissingVar}
+		case f.hasDefault:
+			values = []string{f.def}
+		default:
+			continue
+		}
+		if err := f.set(v, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field is a struct field bound to a var.
+type field struct {
+	index      []int
+	name       string // var name from the tag
+	field      string // struct field name, for errors
+	required   bool
+	def        string
+	hasDefault bool
+}
+
+// set converts values into the field, a non-slice field takes the first one.
+func (f field) set(v reflect.Value, values []string) error {
+	fv := v.FieldByIndex(f.index)
+	switch fv.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(fv.Type().Elem())
+		if err := f.convert(p.Elem(), values[0]); err != nil {
+			return err
+		}
+		fv.Set(p)
+	case reflect.Slice:
+		s := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := f.convert(s.Index(i), value); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+	default:
+		return f.convert(fv, values[0])
+	}
+	return nil
+}
+
+func (f field) convert(v reflect.Value, value string) error {
+	if err := convert(v, value); err != nil {
+		return &VarError{Field: f.field, Name: f.name, Value: value, Err: err}
+	}
+	return nil
+}
+
+type planKey struct {
+	t   reflect.Type
+	tag string
+}
+
+var plans sync.Map // planKey -> []field
+
+// bindPlan returns the struct dst points to and its fields tagged with tag.
+// The fields of a type are only reflected on once.
+func bindPlan(dst any, tag string) (reflect.Value, []field, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("router: bind destination must be a non-nil pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	key := planKey{v.Type(), tag}
+	if fields, ok := plans.Load(key); ok {
+		return v, fields.([]field), nil
+	}
+	fields, err := plan(v.Type(), tag)
+	if err != nil {
+		return reflect.Value{}, nil, err
+	}
+	plans.Store(key, fields)
+	return v, fields, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func plan(t reflect.Type, tag string) ([]field, error) {
+	fields := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := sf.Tag.Lookup(tag)
+		if !ok || sf.PkgPath != "" {
+			continue
+		}
+		f := field{index: sf.Index, name: name, field: sf.Name}
+		f.name = strings.TrimSuffix(name, ",required")
+		f.required = f.name != name
+		f.def, f.hasDefault = sf.Tag.Lookup("default")
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr || (ft.Kind() == reflect.Slice && tag == "query") {
+			ft = ft.Elem()
+		}
+		if !convertible(ft) {
+			return nil, fmt.Errorf("router: field %s of %s has unsupported type %s", sf.Name, t, sf.Type)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func convertible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return t == timeType
+}
+
+// convert parses value into v, whose type is convertible.
+func convert(v reflect.Value, value string) error {
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBind(t *testing.T) {
+	type dst struct {
+		Tenant   string    `path:"tenant"`
+		ID       int64     `path:"id"`
+		Active   bool      `path:"active"`
+		Since    time.Time `path:"since"`
+		Page     *int      `path:"page"`
+		Limit    *int      `path:"limit"`
+		Unknown  string    `path:"nope"`
+		Untagged string
+		hidden   string `path:"tenant"`
+	}
+	r := varsRequest(map[string]string{
+		"tenant": "acme",
+		"id":     "42",
+		"active": "true",
+		"since":  "2024-03-01T10:00:00Z",
+		"page":   "0",
+	})
+	var got dst
+	got.Unknown = "kept"
+	if err := Bind(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	if got.Tenant != "acme" || got.ID != 42 || !got.Active || !got.Since.Equal(since) {
+		t.Errorf("Bind = %+v", got)
+	}
+	if got.Page == nil || *got.Page != 0 || got.Limit != nil {
+		t.Errorf("Bind pointers = %v %v, want 0 and nil", got.Page, got.Limit)
+	}
+	if got.Unknown != "kept" || got.Untagged != "" || got.hidden != "" {
+		t.Errorf("Bind set skipped fields: %+v", got)
+	}
+
+	// The cached plan is reused.
+	if err := Bind(r, &got); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	r := varsRequest(map[string]string{"id": "300", "since": "yesterday"})
+
+	var small struct {
+		ID int8 `path:"id"`
+	}
+	err := Bind(r, &small)
+	if !errors.Is(err, strconv.ErrRange) || !strings.Contains(err.Error(), "field ID") {
+		t.Errorf("Bind overflow error = %v", err)
+	}
+
+	var when struct {
+		Since time.Time `path:"since"`
+	}
+	if err := Bind(r, &when); err == nil || !strings.Contains(err.Error(), "field Since") {
+		t.Errorf("Bind time error = %v", err)
+	}
+
+	type inner struct{ ID int }
+	var nested struct {
+		Inner inner `path:"id"`
+	}
+	if err := Bind(r, &nested); err == nil || !strings.Contains(err.Error(), "unsupported type") {
+		t.Errorf("Bind nested struct error = %v", err)
+	}
+
+	if err := Bind(r, small); err == nil {
+		t.Error("Bind to a struct value succeeded")
+	}
+}
+
+func TestBindQuery(t *testing.T) {
+	type list struct {
+		Tenant string   `path:"tenant"`
+		Page   int      `query:"page" default:"1"`
+		Limit  *int     `query:"limit"`
+		Tags   []string `query:"tag"`
+		IDs    []int    `query:"id"`
+		Q      string   `query:"q" default:"all"`
+		Sort   string   `query:"sort,required"`
+	}
+	tests := []struct {
+		query string
+		want  string
+		err   string
+	}{
+		{"sort=name", "acme 1 <nil> [] [] all name", ""},
+		{"sort=name&page=3&limit=0&tag=a&tag=b&id=1&id=2", "acme 3 0 [a b] [1 2] all name", ""},
+		{"sort=name&tag=a%2Cb", "acme 1 <nil> [a,b] [] all name", ""},
+		{"sort=&q=", "acme 1 <nil> [] []  ", ""},
+		{"page=2", "", "router: field Sort: missing sort"},
+		{"sort=name&page=", "", `router: field Page: malformed page="": strconv.ParseInt: parsing "": invalid syntax`},
+		{"sort=name&page=9223372036854775808", "", "value out of range"},
+		{"sort=name&id=1&id=x", "", `malformed id="x"`},
+	}
+	for _, tt := range tests {
+		r := varsRequest(map[string]string{"tenant": "acme"})
+		r.URL.RawQuery = tt.query
+		var got list
+		err := Bind(r, &got)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Bind(?%s) error = %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Bind(?%s) error = %v", tt.query, err)
+			continue
+		}
+		limit := "<nil>"
+		if got.Limit != nil {
+			limit = strconv.Itoa(*got.Limit)
+		}
+		s := fmt.Sprintf("%s %d %s %v %v %s %s", got.Tenant, got.Page, limit, got.Tags, got.IDs, got.Q, got.Sort)
+		if s != tt.want {
+			t.Errorf("Bind(?%s) = %q, want %q", tt.query, s, tt.want)
+		}
+	}
+
+	r := varsRequest(nil)
+	r.URL.RawQuery = "page=2"
+	var missing list
+	if err := BindQuery(r, &missing); !errors.Is(err, ErrMissingVar) {
+		t.Errorf("BindQuery without required key error = %v, want ErrMissingVar", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// BodyLimit returns a middleware limiting the request bodies to n bytes, the
+// reads past n fail with an *http.MaxBytesError. A BodyLimit of a route
+// replaces the one of the router, so an upload route can raise the limit of
+// every other route, provided the body was not read in between. BindJSON
+// calls passing a maxBytes of 0 use the limit too.
+//
+// A handler answering with another status after a read hit the limit, or not
+// answering, is replaced by a 413, in JSON when the request is JSON or
+// accepts it.
+func BodyLimit(n int64) middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if body, ok := r.Body.(*limitedBody); ok {
+				body.reset(w, n)
+				h.ServeHTTP(w, r)
+				return
+			}
+			if r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r)
+				return
+			}
+			body := &limitedBody{orig: r.Body, contentLength: r.ContentLength}
+			body.reset(w, n)
+			lw := &limitWriter{ResponseWriter: w, r: r, body: body}
+			r2 := *r
+			r2.Body = body
+			h.ServeHTTP(lw, &r2)
+			if body.tooLarge && !lw.wroteHeader {
+				lw.tooLarge()
+			}
+		})
+	}
+}
+
+// limitedBody is the body of a request under BodyLimit. It is shared by the
+// BodyLimit middlewares of the router and of the route, the innermost sets
+// the limit.
+type limitedBody struct {
+	io.ReadCloser // http.MaxBytesReader over orig
+	orig          io.ReadCloser
+	contentLength int64
+	limit         int64
+	tooLarge      bool
+}
+
+func (b *limitedBody) reset(w http.ResponseWriter, n int64) {
+	b.limit = n
+	b.ReadCloser = http.MaxBytesReader(w, b.orig, n)
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.contentLength > b.limit {
+		// no need to read what the client announced to be too large
+		b.tooLarge = true
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		b.tooLarge = true
+	}
+	return n, err
+}
+
+// limitWriter answers 413 in place of the handler once the body was found
+// too large.
+type limitWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	body        *limitedBody
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *limitWriter) WriteHeader(code int) {
+	if w.wroteHeader || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.body.tooLarge && code != http.StatusRequestEntityTooLarge {
+		w.tooLarge()
+		w.discard = true
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *limitWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.discard {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter.
+func (w *limitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *limitWriter) tooLarge() {
+	w.wroteHeader = true
+	code := http.StatusRequestEntityTooLarge
+	if strings.Contains(w.r.Header.Get("Content-Type"), "json") || strings.Contains(w.r.Header.Get("Accept"), "json") {
+		header := w.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Type", "application/json")
+		header.Set("X-Content-Type-Options", "nosniff")
+		w.ResponseWriter.WriteHeader(code)
+		fmt.Fprintf(w.ResponseWriter, `{"error":%q,"limit":%d}`+"\n", http.StatusText(code), w.body.limit)
+		return
+	}
+	http.Error(w.ResponseWriter, http.StatusText(code), code)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimit(t *testing.T) {
+	read := func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%d", len(b))
+	}
+	router := NewRouter()
+	router.Use(BodyLimit(8))
+	router.Post("/form", read)
+	router.Post("/upload", read, BodyLimit(16))
+	router.Post("/json", func(w http.ResponseWriter, r *http.Request) {
+		var v any
+		if err := BindJSON(r, &v, 0); err != nil {
+			http.Error(w, err.Error(), err.(*BodyError).Status)
+		}
+	})
+
+	tests := []struct {
+		path    string
+		body    string
+		chunked bool
+		code    int
+		resp    string
+	}{
+		{"/form", "12345678", false, http.StatusOK, "8"},
+		{"/form", "123456789", false, http.StatusRequestEntityTooLarge, "Request Entity Too Large\n"},
+		{"/form", "12345678", true, http.StatusOK, "8"},
+		{"/form", "123456789", true, http.StatusRequestEntityTooLarge, "Request Entity Too Large\n"},
+		{"/upload", "0123456789abcdef", false, http.StatusOK, "16"},
+		{"/upload", "0123456789abcdefg", true, http.StatusRequestEntityTooLarge, "Request Entity Too Large\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.resp {
+			t.Errorf("POST %s %q (chunked %v) = %d %q, want %d %q", tt.path, tt.body, tt.chunked, w.Code, w.Body.String(), tt.code, tt.resp)
+		}
+	}
+
+	// a JSON request gets a JSON 413, BindJSON answering 413 itself is kept
+	r := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(`{"name":"gopher"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if want := `{"error":"Request Entity Too Large","limit":8}` + "\n"; w.Code != http.StatusRequestEntityTooLarge || w.Body.String() != want {
+		t.Errorf("POST /form JSON = %d %q, want 413 %q", w.Code, w.Body.String(), want)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/json", strings.NewReader(`{"name":"gopher"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "invalid JSON body") {
+		t.Errorf("POST /json = %d %q, want the BindJSON 413", w.Code, w.Body.String())
+	}
+}
+
+func TestBodyLimitUnread(t *testing.T) {
+	router := NewRouter()
+	router.Use(BodyLimit(4))
+	router.Post("/ignore", reply("ignored"))
+
+	r := httptest.NewRequest(http.MethodPost, "/ignore", strings.NewReader("too large a body"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "ignored" {
+		t.Errorf("POST /ignore = %d %q, want the handler answer", w.Code, w.Body.String())
+	}
+}
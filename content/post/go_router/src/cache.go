@@ -0,0 +1,94 @@
+package main
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+)
+
+// WithMatchCache keeps the trie searches of the last size distinct request
+// paths, so a hot path matching params skips the trie walk and the regexes.
+// The vars are cached with the node: search derives them from the path,
+// which is the key. Fully static routes never reach the cache, they are
+// found with a map lookup already. The cache is emptied by every change to
+// the routes, see MatchCacheStats.
+func WithMatchCache(size int) Option {
+	return func(router *Router) {
+		router.cacheSize = size
+	}
+}
+
+// MatchCacheStats returns the number of lookups of the match cache that
+// found the path and of those that did not, since the router was created.
+func (router *Router) MatchCacheStats() (hits, misses uint64) {
+	return router.cacheHits.Load(), router.cacheMisses.Load()
+}
+
+// matchCacheShards is the number of shards of a large cache. A request only
+// locks the shard of its path.
+const matchCacheShards = 16
+
+// matchCache is a least recently used cache of the search results of a
+// table, split in shards locked separately.
+type matchCache struct {
+	seed   maphash.Seed
+	shards []cacheShard
+}
+
+type cacheShard struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     list.List // of *cacheEntry, most recently used first
+}
+
+type cacheEntry struct {
+	path   string
+	node   *node
+	params Params
+}
+
+func newMatchCache(size int) *matchCache {
+	shards := min(size, matchCacheShards)
+	c := &matchCache{seed: maphash.MakeSeed(), shards: make([]cacheShard, shards)}
+	for i := range c.shards {
+		c.shards[i].size = (size + shards - 1) / shards
+		c.shards[i].entries = map[string]*list.Element{}
+	}
+	return c
+}
+
+func (c *matchCache) shard(path string) *cacheShard {
+	return &c.shards[maphash.String(c.seed, path)%uint64(len(c.shards))]
+}
+
+// get returns the entry of path, the caller must not modify its params.
+func (c *matchCache) get(path string) (*cacheEntry, bool) {
+	s := c.shard(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[path]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry), true
+}
+
+// add caches node and a copy of params for path, evicting the least
+// recently used entry of the shard when it is full.
+func (c *matchCache) add(path string, node *node, params Params) {
+	s := c.shard(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[path]; ok {
+		return // added by a concurrent request
+	}
+	if s.lru.Len() >= s.size {
+		oldest := s.lru.Back()
+		delete(s.entries, oldest.Value.(*cacheEntry).path)
+		s.lru.Remove(oldest)
+	}
+	entry := &cacheEntry{path: path, node: node, params: append(Params(nil), params...)}
+	s.entries[path] = s.lru.PushFront(entry)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestMatchCache(t *testing.T) {
+	router := NewRouter(WithMatchCache(1))
+	router.Get("/files/:dir/:name:[a-z]+", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "file ", Param(r, "dir"), " ", Param(r, "name"))
+	})
+	router.Get("/home", reply("home"))
+
+	tests := []struct {
+		path, body   string
+		hits, misses uint64
+	}{
+		{"/files/a/x", "file a x", 0, 1},
+		{"/files/a/x", "file a x", 1, 1},
+		{"/home", "home", 1, 1}, // static routes skip the cache
+		{"/files/b/y", "file b y", 1, 2},
+		{"/files/a/x", "file a x", 1, 3}, // evicted by /files/b/y
+		{"/files/a/1", "404 page not found\n", 1, 4},
+		{"/files/a/1", "404 page not found\n", 1, 5}, // unmatched paths are not cached
+	}
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Body.String() != tt.body {
+			t.Errorf("GET %s = %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+		if hits, misses := router.MatchCacheStats(); hits != tt.hits || misses != tt.misses {
+			t.Errorf("after GET %s, %d hits and %d misses, want %d and %d", tt.path, hits, misses, tt.hits, tt.misses)
+		}
+	}
+
+	// a new route shadowing a cached match empties the cache
+	router.Get("/files/a/:other", reply("other"))
+	if w := serve(router, http.MethodGet, "/files/a/x"); w.Body.String() != "other" {
+		t.Errorf("GET /files/a/x after Handle = %q, want %q", w.Body.String(), "other")
+	}
+	router.Unhandle("/files/a/:other", http.MethodGet)
+	if w := serve(router, http.MethodGet, "/files/a/x"); w.Body.String() != "file a x" {
+		t.Errorf("GET /files/a/x after Unhandle = %q, want %q", w.Body.String(), "file a x")
+	}
+}
+
+func TestMatchCacheMount(t *testing.T) {
+	users := NewRouter(WithMatchCache(16))
+	users.Get("/:id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, Param(r, "tid"), " ", Param(r, "id"))
+	})
+	router := NewRouter()
+	router.Mount("/tenants/:tid/users", users)
+
+	// the cached vars of the mounted router follow the vars of the mount
+	for _, tid := range []string{"1", "2", "1"} {
+		if w := serve(router, http.MethodGet, "/tenants/"+tid+"/users/42"); w.Body.String() != tid+" 42" {
+			t.Errorf("GET tenant %s = %q, want %q", tid, w.Body.String(), tid+" 42")
+		}
+	}
+	if hits, misses := users.MatchCacheStats(); hits != 2 || misses != 1 {
+		t.Errorf("%d hits and %d misses, want 2 and 1", hits, misses)
+	}
+}
+
+// TestMatchCacheConcurrent is meant for go test -race.
+func TestMatchCacheConcurrent(t *testing.T) {
+	router := NewRouter(WithMatchCache(8))
+	router.Get("/book/:id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, Param(r, "id"))
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				id := fmt.Sprint((i + j) % 20)
+				if w := serve(router, http.MethodGet, "/book/"+id); w.Body.String() != id {
+					t.Errorf("GET /book/%s = %q", id, w.Body.String())
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// BenchmarkMatchCache serves a regex route from a few hot paths, where the
+// cache helps, and from distinct paths, where every lookup misses and pays
+// for the insertion too.
+func BenchmarkMatchCache(b *testing.B) {
+	hot := []string{"/book/42/reviews/great-read", "/book/7/reviews/meh", "/book/1/reviews/ok"}
+	var distinct []string
+	for i := 0; i < 4096; i++ {
+		distinct = append(distinct, fmt.Sprintf("/book/%d/reviews/r%d", i, i))
+	}
+	for _, size := range []int{0, 1024} {
+		router := NewRouter(WithMatchCache(size))
+		router.Get("/user/:name", reply("user"))
+		router.Get("/book/:id:[0-9]+/comments", reply("comments"))
+		router.Get("/book/:id:[0-9]+/reviews/:review:[a-z0-9-]+", reply("review"))
+		b.Run(fmt.Sprintf("size=%d/hot", size), func(b *testing.B) {
+			benchmarkServe(b, router, http.MethodGet, hot...)
+		})
+		b.Run(fmt.Sprintf("size=%d/distinct", size), func(b *testing.B) {
+			benchmarkServe(b, router, http.MethodGet, distinct...)
+		})
+	}
+}
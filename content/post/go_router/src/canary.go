@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+)
+
+// Split sends a fraction of the requests of a route to the handler of the
+// route, the canary, and the others to a stable handler, for migrating a
+// route. The fraction can be changed while serving. The requests with an
+// X-Request-Id header, or an ID given by RequestIDs, always get the same
+// variant for the same fraction, the others are picked at random.
+type Split struct {
+	stable   http.Handler
+	fraction atomic.Uint64 // math.Float64bits
+	shadow   bool
+	maxBody  int64         // of the shadowed requests, see NewShadow
+	shadows  chan struct{} // one per shadowed request in flight
+	dropped  atomic.Uint64
+}
+
+// maxShadowed is the number of shadowed requests a Split serves at once.
+const maxShadowed = 64
+
+// The variants returned by Variant.
+const (
+	VariantStable = "stable"
+	VariantCanary = "canary"
+	VariantShadow = "shadow"
+)
+
+// NewCanary returns a Split serving fraction of the requests, from 0 to 1,
+// with the handler of the route and the others with stable.
+func NewCanary(fraction float64, stable http.Handler) *Split {
+	s := &Split{stable: stable}
+	s.SetFraction(fraction)
+	return s
+}
+
+// Canary returns the Middleware of a new Split, see NewCanary.
+func Canary(fraction float64, stable http.Handler) middleware {
+	return NewCanary(fraction, stable).Middleware
+}
+
+// NewShadow returns a Split serving every request with stable, and sending
+// a copy of fraction of them to the handler of the route, whose answer is
+// discarded, for a dark launch. The copy runs after the request is served,
+// with a context that is not cancelled with it, and its body is duplicated
+// up to maxBody bytes: the requests with a longer body are not shadowed.
+// At most 64 copies run at once, the others are dropped and counted by
+// Dropped.
+func NewShadow(fraction float64, stable http.Handler, maxBody int64) *Split {
+	s := &Split{stable: stable, shadow: true, maxBody: maxBody, shadows: make(chan struct{}, maxShadowed)}
+	s.SetFraction(fraction)
+	return s
+}
+
+// Shadow returns the Middleware of a new Split, see NewShadow.
+func Shadow(fraction float64, stable http.Handler, maxBody int64) middleware {
+	return NewShadow(fraction, stable, maxBody).Middleware
+}
+
+// SetFraction changes the fraction of the requests sent to the canary,
+// clamped to [0, 1].
+func (s *Split) SetFraction(fraction float64) {
+	s.fraction.Store(math.Float64bits(min(max(fraction, 0), 1)))
+}
+
+// Fraction returns the fraction of the requests sent to the canary.
+func (s *Split) Fraction() float64 {
+	return math.Float64frombits(s.fraction.Load())
+}
+
+// Dropped returns the number of copies not shadowed because too many were
+// running.
+func (s *Split) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Middleware splits the requests between h, the canary, and the stable
+// handler.
+func (s *Split) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canary := s.picks(r)
+		switch {
+		case s.shadow:
+			s.serveShadowed(h, w, r, canary)
+		case canary:
+			h.ServeHTTP(w, withVariant(r, VariantCanary))
+		default:
+			s.stable.ServeHTTP(w, withVariant(r, VariantStable))
+		}
+	})
+}
+
+// picks reports whether r goes to the canary.
+func (s *Split) picks(r *http.Request) bool {
+	fraction := s.Fraction()
+	id := RequestID(r)
+	if id == "" {
+		id = r.Header.Get("X-Request-Id")
+	}
+	if id == "" {
+		return rand.Float64() < fraction
+	}
+	hash := fnv.New64a()
+	io.WriteString(hash, id)
+	return float64(hash.Sum64()%10000) < fraction*10000
+}
+
+// serveShadowed serves r with the stable handler, then a copy of r with h
+// when shadowed.
+func (s *Split) serveShadowed(h http.Handler, w http.ResponseWriter, r *http.Request, shadowed bool) {
+	var body []byte
+	if shadowed && r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, s.maxBody+1))
+		rest := r.Body
+		r = r.Clone(r.Context())
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), rest), rest}
+		shadowed = err == nil && int64(len(body)) <= s.maxBody
+	}
+	var copied *http.Request
+	if shadowed {
+		copied = r.Clone(context.WithValue(context.WithoutCancel(r.Context()), variantKey, VariantShadow))
+		copied.Body = http.NoBody
+		if body != nil {
+			copied.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+
+	s.stable.ServeHTTP(w, withVariant(r, VariantStable))
+	if copied == nil {
+		return
+	}
+	select {
+	case s.shadows <- struct{}{}:
+		go s.serveDiscarded(h, copied)
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// serveDiscarded serves r with h, discarding the answer and logging a panic.
+func (s *Split) serveDiscarded(h http.Handler, r *http.Request) {
+	defer func() {
+		<-s.shadows
+		if err := recover(); err != nil && err != http.ErrAbortHandler {
+			log.Printf("router: panic shadowing %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}()
+	h.ServeHTTP(&discardWriter{header: http.Header{}}, r)
+}
+
+// discardWriter is the ResponseWriter of a shadowed request, nothing written
+// to it reaches the client.
+type discardWriter struct {
+	header http.Header
+}
+
+func (dw *discardWriter) Header() http.Header {
+	return dw.header
+}
+
+func (dw *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (dw *discardWriter) WriteHeader(code int) {}
+
+func withVariant(r *http.Request, variant string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), variantKey, variant))
+}
+
+// Variant returns the variant of a Split that r is served by, VariantStable,
+// VariantCanary or VariantShadow, or "" outside of a Split.
+func Variant(r *http.Request) string {
+	variant, _ := r.Context().Value(variantKey).(string)
+	return variant
+}
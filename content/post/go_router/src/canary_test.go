@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func variantHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, Variant(r))
+	}
+}
+
+func TestCanary(t *testing.T) {
+	split := NewCanary(0.3, variantHandler("old"))
+	router := NewRouter()
+	router.Get("/search", variantHandler("new"), split.Middleware)
+
+	get := func(id string) string {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		if id != "" {
+			r.Header.Set("X-Request-Id", id)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	const ids = 2000
+	first := map[string]string{}
+	canaries := 0
+	for i := range ids {
+		id := fmt.Sprintf("req-%d", i)
+		got := get(id)
+		if got != "new canary" && got != "old stable" {
+			t.Fatalf("GET /search = %q, want a variant", got)
+		}
+		if got == "new canary" {
+			canaries++
+		}
+		first[id] = got
+	}
+	if canaries < ids*25/100 || canaries > ids*35/100 {
+		t.Errorf("%d canaries of %d requests, want about 30%%", canaries, ids)
+	}
+	for id, want := range first {
+		if got := get(id); got != want {
+			t.Fatalf("GET /search with X-Request-Id %s = %q then %q, want the same variant", id, want, got)
+		}
+	}
+
+	split.SetFraction(0)
+	if got := get("req-1"); got != "old stable" {
+		t.Errorf("GET /search with a fraction of 0 = %q, want old stable", got)
+	}
+	split.SetFraction(2)
+	if got := split.Fraction(); got != 1 {
+		t.Errorf("Fraction() after SetFraction(2) = %v, want 1", got)
+	}
+	if got := get(""); got != "new canary" {
+		t.Errorf("GET /search with a fraction of 1 = %q, want new canary", got)
+	}
+}
+
+func TestCanaryRequestID(t *testing.T) {
+	split := NewCanary(0.5, http.NotFoundHandler())
+	for i := range 50 {
+		id := fmt.Sprintf("req-%d", i)
+		byHeader := httptest.NewRequest(http.MethodGet, "/", nil)
+		byHeader.Header.Set("X-Request-Id", id)
+		byID := httptest.NewRequest(http.MethodGet, "/", nil)
+		byID.Header.Set("X-Request-Id", "ignored")
+		byID = byID.WithContext(context.WithValue(byID.Context(), requestIDKey, id))
+		if split.picks(byID) != split.picks(byHeader) {
+			t.Errorf("picks() with the RequestIDs ID %s differs from the header %s", id, id)
+		}
+	}
+}
+
+func TestShadow(t *testing.T) {
+	type shadowed struct {
+		body, variant, param string
+	}
+	done := make(chan shadowed, 1)
+	router := NewRouter()
+	router.Post("/search/:index", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-New", "yes")
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, "new")
+		done <- shadowed{string(body), Variant(r), Param(r, "index")}
+	}, Shadow(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "old %s %s", Variant(r), body)
+	}), 16))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/search/books", strings.NewReader(body)))
+		return w
+	}
+
+	w := post("q=gopher")
+	if w.Code != http.StatusOK || w.Body.String() != "old stable q=gopher" || w.Header().Get("X-New") != "" {
+		t.Errorf("POST /search/books = %d %q %v, want the stable answer only", w.Code, w.Body.String(), w.Header())
+	}
+	select {
+	case got := <-done:
+		if want := (shadowed{"q=gopher", VariantShadow, "books"}); got != want {
+			t.Errorf("shadowed request = %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the request was not shadowed")
+	}
+
+	long := strings.Repeat("x", 17)
+	if w := post(long); w.Body.String() != "old stable "+long {
+		t.Errorf("POST /search/books with a long body = %q, want the whole body served", w.Body.String())
+	}
+	select {
+	case got := <-done:
+		t.Errorf("shadowed a request over the body cap: %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestShadowDropped(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, maxShadowed)
+	split := NewShadow(1, reply("old"), 0)
+	router := NewRouter()
+	router.Get("/search", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}, split.Middleware)
+
+	for range maxShadowed + 3 {
+		if w := serve(router, http.MethodGet, "/search"); w.Body.String() != "old" {
+			t.Fatalf("GET /search = %q, want old", w.Body.String())
+		}
+	}
+	for range maxShadowed {
+		<-started
+	}
+	if got := split.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, want 3", got)
+	}
+	close(release)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For and X-Real-Ip
+// headers ClientIP believes, each written as a CIDR such as "10.0.0.0/8" or
+// as a single IP. No proxy is trusted by default, and when cidrs is empty.
+func (router *Router) SetTrustedProxies(cidrs []string) error {
+	proxies := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return fmt.Errorf("router: invalid trusted proxy %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr.WithZone(""), addr.BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		if len(proxies) == 0 {
+			proxies = nil
+		}
+		t.proxies = proxies
+		return nil
+	})
+}
+
+// ClientIP returns the IP of the client that sent r. When the connection
+// comes from a trusted proxy, see Router.SetTrustedProxies, X-Forwarded-For
+// is read from right to left, skipping the trusted proxies, and the first
+// other address is the client. Without X-Forwarded-For, X-Real-Ip is used.
+// The headers of the other peers are ignored, anyone can send them.
+func ClientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	proxies, _ := r.Context().Value(proxiesKey).([]netip.Prefix)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !trusted(proxies, addr) {
+		return remote
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if real, err := parseForwarded(r.Header.Get("X-Real-Ip")); err == nil {
+			return real.String()
+		}
+		return remote
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := parseForwarded(hops[i])
+		if err != nil {
+			break // the proxies wrote garbage, keep the last one
+		}
+		addr = hop
+		if !trusted(proxies, hop) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// RealIP returns a middleware replacing r.RemoteAddr with ClientIP, for the
+// handlers and middlewares reading it directly.
+func RealIP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = ClientIP(r)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func trusted(proxies []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded parses an address of X-Forwarded-For or X-Real-Ip, with or
+// without a port.
+func parseForwarded(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr, nil
+	}
+	addrPort, err := netip.ParseAddrPort(s)
+	return addrPort.Addr(), err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	router := NewRouter()
+	if err := router.SetTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32", "fe80::1"}); err != nil {
+		t.Fatal(err)
+	}
+	router.Get("/ip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ClientIP(r)))
+	})
+	router.Get("/real", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	}, RealIP)
+
+	tests := []struct {
+		name, remote, xff, realIP, want string
+	}{
+		{"no proxy", "192.0.2.1:1234", "", "", "192.0.2.1"},
+		{"single trusted hop", "10.0.0.1:1234", "198.51.100.7", "", "198.51.100.7"},
+		{"chained proxies", "10.0.0.1:1234", "198.51.100.7, 10.1.1.1,10.2.2.2", "", "198.51.100.7"},
+		{"spoofed behind proxy", "10.0.0.1:1234", "6.6.6.6, 198.51.100.7, 10.1.1.1", "", "198.51.100.7"},
+		{"untrusted peer fake XFF", "192.0.2.1:1234", "198.51.100.7", "198.51.100.8", "192.0.2.1"},
+		{"all trusted", "10.0.0.1:1234", "10.3.3.3, 10.1.1.1", "", "10.3.3.3"},
+		{"garbage hop", "10.0.0.1:1234", "198.51.100.7, garbage, 10.1.1.1", "", "10.1.1.1"},
+		{"hop with port", "10.0.0.1:1234", "198.51.100.7:5678", "", "198.51.100.7"},
+		{"real ip", "10.0.0.1:1234", "", "198.51.100.8", "198.51.100.8"},
+		{"ipv6 proxy", "[2001:db8::1]:443", "2001:db8:ffff::1, [2606:4700::1]:80", "", "2606:4700::1"},
+		{"ipv6 zone", "[fe80::1%eth0]:443", "198.51.100.7", "", "198.51.100.7"},
+		{"untrusted ipv6 zone", "[fe80::2%eth0]:443", "198.51.100.7", "", "fe80::2%eth0"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-Ip", tt.realIP)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Body.String() != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, w.Body.String(), tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/real", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Body.String() != "198.51.100.7" {
+		t.Errorf("RealIP RemoteAddr = %q, want 198.51.100.7", w.Body.String())
+	}
+
+	if err := router.SetTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("SetTrustedProxies accepted an invalid CIDR")
+	}
+}
+
+func TestClientIPRateLimit(t *testing.T) {
+	router := NewRouter()
+	router.SetTrustedProxies([]string{"10.0.0.1"})
+	router.Use(RateLimit(1, 1, nil))
+	router.Get("/", reply("ok"))
+
+	get := func(client string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	// the clients behind the proxy have their own bucket
+	if get("198.51.100.7") != http.StatusOK || get("198.51.100.8") != http.StatusOK {
+		t.Error("clients behind the proxy share the proxy bucket")
+	}
+	if code := get("198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Errorf("second request = %d, want 429", code)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrCompiled is returned by the changes to a router frozen by Compile.
+var ErrCompiled = errors.New("router: routes are compiled")
+
+// Compile checks the routes and freezes the router for an application that
+// registers everything at startup. It fails with every registration the
+// router rejected, invalid paths and regexes, misplaced wildcards and
+// conflicts, including those whose error was ignored. It returns a handler
+// serving the routes as they are: the trie is compressed, the static routes
+// indexed and the middleware chains composed already, and the handler reads
+// them without any lock or atomic load of the routes. It only counts the
+// requests in flight of Stats, atomically, and does not use the match cache,
+// whose LRU is locked.
+//
+// The changes to the routes, handlers and middlewares of the router fail
+// with ErrCompiled afterwards, Host panics with it. The router keeps serving
+// too. The routers mounted or served by Host are
+// not frozen, compile them first.
+func (router *Router) Compile() (http.Handler, error) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	if err := errors.Join(router.rejected...); err != nil {
+		return nil, err
+	}
+	router.compiled = true
+	t := router.routes.Load().clone()
+	t.cache = nil
+	if len(t.pre) > 0 {
+		t.preChain = chain(router.dispatcher(t), t.pre)
+	}
+	return &compiled{router: router, t: t}, nil
+}
+
+type compiled struct {
+	router *Router
+	t      *table
+}
+
+func (c *compiled) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.router.serve(c.t, w, r)
+}
+
+// reject records err, returned by a registration, for Compile. The caller
+// holds router.mu.
+func (router *Router) reject(err error) error {
+	if err != nil && err != ErrCompiled {
+		router.rejected = append(router.rejected, err)
+	}
+	return err
+}
+
+// mustUpdate is update for the changes that only fail on a compiled router
+// and have no error to return, it panics then like MustHandle.
+func (router *Router) mustUpdate(fn func(t *table) error) {
+	if err := router.update(fn); err != nil {
+		panic(err)
+	}
+}
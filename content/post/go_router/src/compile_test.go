@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	router := NewRouter(WithMatchCache(8))
+	router.Pre(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+			h.ServeHTTP(w, r)
+		})
+	})
+	router.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Wrapped", "yes")
+			h.ServeHTTP(w, r)
+		})
+	})
+	router.Get("/about", reply("about"))
+	router.Get("/book/:id:[0-9]+", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("book " + Param(r, "id")))
+	})
+
+	handler, err := router.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodGet, "/about", "about", http.StatusOK},
+		{http.MethodGet, "/v1/book/42", "book 42", http.StatusOK},
+		{http.MethodGet, "/book/abc", "404 page not found\n", http.StatusNotFound},
+		{http.MethodPost, "/about", "Method Not Allowed\n", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code || w.Body.String() != tt.body || w.Header().Get("X-Wrapped") != "yes" {
+			t.Errorf("%s %s = %d %q, want %d %q wrapped", tt.method, tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+	if hits, misses := router.MatchCacheStats(); hits+misses != 0 {
+		t.Errorf("the compiled handler used the match cache: %d hits, %d misses", hits, misses)
+	}
+
+	if err := router.Get("/new", reply("new")); !errors.Is(err, ErrCompiled) {
+		t.Errorf("Get after Compile = %v, want ErrCompiled", err)
+	}
+	if err := router.Unhandle("/about", http.MethodGet); !errors.Is(err, ErrCompiled) {
+		t.Errorf("Unhandle after Compile = %v, want ErrCompiled", err)
+	}
+	changes := map[string]func() error{
+		"Use":          func() error { return router.Use(func(h http.Handler) http.Handler { return h }) },
+		"Pre":          func() error { return router.Pre(func(h http.Handler) http.Handler { return h }) },
+		"NotFound":     func() error { return router.NotFound(http.NotFoundHandler()) },
+		"PanicHandler": func() error { return router.PanicHandler(func(http.ResponseWriter, *http.Request, any) {}) },
+		"SetValue":     func() error { return router.SetValue("key", "value") },
+	}
+	for name, change := range changes {
+		if err := change(); !errors.Is(err, ErrCompiled) {
+			t.Errorf("%s after Compile = %v, want ErrCompiled", name, err)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/about"); w.Body.String() != "about" {
+		t.Errorf("GET /about on the compiled router = %q", w.Body.String())
+	}
+	if again, err := router.Compile(); err != nil || again == nil {
+		t.Errorf("Compile of a compiled router = %v, %v, want a handler", again, err)
+	}
+}
+
+func TestCompileRejected(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book/:id", reply("book"))
+	router.Get("/book/:id", reply("again"))     // conflict, error ignored
+	router.Get("/file/*path/raw", reply("raw")) // wildcard not last
+	router.Get("/user/:id:[0-9", reply("user")) // invalid regex
+	router.HandleNamed("book", "/b", "GET", reply("b"))
+	router.HandleNamed("book", "/c", "GET", reply("c")) // duplicate name
+
+	handler, err := router.Compile()
+	if handler != nil || err == nil {
+		t.Fatal("Compile succeeded with rejected routes")
+	}
+	for _, want := range []string{"conflicts with existing", "must be the last segment", "invalid regex", `name "book"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Compile error %q does not report %q", err, want)
+		}
+	}
+	if err := router.Get("/ok", reply("ok")); err != nil {
+		t.Errorf("Get after a failed Compile = %v, the router is not frozen", err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var compressTypes = []string{
+	"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml",
+}
+
+// Compress returns a middleware gzipping the responses of the clients
+// accepting it, with the given gzip level. Only the Content-Types listed in
+// types are compressed, "text/*" matches every text type, and by default text,
+// JSON, JavaScript, XML and SVG are. A response shorter than minSize bytes, or
+// with a Content-Encoding already set, is sent as is. Flush flushes the
+// compressed stream so server-sent events keep working, and a hijacked
+// connection, of a websocket say, is left to the handler. Compress panics
+// with an invalid level.
+func Compress(level, minSize int, types ...string) middleware {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		panic("router: Compress: " + err.Error())
+	}
+	if len(types) == 0 {
+		types = compressTypes
+	}
+	pool := sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, level)
+		return gz
+	}}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w, pool: &pool, types: types, minSize: minSize}
+			defer gw.close()
+			h.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip: with a
+// gzip element of a q above 0, or without one, with such a "*" element.
+func acceptsGzip(accept string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		switch coding = strings.TrimSpace(coding); {
+		case strings.EqualFold(coding, "gzip"):
+			gzipQ = max(gzipQ, qValue(params))
+		case coding == "*":
+			anyQ = max(anyQ, qValue(params))
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// acceptable reports whether the params of an Accept or Accept-Encoding
+// element do not refuse it with a zero q.
+func acceptable(params string) bool {
+	return qValue(params) > 0
+}
+
+// qValue returns the q param of the params of an Accept or Accept-Encoding
+// element, 1 without one and 0 when malformed.
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// gzipWriter buffers the start of a response until it knows whether to
+// compress it: once minSize bytes are written, on Flush, or when the handler
+// returns.
+type gzipWriter struct {
+	http.ResponseWriter
+	pool    *sync.Pool
+	types   []string
+	minSize int
+
+	code    int
+	buf     bytes.Buffer
+	decided bool
+	gz      *gzip.Writer // nil when the response is sent as is
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if code < 200 || w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		w.decide(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack hands the connection to the handler, close sends nothing on it.
+func (w *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.decided = true
+		w.buf.Reset()
+	}
+	return conn, brw, err
+}
+
+// decide sends the header, compressed when size allows it and the response
+// is eligible, then the buffered bytes.
+func (w *gzipWriter) decide(size bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && w.buf.Len() > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if size && w.compressible() {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+func (w *gzipWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || w.code == http.StatusNoContent || w.code == http.StatusNotModified {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range w.types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// close sends what is still buffered, a response shorter than minSize is sent
+// uncompressed, and returns the gzip writer to the pool.
+func (w *gzipWriter) close() {
+	if !w.decided {
+		if w.code == 0 {
+			return // nothing written, net/http sends an empty 200
+		}
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz.Reset(nil)
+		w.pool.Put(w.gz)
+	}
+}
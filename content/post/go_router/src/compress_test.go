@@ -0,0 +1,148 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	long := strings.Repeat("hello gzip ", 200)
+	router := NewRouter()
+	router.Use(Compress(gzip.BestSpeed, 256))
+	router.Get("/long", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", "2200")
+		io.WriteString(w, long)
+	})
+	router.Get("/short", reply("short"))
+	router.Get("/png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, long)
+	})
+	router.Get("/encoded", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, long)
+	})
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/long", "br, gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Length") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("GET /long headers = %v", w.Header())
+	}
+	for _, accept := range []string{"*;q=0, gzip", "GZIP;q=0.5", "*", "gzip;q=0, gzip;q=1", "br;q=1, *;q=0.1"} {
+		if w := get("/long", accept); w.Header().Get("Content-Encoding") != "gzip" {
+			t.Errorf("GET /long with %q headers = %v, want it compressed", accept, w.Header())
+		}
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != long {
+		t.Errorf("GET /long decompressed to %d bytes, want %d", len(body), len(long))
+	}
+
+	tests := []struct {
+		path, accept string
+	}{
+		{"/long", ""},
+		{"/long", "gzip;q=0"},
+		{"/long", "gzip;q=0, *"},
+		{"/long", "*;q=0"},
+		{"/long", "br"},
+		{"/short", "gzip"},
+		{"/png", "gzip"},
+		{"/encoded", "gzip"},
+	}
+	for _, tt := range tests {
+		w := get(tt.path, tt.accept)
+		if w.Header().Get("Content-Encoding") == "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("GET %s with %q headers = %v, want it uncompressed", tt.path, tt.accept, w.Header())
+		}
+		if !strings.HasSuffix(long, w.Body.String()) && w.Body.String() != "short" {
+			t.Errorf("GET %s with %q body changed", tt.path, tt.accept)
+		}
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	router := NewRouter()
+	router.Use(Compress(gzip.DefaultCompression, 1024))
+	router.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("flushed %v with headers %v", w.Flushed, w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != "data: 1\n\n" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestCompressLevel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Compress(42, 0) did not panic")
+		}
+	}()
+	Compress(42, 0)
+}
+
+func TestCompressHijack(t *testing.T) {
+	router := NewRouter()
+	router.Use(Compress(gzip.BestSpeed, 0))
+	router.Get("/raw", func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker) // as websocket libraries do
+		if !ok {
+			t.Error("the writer of Compress is not a Hijacker")
+			return
+		}
+		conn, brw, err := hj.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 3\r\nConnection: close\r\n\r\nraw")
+		brw.Flush()
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/raw", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "raw" {
+		t.Errorf("GET /raw behind Compress = %d %q, want the raw answer", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// When returns a route middleware serving the route only to the requests
+// every predicate returns true for, the others get a 404 like an unmatched
+// path. Several routes can be registered for the same path and method with
+// When: they are tried in registration order, the first whose predicates all
+// pass serves the request, then the route registered without When, if any.
+// The predicates run for every request of the path, they must be cheap and
+// must not read the body.
+func When(fns ...func(r *http.Request) bool) middleware {
+	return func(h http.Handler) http.Handler {
+		return constraintOption(constrainedRoute{preds: fns}, h)
+	}
+}
+
+// routeOption is the handler returned by the route middlewares that set an
+// option of the route rather than wrap its handler: Consumes, When, Versions
+// and WithDeadline. Group.wrap finds it among the handlers the middlewares of
+// a route return, and applies the option to the route instead. Used as any
+// other middleware, in Group.Use say, it serves the handler it wraps under
+// its option alone.
+type routeOption struct {
+	route       constrainedRoute // without its handler
+	deadline    time.Duration
+	hasDeadline bool
+	serve       http.Handler // h under the option
+}
+
+func (opt *routeOption) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	opt.serve.ServeHTTP(w, r)
+}
+
+// constraintOption returns the routeOption constraining the route of h.
+func constraintOption(route constrainedRoute, h http.Handler) *routeOption {
+	served := route
+	served.h = h
+	return &routeOption{route: route, serve: &routeMux{routes: []constrainedRoute{served}}}
+}
+
+// applyRoute wraps h in the route middlewares mws, the first one outermost,
+// and returns the options set by the routeOption middlewares among them.
+func applyRoute(h http.Handler, mws []middleware) (http.Handler, routeOptions) {
+	var opts routeOptions
+	for i := len(mws) - 1; i >= 0; i-- {
+		switch wrapped := mws[i](h).(type) {
+		case *routeOption:
+			opts.route.types = concat(wrapped.route.types, opts.route.types)
+			opts.route.preds = concat(wrapped.route.preds, opts.route.preds)
+			opts.route.versions = concat(wrapped.route.versions, opts.route.versions)
+			if wrapped.hasDeadline && !opts.hasDeadline {
+				opts.deadline, opts.hasDeadline = wrapped.deadline, true // the last one wins
+			}
+		default:
+			h = wrapped
+		}
+	}
+	return h, opts
+}
+
+// concat returns a new slice of the values of a then b, nil when both are
+// nil since a nil constraint stands for any.
+func concat[T any](a, b []T) []T {
+	if a == nil && b == nil {
+		return nil
+	}
+	return slices.Concat(a, b)
+}
+
+// routeOptions are the options of a route, see applyRoute.
+type routeOptions struct {
+	route       constrainedRoute
+	deadline    time.Duration
+	hasDeadline bool
+}
+
+// routeMux serves the routes of a path and method registered with Consumes,
+// When or Versions. The routes with predicates are tried first, in
+// registration order, then the others. It is replaced, not modified, by a
+// registration.
+type routeMux struct {
+	routes      []constrainedRoute
+	defaultType string // see WithDefaultContentType
+}
+
+type constrainedRoute struct {
+	types    []string // consumed, nil for any
+	preds    []func(r *http.Request) bool
+	versions []string // served, nil for any
+	h        http.Handler
+}
+
+func (route constrainedRoute) constrained() bool {
+	return route.types != nil || route.preds != nil || route.versions != nil
+}
+
+// conflicts reports whether route and other cannot tell the requests apart:
+// neither has predicates, they consume a same type and serve a same version.
+func (route constrainedRoute) conflicts(other constrainedRoute) bool {
+	if len(route.preds) > 0 || len(other.preds) > 0 {
+		return false
+	}
+	return overlap(route.types, other.types) && overlap(route.versions, other.versions)
+}
+
+// overlap reports whether a and b share a value, nil standing for any.
+func overlap(a, b []string) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	for _, v := range b {
+		if slices.Contains(a, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// routesOf returns the routes served by h, a route without constraints for
+// a handler other than a routeMux.
+func routesOf(h http.Handler) []constrainedRoute {
+	if mux, ok := h.(*routeMux); ok {
+		return mux.routes
+	}
+	return []constrainedRoute{{h: h}}
+}
+
+// conflicting reports whether a route of h conflicts with one of old.
+func conflicting(old, h http.Handler) bool {
+	for _, route := range routesOf(h) {
+		if slices.ContainsFunc(routesOf(old), route.conflicts) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRoutes returns the handler of the routes of old and of h, the ones of
+// h replacing those of old they conflict with, see WithOverride.
+func withRoutes(old, h http.Handler, defaultType string) http.Handler {
+	added := routesOf(h)
+	merged := &routeMux{defaultType: defaultType}
+	for _, route := range routesOf(old) {
+		if !slices.ContainsFunc(added, route.conflicts) {
+			merged.routes = append(merged.routes, route)
+		}
+	}
+	merged.routes = append(merged.routes, added...)
+	if len(merged.routes) == 1 && !merged.routes[0].constrained() {
+		return merged.routes[0].h
+	}
+	return merged
+}
+
+func (mux *routeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mediaType := mux.defaultType
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType = baseMediaType(contentType)
+	}
+	version, _ := r.Context().Value(versionKey).(apiVersion)
+	var refusedTypes, refusedVersions []string // of the routes refusing r for them
+	versionServed := false
+	serve := func(route constrainedRoute) bool {
+		for _, pred := range route.preds {
+			if !pred(r) {
+				return false
+			}
+		}
+		if route.versions != nil && !slices.Contains(route.versions, version.version) {
+			refusedVersions = appendNew(refusedVersions, route.versions)
+			return false
+		}
+		versionServed = true
+		if route.types != nil && !allowedType(route.types, mediaType) {
+			refusedTypes = appendNew(refusedTypes, route.types)
+			return false
+		}
+		route.h.ServeHTTP(w, r)
+		return true
+	}
+	for _, withPreds := range []bool{true, false} {
+		for _, route := range mux.routes {
+			if (len(route.preds) > 0) == withPreds && serve(route) {
+				return
+			}
+		}
+	}
+
+	switch {
+	case refusedVersions != nil && !versionServed:
+		WriteError(w, r, &HTTPError{
+			Code:       version.unknown(),
+			Msg:        "supported versions: " + strings.Join(refusedVersions, ", "),
+			Extensions: map[string]any{"versions": refusedVersions},
+		})
+	case refusedTypes != nil:
+		WriteError(w, r, &HTTPError{
+			Code:       http.StatusUnsupportedMediaType,
+			Msg:        "supported types: " + strings.Join(refusedTypes, ", "),
+			Extensions: map[string]any{"types": refusedTypes},
+		})
+	default:
+		notFound(w, r)
+	}
+}
+
+// appendNew appends the values missing from list.
+func appendNew(list, values []string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWhen(t *testing.T) {
+	router := NewRouter()
+	header := func(name, value string) func(r *http.Request) bool {
+		return func(r *http.Request) bool { return r.Header.Get(name) == value }
+	}
+	query := func(name string) func(r *http.Request) bool {
+		return func(r *http.Request) bool { return r.URL.Query().Has(name) }
+	}
+	router.Get("/items/:id", replyID("default"))
+	router.Get("/items/:id", replyID("v2"), When(header("Api-Version", "2")))
+	router.Get("/items/:id", replyID("v2 preview"), When(header("Api-Version", "2"), query("preview")))
+	router.Get("/items/:id", replyID("preview"), When(query("preview")))
+
+	tests := []struct {
+		path, version, want string
+	}{
+		{"/items/1", "", "default 1"},
+		{"/items/2", "2", "v2 2"},
+		{"/items/3?preview", "2", "v2 3"}, // registered first, v2 wins
+		{"/items/4?preview", "", "preview 4"},
+		{"/items/5", "3", "default 5"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.version != "" {
+			r.Header.Set("Api-Version", tt.version)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != tt.want {
+			t.Errorf("GET %s, version %q answered %d %q, want %q", tt.path, tt.version, w.Code, w.Body.String(), tt.want)
+		}
+	}
+	if err := router.Get("/items/:id", replyID("again")); err == nil {
+		t.Error("registered a second route without constraints")
+	}
+}
+
+func TestWhenWithoutDefault(t *testing.T) {
+	router := NewRouter()
+	router.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "custom 404", http.StatusNotFound)
+	}))
+	beta := When(func(r *http.Request) bool { return r.Header.Get("X-Beta") != "" })
+	router.Post("/orders", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "beta json") }, beta, Consumes("application/json"))
+	router.Post("/orders", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "json") }, Consumes("application/json"))
+
+	tests := []struct {
+		beta        bool
+		contentType string
+		code        int
+		body        string
+	}{
+		{true, "application/json", http.StatusOK, "beta json"},
+		{false, "application/json", http.StatusOK, "json"},
+		{true, "text/plain", http.StatusUnsupportedMediaType, "supported types: application/json\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		r.Header.Set("Content-Type", tt.contentType)
+		if tt.beta {
+			r.Header.Set("X-Beta", "1")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("POST beta %v as %s answered %d %q, want %d %q", tt.beta, tt.contentType, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	router.Get("/beta", func(w http.ResponseWriter, r *http.Request) {}, beta)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/beta", nil))
+	if w.Code != http.StatusNotFound || w.Body.String() != "custom 404\n" {
+		t.Errorf("GET /beta refused by its predicate answered %d %q, want the NotFound handler", w.Code, w.Body.String())
+	}
+}
+
+func TestWhenPanic(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	router := NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {}, When(func(r *http.Request) bool {
+		panic("predicate failed")
+	}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "server error") {
+		t.Errorf("panicking predicate answered %d %q, want the 500 of the panic handler", w.Code, w.Body.String())
+	}
+}
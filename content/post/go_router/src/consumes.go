@@ -0,0 +1,37 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Consumes returns a route middleware answering a 415 to the requests whose
+// Content-Type, its params like charset ignored, is none of types. A type
+// like "text/*" matches every subtype. Several routes can be registered for
+// the same path and method with Consumes of different types, each request is
+// served by the first one consuming its Content-Type. A request without a
+// Content-Type has the type of WithDefaultContentType, and gets a 415
+// otherwise.
+func Consumes(types ...string) middleware {
+	if len(types) == 0 {
+		panic("router: Consumes without a media type")
+	}
+	consumed := make([]string, len(types))
+	for i, t := range types {
+		consumed[i] = baseMediaType(t)
+	}
+	return func(h http.Handler) http.Handler {
+		return constraintOption(constrainedRoute{types: consumed}, h)
+	}
+}
+
+// baseMediaType returns the lowercased media type of a Content-Type, without
+// its params.
+func baseMediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newConsumesRouter(opts ...Option) *Router {
+	router := NewRouter(opts...)
+	router.Handle("/orders/:id", http.MethodPost, replyID("json"), Consumes("application/json"))
+	router.Handle("/orders/:id", http.MethodPost, replyID("xml"), Consumes("application/xml", "text/xml"))
+	router.Post("/notes", replyID("text"), Consumes("text/*"))
+	return router
+}
+
+func TestConsumes(t *testing.T) {
+	router := newConsumesRouter()
+	tests := []struct {
+		path, contentType string
+		code              int
+		body              string
+	}{
+		{"/orders/1", "application/json", http.StatusOK, "json 1"},
+		{"/orders/2", "application/json; charset=utf-8", http.StatusOK, "json 2"},
+		{"/orders/3", "Application/XML", http.StatusOK, "xml 3"},
+		{"/orders/4", "text/xml;charset=iso-8859-1", http.StatusOK, "xml 4"},
+		{"/orders/5", "text/plain", http.StatusUnsupportedMediaType, "supported types: application/json, application/xml, text/xml\n"},
+		{"/orders/6", "", http.StatusUnsupportedMediaType, "supported types: application/json, application/xml, text/xml\n"},
+		{"/notes", "text/markdown", http.StatusOK, "text "},
+		{"/notes", "image/png", http.StatusUnsupportedMediaType, "supported types: text/*\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader("{}"))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("POST %s as %q answered %d %q, want %d %q", tt.path, tt.contentType, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/orders/7", nil)
+	r.Header.Set("Content-Type", "application/yaml")
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	var problem struct {
+		Status int
+		Types  []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || problem.Status != http.StatusUnsupportedMediaType || len(problem.Types) != 3 {
+		t.Errorf("415 problem = %q, %v", w.Body.String(), err)
+	}
+}
+
+func TestConsumesDefault(t *testing.T) {
+	router := newConsumesRouter(WithDefaultContentType("application/json"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/1", strings.NewReader("{}")))
+	if w.Code != http.StatusOK || w.Body.String() != "json 1" {
+		t.Errorf("POST without a Content-Type answered %d %q, want the default json", w.Code, w.Body.String())
+	}
+}
+
+func TestConsumesConflicts(t *testing.T) {
+	router := newConsumesRouter()
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	if err := router.Post("/orders/:id", h, Consumes("application/json")); err == nil {
+		t.Error("registered a second route consuming application/json")
+	}
+	if err := router.Post("/orders/:id", h); err == nil {
+		t.Error("registered a route consuming anything next to the Consumes ones")
+	}
+	if err := router.Post("/notes", h, Consumes("text/*")); err == nil {
+		t.Error("registered a second route consuming text/*")
+	}
+	router.Post("/plain", h)
+	if err := router.Post("/plain", h, Consumes("text/plain")); err == nil {
+		t.Error("registered a Consumes route next to one consuming anything")
+	}
+
+	router = NewRouter(WithOverride())
+	router.Post("/orders", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "old") }, Consumes("application/json"))
+	router.Post("/orders", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "xml") }, Consumes("application/xml"))
+	router.Post("/orders", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "new") }, Consumes("application/json"))
+	for contentType, want := range map[string]string{"application/json": "new", "application/xml": "xml"} {
+		r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Body.String() != want {
+			t.Errorf("overridden POST as %s answered %q, want %q", contentType, w.Body.String(), want)
+		}
+	}
+}
+
+func TestConsumesMiddleware(t *testing.T) {
+	router := NewRouter()
+	router.Use(Consumes("application/json"))
+	router.Post("/", func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Use(Consumes) answered %d, want a 415", w.Code)
+	}
+}
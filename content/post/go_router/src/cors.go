@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS middleware.
+type CORSOptions struct {
+	// Origins lists the allowed origins, such as "https://example.com".
+	// "*" allows any origin.
+	Origins []string
+	// AllowOrigin is called for the origins not listed in Origins.
+	AllowOrigin func(origin string) bool
+	// Headers lists the request headers a preflight request may ask for.
+	Headers []string
+	// MaxAge is how long a browser may cache a preflight answer.
+	MaxAge time.Duration
+	// Credentials allows cookies and authorization headers, it cannot be
+	// combined with the "*" origin.
+	Credentials bool
+}
+
+// CORS returns a middleware answering the CORS requests of the allowed
+// origins. A preflight request is answered with a 204 before reaching the
+// handlers, its Access-Control-Allow-Methods lists the methods of the
+// matched route. CORS panics when Credentials is combined with the "*"
+// origin, which browsers reject.
+func CORS(opts CORSOptions) middleware {
+	anyOrigin := false
+	origins := map[string]bool{}
+	for _, origin := range opts.Origins {
+		anyOrigin = anyOrigin || origin == "*"
+		origins[origin] = true
+	}
+	if anyOrigin && opts.Credentials {
+		panic("router: CORS cannot allow credentials for any origin")
+	}
+	allowed := func(origin string) bool {
+		return anyOrigin || origins[origin] || (opts.AllowOrigin != nil && opts.AllowOrigin(origin))
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			if !anyOrigin {
+				header.Add("Vary", "Origin")
+			}
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !allowed(origin) {
+				if preflight {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if opts.Credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			methods := AllowedMethods(r)
+			if !preflight || methods == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(opts.Headers) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(opts.Headers, ", "))
+			}
+			if opts.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	router := NewRouter()
+	router.Use(CORS(CORSOptions{
+		Origins:     []string{"https://app.example.com"},
+		AllowOrigin: func(origin string) bool { return strings.HasSuffix(origin, ".example.org") },
+		Headers:     []string{"Content-Type", "X-Token"},
+		MaxAge:      10 * time.Minute,
+		Credentials: true,
+	}))
+	router.Get("/books", reply("books"))
+	router.Post("/books", reply("created"))
+	router.HandleFunc("/custom", http.MethodOptions, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("preflight reached the OPTIONS handler")
+	})
+
+	request := func(method, path, origin string, preflight bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight {
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			r.Header.Set("Access-Control-Request-Headers", "content-type, x-token")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(http.MethodGet, "/books", "https://app.example.com", false)
+	if w.Body.String() != "books" || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("allowed origin: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	w = request(http.MethodGet, "/books", "https://api.example.org", false)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://api.example.org" {
+		t.Errorf("AllowOrigin callback: %v", w.Header())
+	}
+
+	w = request(http.MethodGet, "/books", "https://evil.example.net", false)
+	if w.Body.String() != "books" || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("disallowed origin: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	w = request(http.MethodOptions, "/books", "https://evil.example.net", true)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("disallowed preflight = %d, want 403", w.Code)
+	}
+
+	w = request(http.MethodOptions, "/books", "https://app.example.com", true)
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST",
+		"Access-Control-Allow-Headers": "Content-Type, X-Token",
+		"Access-Control-Max-Age":       "600",
+	}
+	if w.Code != http.StatusNoContent {
+		t.Errorf("preflight = %d, want 204", w.Code)
+	}
+	for name, value := range want {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("preflight %s = %q, want %q", name, got, value)
+		}
+	}
+
+	if w = request(http.MethodOptions, "/custom", "https://app.example.com", true); w.Code != http.StatusNoContent {
+		t.Errorf("preflight with an OPTIONS route = %d, want 204", w.Code)
+	}
+	if w = request(http.MethodOptions, "/nope", "https://app.example.com", true); w.Code != http.StatusNotFound {
+		t.Errorf("preflight of an unknown path = %d, want 404", w.Code)
+	}
+}
+
+func TestCORSCredentialsAnyOrigin(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("CORS allowed credentials for any origin")
+		}
+	}()
+	CORS(CORSOptions{Origins: []string{"*"}, Credentials: true})
+}
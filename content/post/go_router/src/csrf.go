@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// CSRFOptions configures the CSRF middleware.
+type CSRFOptions struct {
+	// Keys sign the tokens. The first signs the new tokens, the others are
+	// former keys still accepted, so a key can be rotated.
+	Keys [][]byte
+	// CookieName, HeaderName and FieldName name the cookie holding the
+	// token and the header or form field echoing it, "csrf_token",
+	// "X-CSRF-Token" and "csrf_token" by default.
+	CookieName string
+	HeaderName string
+	FieldName  string
+	// Path, SameSite and Secure set the cookie attributes, by default the
+	// cookie is sent for every path, with SameSite=Lax.
+	Path     string
+	SameSite http.SameSite
+	Secure   bool
+	// Exempt lists the routes not checked, written like for UseExcept:
+	// "POST /webhook", "/hooks/:id".
+	Exempt []string
+}
+
+// CSRF returns a middleware protecting from cross-site request forgery
+// with a signed double-submit cookie. The GET, HEAD, OPTIONS and TRACE
+// requests are let through and get a token cookie when they have none. The
+// other requests must send the token of their cookie back in the header or
+// the form field, or get a 403. The token is returned by CSRFToken, the
+// cookie is readable by scripts so they can set the header. Only a route
+// matched and listed in Exempt skips the check: used around the router or
+// in Pre, before routing, CSRF checks every unsafe request. CSRF panics
+// without a key.
+func CSRF(opts CSRFOptions) middleware {
+	if len(opts.Keys) == 0 {
+		panic("router: CSRF needs a key")
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "csrf_token"
+	}
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-CSRF-Token"
+	}
+	if opts.FieldName == "" {
+		opts.FieldName = "csrf_token"
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	exempt := routeSet(opts.Exempt)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var token string
+			key := -1
+			if cookie, err := r.Cookie(opts.CookieName); err == nil {
+				token, key = cookie.Value, verifyCSRF(opts.Keys, cookie.Value)
+			}
+
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				if key != 0 {
+					// missing, forged, or signed with a former key
+					token = signCSRF(opts.Keys[0], newCSRFValue(token, key))
+					http.SetCookie(w, &http.Cookie{
+						Name: opts.CookieName, Value: token, Path: opts.Path,
+						Secure: opts.Secure, SameSite: opts.SameSite,
+					})
+				}
+			default:
+				if pattern := RoutePattern(r); pattern != "" && (exempt[pattern] || exempt[r.Method+" "+pattern]) {
+					if key < 0 {
+						token = ""
+					}
+					break
+				}
+				sent := r.Header.Get(opts.HeaderName)
+				if sent == "" {
+					sent = r.PostFormValue(opts.FieldName)
+				}
+				if key < 0 || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey, token)))
+		})
+	}
+}
+
+// CSRFToken returns the token the forms and scripts must send back to pass
+// the CSRF middleware, or "".
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey).(string)
+	return token
+}
+
+// A token is "value.signature", both base64url encoded, where value is 32
+// random bytes and signature their HMAC-SHA256.
+func signCSRF(key, value []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(value)
+	return base64.RawURLEncoding.EncodeToString(value) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCSRF returns the index of the key token is signed with, or -1.
+func verifyCSRF(keys [][]byte, token string) int {
+	value, _, ok := strings.Cut(token, ".")
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if !ok || err != nil || len(b) != 32 {
+		return -1
+	}
+	for i, key := range keys {
+		if hmac.Equal([]byte(signCSRF(key, b)), []byte(token)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// newCSRFValue keeps the value of a token signed with a former key, and
+// draws a new one otherwise.
+func newCSRFValue(token string, key int) []byte {
+	if key > 0 {
+		value, _, _ := strings.Cut(token, ".")
+		b, _ := base64.RawURLEncoding.DecodeString(value)
+		return b
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
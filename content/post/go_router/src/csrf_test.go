@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	oldKey, key := []byte("old key"), []byte("new key")
+	router := NewRouter()
+	router.Use(CSRF(CSRFOptions{Keys: [][]byte{key, oldKey}, Exempt: []string{"POST /webhook"}}))
+	router.Get("/form", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	})
+	router.Post("/form", reply("posted"))
+	router.Post("/webhook", reply("hooked"))
+
+	// a GET is never blocked and issues the token
+	w := serve(router, http.MethodGet, "/form")
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Value != w.Body.String() {
+		t.Fatalf("GET /form = %d %q %v, want the token in the body and cookie", w.Code, w.Body.String(), cookies)
+	}
+	token := cookies[0].Value
+	if cookies[0].SameSite != http.SameSiteLaxMode || cookies[0].Path != "/" {
+		t.Errorf("cookie = %v, want SameSite=Lax and Path=/", cookies[0])
+	}
+
+	post := func(path, cookie, header, field string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		if field != "" {
+			form.Set("csrf_token", field)
+		}
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: cookie})
+		}
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	rotated := signCSRF(oldKey, make([]byte, 32))
+	forged := signCSRF([]byte("forged"), make([]byte, 32))
+	tests := []struct {
+		name, path, cookie, header, field string
+		code                              int
+	}{
+		{"header", "/form", token, token, "", http.StatusOK},
+		{"form field", "/form", token, "", token, http.StatusOK},
+		{"rotated key", "/form", rotated, rotated, "", http.StatusOK},
+		{"missing token", "/form", token, "", "", http.StatusForbidden},
+		{"missing cookie", "/form", "", token, "", http.StatusForbidden},
+		{"mismatch", "/form", token, rotated, "", http.StatusForbidden},
+		{"unknown key", "/form", forged, forged, "", http.StatusForbidden},
+		{"exempt", "/webhook", "", "", "", http.StatusOK},
+		{"no route", "/nope", "", "", "", http.StatusForbidden},
+		{"no route with a token", "/nope", token, token, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := post(tt.path, tt.cookie, tt.header, tt.field); w.Code != tt.code {
+			t.Errorf("%s: POST %s = %d, want %d", tt.name, tt.path, w.Code, tt.code)
+		}
+	}
+
+	// a GET with a rotated token gets it signed again with the current key
+	r := httptest.NewRequest(http.MethodGet, "/form", nil)
+	r.AddCookie(&http.Cookie{Name: "csrf_token", Value: rotated})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if want := signCSRF(key, make([]byte, 32)); w.Body.String() != want {
+		t.Errorf("GET /form with a rotated token = %q, want %q", w.Body.String(), want)
+	}
+	// a valid token is kept
+	r = httptest.NewRequest(http.MethodGet, "/form", nil)
+	r.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Body.String() != token || len(w.Result().Cookies()) != 0 {
+		t.Errorf("GET /form with a token = %q, want the same token and no cookie", w.Body.String())
+	}
+}
+
+func TestCSRFBeforeRouting(t *testing.T) {
+	opts := CSRFOptions{Keys: [][]byte{[]byte("key")}, Exempt: []string{"POST /webhook"}}
+	token := signCSRF(opts.Keys[0], make([]byte, 32))
+	routes := func(router *Router) {
+		router.Post("/form", reply("done"))
+		router.Post("/webhook", reply("hooked"))
+	}
+	wrapped := NewRouter()
+	routes(wrapped)
+	pre := NewRouter()
+	pre.Pre(CSRF(opts))
+	routes(pre)
+
+	for name, h := range map[string]http.Handler{"around the router": CSRF(opts)(wrapped), "in Pre": pre} {
+		for _, tt := range []struct {
+			path, token string
+			code        int
+		}{
+			{"/form", "", http.StatusForbidden},
+			{"/webhook", "", http.StatusForbidden}, // not routed yet, so not exempt
+			{"/form", token, http.StatusOK},
+		} {
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.token != "" {
+				r.AddCookie(&http.Cookie{Name: "csrf_token", Value: tt.token})
+				r.Header.Set("X-CSRF-Token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Errorf("%s: POST %s with token %q = %d, want %d", name, tt.path, tt.token, w.Code, tt.code)
+			}
+		}
+	}
+}
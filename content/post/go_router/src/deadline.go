@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// WithDeadline returns a route middleware giving the requests of the route
+// d to be answered, counted from when the route matched. It runs outside the
+// other middlewares of the route and of its groups, inside those of the
+// router, and answers like Timeout with a 503. The handler sees the deadline
+// in r.Context().Deadline(). A d of 0 or less exempts the route from the
+// deadline of WithDefaultDeadline.
+func WithDeadline(d time.Duration) middleware {
+	return func(h http.Handler) http.Handler {
+		return &routeOption{deadline: d, hasDeadline: true, serve: withDeadline(d)(h)}
+	}
+}
+
+// WithDefaultDeadline gives the routes registered without WithDeadline d to
+// be answered, see WithDeadline. The streaming routes, of SSE or websockets,
+// opt out with WithDeadline(0).
+func WithDefaultDeadline(d time.Duration) Option {
+	return func(router *Router) {
+		router.defaultDeadline = d
+	}
+}
+
+// withDeadline returns the middleware of a route with the deadline d, none
+// when d is 0 or less.
+func withDeadline(d time.Duration) middleware {
+	if d <= 0 {
+		return func(h http.Handler) http.Handler { return h }
+	}
+	return Timeout(d, 0)
+}
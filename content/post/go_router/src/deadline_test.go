@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWithDeadline(t *testing.T) {
+	router := NewRouter()
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			fmt.Fprint(w, "slow")
+		}
+	}, WithDeadline(100*time.Millisecond))
+	router.Get("/sibling", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("GET /sibling has a deadline")
+		}
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprint(w, "sibling")
+	})
+	router.Get("/deadline", func(w http.ResponseWriter, r *http.Request) {
+		when, ok := r.Context().Deadline()
+		fmt.Fprint(w, ok && time.Until(when) > 0 && time.Until(when) <= 100*time.Millisecond)
+	}, WithDeadline(100*time.Millisecond))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/slow", http.StatusServiceUnavailable, "Service Unavailable\n"},
+		{"/sibling", http.StatusOK, "sibling"},
+		{"/deadline", http.StatusOK, "true"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
+
+func TestWithDeadlineAfterRouting(t *testing.T) {
+	router := NewRouter()
+	router.Pre(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(150 * time.Millisecond)
+			h.ServeHTTP(w, r)
+		})
+	})
+	router.Get("/fast", reply("fast"), WithDeadline(100*time.Millisecond))
+	if w := serve(router, http.MethodGet, "/fast"); w.Code != http.StatusOK || w.Body.String() != "fast" {
+		t.Errorf("GET /fast after a slow Pre middleware = %d %q, want 200 fast", w.Code, w.Body.String())
+	}
+}
+
+func TestWithDefaultDeadline(t *testing.T) {
+	router := NewRouter(WithDefaultDeadline(50 * time.Millisecond))
+	wait := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(150 * time.Millisecond):
+			fmt.Fprint(w, "done")
+		}
+	}
+	router.Get("/default", wait)
+	router.Get("/longer", wait, WithDeadline(time.Second))
+	router.Get("/exempt", wait, WithDeadline(0))
+	api := router.Group("/api")
+	api.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Deadline(); !ok {
+				t.Error("the group middleware runs without the deadline")
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	api.Get("/default", wait)
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/default", http.StatusServiceUnavailable},
+		{"/longer", http.StatusOK},
+		{"/exempt", http.StatusOK},
+		{"/api/default", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+}
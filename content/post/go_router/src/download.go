@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ServeFileDownload serves the file name of fsys as an attachment saved as
+// downloadName, the base of name when empty. http.ServeContent answers the
+// Range, If-Range and conditional requests, and HEAD with the headers only.
+// The Content-Type is guessed from the extension of downloadName, or sniffed,
+// unless the handler set one. A missing file is answered by the NotFound
+// handler of the router and a directory with a 403.
+func ServeFileDownload(w http.ResponseWriter, r *http.Request, fsys fs.FS, name, downloadName string) {
+	name = strings.TrimPrefix(name, "/")
+	f, err := fsys.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || !fs.ValidPath(name) {
+			notFound(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if downloadName == "" {
+		downloadName = path.Base(name)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(downloadName))
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, downloadName, info.ModTime(), content)
+}
+
+// notFound answers r with the NotFound handler of the router serving it.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if h, ok := r.Context().Value(notFoundKey).(http.Handler); ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// contentDisposition returns an attachment Content-Disposition for name. A
+// name that is not plain ASCII is sent as an RFC 5987 filename*, after a
+// filename with the other characters replaced for the older clients.
+func contentDisposition(name string) string {
+	fallback := make([]byte, 0, len(name))
+	for _, c := range name {
+		if c < ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			c = '_'
+		}
+		fallback = append(fallback, byte(c))
+	}
+	header := `attachment; filename="` + string(fallback) + `"`
+	if string(fallback) == name {
+		return header
+	}
+	const hex = "0123456789ABCDEF"
+	encoded := make([]byte, 0, 3*len(name))
+	for _, c := range []byte(name) {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			encoded = append(encoded, c)
+		} else {
+			encoded = append(encoded, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return header + "; filename*=UTF-8''" + string(encoded)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var downloads = fstest.MapFS{
+	"reports/q1.csv": {Data: []byte("a,b\n1,2\n"), ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	"empty.txt":      {},
+	"notes":          {Data: []byte("0123456789")},
+}
+
+func TestServeFileDownload(t *testing.T) {
+	router := NewRouter()
+	router.NotFound(reply("no such file"))
+	router.Get("/files/*name", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("binary") {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		ServeFileDownload(w, r, downloads, Param(r, "name"), r.URL.Query().Get("as"))
+	})
+
+	tests := []struct {
+		method, path, rangeHeader string
+		code                      int
+		body, contentType, length string
+		disposition               string
+	}{
+		{http.MethodGet, "/files/reports/q1.csv", "", http.StatusOK, "a,b\n1,2\n", "text/csv; charset=utf-8", "8", `attachment; filename="q1.csv"`},
+		{http.MethodHead, "/files/reports/q1.csv", "", http.StatusOK, "", "text/csv; charset=utf-8", "8", `attachment; filename="q1.csv"`},
+		{http.MethodGet, "/files/reports/q1.csv?binary", "", http.StatusOK, "a,b\n1,2\n", "application/octet-stream", "8", `attachment; filename="q1.csv"`},
+		{http.MethodGet, "/files/empty.txt", "", http.StatusOK, "", "text/plain; charset=utf-8", "0", `attachment; filename="empty.txt"`},
+		{http.MethodGet, "/files/notes", "bytes=2-4", http.StatusPartialContent, "234", "text/plain; charset=utf-8", "3", `attachment; filename="notes"`},
+		{http.MethodGet, "/files/notes", "bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "invalid range: failed to overlap\n", "text/plain; charset=utf-8", "", `attachment; filename="notes"`},
+		{http.MethodGet, "/files/notes?as=rapport+été.txt", "", http.StatusOK, "0123456789", "text/plain; charset=utf-8", "10",
+			`attachment; filename="rapport _t_.txt"; filename*=UTF-8''rapport%20%C3%A9t%C3%A9.txt`},
+		{http.MethodGet, "/files/notes?as=a\"b.txt", "", http.StatusOK, "0123456789", "text/plain; charset=utf-8", "10",
+			`attachment; filename="a_b.txt"; filename*=UTF-8''a%22b.txt`},
+		{http.MethodGet, "/files/missing.csv", "", http.StatusOK, "no such file", "", "", ""},
+		{http.MethodGet, "/files/reports", "", http.StatusForbidden, "Forbidden\n", "text/plain; charset=utf-8", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.rangeHeader != "" {
+			r.Header.Set("Range", tt.rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("%s %s Content-Type = %q, want %q", tt.method, tt.path, w.Header().Get("Content-Type"), tt.contentType)
+		}
+		if tt.length != "" && w.Header().Get("Content-Length") != tt.length {
+			t.Errorf("%s %s Content-Length = %q, want %s", tt.method, tt.path, w.Header().Get("Content-Length"), tt.length)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+			t.Errorf("%s %s Content-Disposition = %s, want %s", tt.method, tt.path, got, tt.disposition)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/files/reports/q1.csv", nil)
+	r.Header.Set("If-Modified-Since", "Tue, 02 Jan 2024 03:04:05 GMT")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional download = %d, want 304", w.Code)
+	}
+}
+
+func TestServeFileDownloadMultiRange(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", "bytes=0-1,8-")
+	w := httptest.NewRecorder()
+	ServeFileDownload(w, r, downloads, "notes", "")
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("multi-range download = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	parts := multipart.NewReader(w.Body, params["boundary"])
+	var got []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		got = append(got, part.Header.Get("Content-Range")+" "+string(body))
+	}
+	if want := "bytes 0-1/10 01,bytes 8-9/10 89"; strings.Join(got, ",") != want {
+		t.Errorf("multi-range parts = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HandlerFuncE is a handler returning its error to the router rather than
+// answering it, see HandleE.
+type HandlerFuncE func(w http.ResponseWriter, r *http.Request) error
+
+// HTTPError is an error answered with its status code and message. It can be
+// returned as is, declared as a sentinel or wrapped: the error handler finds
+// it with errors.As. A client accepting JSON gets it as an RFC 7807 problem,
+// the other fields are its members.
+type HTTPError struct {
+	Code int
+	Msg  string // the detail member, the status text in plain text when empty
+
+	Type       string         // URI of the problem type, "about:blank" when empty
+	Title      string         // summary of the type, the status text for "about:blank"
+	Instance   string         // URI of this occurrence of the problem
+	Extensions map[string]any // more members, the fields above win over them
+}
+
+// NewHTTPError returns the error answered with code and detail.
+func NewHTTPError(code int, detail string) *HTTPError {
+	return &HTTPError{Code: code, Msg: detail}
+}
+
+// ErrNotFound is answered with a 404, it is the error of the router 404s
+// with WithProblemDetails.
+var ErrNotFound = NewHTTPError(http.StatusNotFound, "")
+
+func (e *HTTPError) Error() string {
+	return strconv.Itoa(e.Code) + " " + e.message()
+}
+
+func (e *HTTPError) message() string {
+	if e.Msg == "" {
+		return http.StatusText(e.Code)
+	}
+	return e.Msg
+}
+
+// HandleE registers h for the path and method like Handle. An error returned
+// by h is passed to the router ErrorHandler, unless the response header was
+// sent already: the error can only be logged then.
+func (g *Group) HandleE(path, method string, h HandlerFuncE, mws ...middleware) error {
+	router := g.router
+	return g.Handle(path, method, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw, rec := newResponseRecorder(w)
+		err := h(rw, r)
+		switch {
+		case err == nil:
+		case rec.status != 0:
+			logError(rw, r, err, "after the response was sent")
+		default:
+			router.routes.Load().onError(rw, r, err)
+		}
+	}), mws...)
+}
+
+// ErrorHandler replaces the handler of the errors returned by the HandleE
+// handlers. The default one answers an HTTPError with WriteError, and other
+// errors with a 500 after logging them.
+func (router *Router) ErrorHandler(h func(w http.ResponseWriter, r *http.Request, err error)) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		t.onError = h
+		return nil
+	})
+}
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		logError(w, r, err, "")
+		httpErr = &HTTPError{Code: http.StatusInternalServerError, Msg: "server error"}
+	}
+	WriteError(w, r, httpErr)
+}
+
+// WriteError answers r with err as an application/problem+json document
+// when the Accept header of r asks for JSON, and with its message in plain
+// text otherwise, like http.Error.
+func WriteError(w http.ResponseWriter, r *http.Request, err *HTTPError) {
+	w.Header().Add("Vary", "Accept")
+	if !acceptsJSON(r.Header.Get("Accept")) {
+		http.Error(w, err.message(), err.Code)
+		return
+	}
+	problemType, title := err.Type, err.Title
+	if problemType == "" {
+		problemType = "about:blank"
+	}
+	if title == "" && problemType == "about:blank" {
+		title = http.StatusText(err.Code)
+	}
+	problem := make(map[string]any, len(err.Extensions)+5)
+	for name, value := range err.Extensions {
+		problem[name] = value
+	}
+	problem["type"], problem["status"] = problemType, err.Code
+	for name, value := range map[string]string{"title": title, "detail": err.Msg, "instance": err.Instance} {
+		if value != "" {
+			problem[name] = value
+		} else {
+			delete(problem, name)
+		}
+	}
+	body, jsonErr := json.Marshal(problem)
+	if jsonErr != nil {
+		logError(w, r, jsonErr, "encoding the problem")
+		http.Error(w, err.message(), err.Code)
+		return
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Code)
+	w.Write(append(body, '\n'))
+}
+
+// acceptsJSON reports whether an Accept header lists application/json,
+// application/problem+json or application/*. A bare */* does not count, a
+// browser sending it gets plain text.
+func acceptsJSON(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json", "application/problem+json", "application/*":
+			if acceptable(params) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errorHandler answers every request with err.
+func errorHandler(err *HTTPError) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, err)
+	})
+}
+
+func logError(w http.ResponseWriter, r *http.Request, err error, when string) {
+	id := ""
+	if rid := requestID(w, r); rid != "" {
+		id = " [" + rid + "]"
+	}
+	if when != "" {
+		when = " " + when
+	}
+	log.Printf("router: error serving %s %s%s%s: %v", r.Method, r.URL.Path, id, when, err)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+var errNoBook = &HTTPError{Code: http.StatusNotFound, Msg: "no such book"}
+
+func TestHandleE(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	router := NewRouter()
+	router.HandleE("/plain", http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("database down")
+	})
+	router.HandleE("/missing", http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+		return &HTTPError{Code: http.StatusNotFound}
+	})
+	router.HandleE("/book/:id", http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("book %s: %w", Param(r, "id"), errNoBook)
+	})
+	router.HandleE("/ok", http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("ok"))
+		return nil
+	})
+	router.HandleE("/partial", http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("half"))
+		return errors.New("stream broken")
+	})
+
+	tests := []struct {
+		path, body, log string
+		code            int
+	}{
+		{"/plain", "server error\n", "error serving GET /plain: database down", http.StatusInternalServerError},
+		{"/missing", "Not Found\n", "", http.StatusNotFound},
+		{"/book/42", "no such book\n", "", http.StatusNotFound},
+		{"/ok", "ok", "", http.StatusOK},
+		{"/partial", "half", "error serving GET /partial after the response was sent: stream broken", http.StatusOK},
+	}
+	for _, tt := range tests {
+		logged.Reset()
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if !strings.Contains(logged.String(), tt.log) || (tt.log == "") != (logged.Len() == 0) {
+			t.Errorf("GET %s logged %q, want %q", tt.path, logged.String(), tt.log)
+		}
+	}
+
+	var got error
+	router.ErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		w.WriteHeader(http.StatusTeapot)
+	})
+	if w := serve(router, http.MethodGet, "/book/7"); w.Code != http.StatusTeapot || !errors.Is(got, errNoBook) {
+		t.Errorf("GET /book/7 with an ErrorHandler = %d, handler got %v", w.Code, got)
+	}
+	if errNoBook.Error() != "404 no such book" {
+		t.Errorf("HTTPError.Error() = %q", errNoBook.Error())
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	err := NewHTTPError(http.StatusForbidden, "not enough credit")
+	err.Type = "https://example.com/probs/out-of-credit"
+	err.Title = "You do not have enough credit."
+	err.Instance = "/account/12345/msgs/abc"
+	err.Extensions = map[string]any{"balance": 30, "accounts": []string{"/account/12345"}, "status": 200}
+
+	tests := []struct {
+		accept, contentType string
+	}{
+		{"", "text/plain; charset=utf-8"},
+		{"*/*", "text/plain; charset=utf-8"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/plain; charset=utf-8"},
+		{"application/json", "application/problem+json"},
+		{"text/plain;q=0.5, application/problem+json", "application/problem+json"},
+		{"application/*", "application/problem+json"},
+		{"application/json;q=0", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		WriteError(w, r, err)
+		if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != tt.contentType || w.Header().Get("Vary") != "Accept" {
+			t.Errorf("Accept %q answered %d %v", tt.accept, w.Code, w.Header())
+		}
+		if tt.contentType != "application/problem+json" {
+			if w.Body.String() != "not enough credit\n" {
+				t.Errorf("Accept %q body = %q", tt.accept, w.Body.String())
+			}
+			continue
+		}
+		var problem map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("Accept %q body %q: %v", tt.accept, w.Body.String(), err)
+		}
+		want := map[string]any{
+			"type":     "https://example.com/probs/out-of-credit",
+			"title":    "You do not have enough credit.",
+			"status":   float64(403), // the extension does not override it
+			"detail":   "not enough credit",
+			"instance": "/account/12345/msgs/abc",
+			"balance":  float64(30),
+			"accounts": []any{"/account/12345"},
+		}
+		if fmt.Sprint(problem) != fmt.Sprint(want) {
+			t.Errorf("Accept %q problem = %v, want %v", tt.accept, problem, want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	WriteError(w, r, &HTTPError{Code: http.StatusNotFound})
+	if got := w.Body.String(); got != `{"status":404,"title":"Not Found","type":"about:blank"}`+"\n" {
+		t.Errorf("problem of a bare HTTPError = %s", got)
+	}
+}
+
+func TestHandleEProblem(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	router := NewRouter()
+	router.HandleE("/book/:id", http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("book %s: %w", Param(r, "id"), ErrNotFound)
+	})
+	router.HandleE("/boom", http.MethodGet, func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("database down")
+	})
+
+	tests := []struct {
+		path, body string
+	}{
+		{"/book/42", `{"status":404,"title":"Not Found","type":"about:blank"}` + "\n"},
+		{"/boom", `{"detail":"server error","status":500,"title":"Internal Server Error","type":"about:blank"}` + "\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Body.String() != tt.body {
+			t.Errorf("GET %s = %s, want %s", tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestWithProblemDetails(t *testing.T) {
+	router := NewRouter(WithProblemDetails())
+	router.Get("/book", reply("book"))
+
+	tests := []struct {
+		method, path, accept, body string
+		code                       int
+	}{
+		{http.MethodGet, "/nope", "application/json", `{"status":404,"title":"Not Found","type":"about:blank"}` + "\n", http.StatusNotFound},
+		{http.MethodGet, "/nope", "", "Not Found\n", http.StatusNotFound},
+		{http.MethodPost, "/book", "application/json", `{"status":405,"title":"Method Not Allowed","type":"about:blank"}` + "\n", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("%s %s Accept %q = %d %q, want %d %q", tt.method, tt.path, tt.accept, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+	if w := serve(router, http.MethodPost, "/book"); w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("405 Allow = %q", w.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ETag returns a middleware giving the 200 answers to GET requests a strong
+// ETag, the hash of their body, and answering 304 Not Modified with no body
+// to the requests whose If-None-Match lists it. The body is buffered until
+// the handler returns, the responses over maxSize bytes, flushed, or with an
+// ETag or a Content-Range already set are sent as is. The HEAD requests are
+// let through, their handler does not write the body to hash.
+func ETag(maxSize int) middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				h.ServeHTTP(w, r)
+				return
+			}
+			ew := &etagWriter{ResponseWriter: w, maxSize: maxSize}
+			h.ServeHTTP(ew, r)
+			ew.finish(r)
+		})
+	}
+}
+
+// etagWriter buffers a response until it is complete or found ineligible.
+type etagWriter struct {
+	http.ResponseWriter
+	maxSize int
+
+	code    int
+	buf     bytes.Buffer
+	through bool // the response is sent as is
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if code < 200 || w.through {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.through {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !w.eligible() || w.buf.Len()+len(b) > w.maxSize {
+		if err := w.passThrough(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+func (w *etagWriter) Flush() {
+	if !w.through {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		w.passThrough()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter.
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *etagWriter) eligible() bool {
+	header := w.Header()
+	return w.code == http.StatusOK && header.Get("ETag") == "" && header.Get("Content-Range") == ""
+}
+
+// passThrough sends the header and the buffered body, the rest of the
+// response is written directly.
+func (w *etagWriter) passThrough() error {
+	w.through = true
+	w.ResponseWriter.WriteHeader(w.code)
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *etagWriter) finish(r *http.Request) {
+	if w.through {
+		return
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !w.eligible() {
+		w.passThrough()
+		return
+	}
+	sum := sha256.Sum256(w.buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	header := w.Header()
+	header.Set("ETag", etag)
+	if noneMatch(r.Header.Get("If-None-Match"), etag) {
+		w.passThrough()
+		return
+	}
+	delete(header, "Content-Type")
+	delete(header, "Content-Length")
+	w.ResponseWriter.WriteHeader(http.StatusNotModified)
+}
+
+// noneMatch reports whether an If-None-Match header misses etag, comparing
+// the tags weakly.
+func noneMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return true
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return false
+		}
+	}
+	return true
+}
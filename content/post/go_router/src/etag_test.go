@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETag(t *testing.T) {
+	big := bytes.Repeat([]byte("x"), 5<<20)
+	rec := httptest.NewRecorder()
+	streamed := false
+
+	router := NewRouter()
+	router.Use(ETag(1 << 20))
+	router.HandleMethods("/page", []string{"GET", "POST"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("page"))
+	}))
+	router.Get("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(big[:len(big)/2])
+		streamed = rec.Body.Len() > 0
+		w.Write(big[len(big)/2:])
+	})
+	router.Get("/tagged", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("tagged"))
+	})
+
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/page", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "page" || len(etag) != 34 {
+		t.Fatalf("GET /page = %d %q ETag %q, want 200 with an ETag", w.Code, w.Body.String(), etag)
+	}
+	for _, inm := range []string{etag, `"other", W/` + etag, "*"} {
+		w = get("/page", inm)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s = %d %q, want an empty 304", inm, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Cache-Control") != "max-age=60" || w.Header().Get("Content-Type") != "" {
+			t.Errorf("If-None-Match %s headers = %v, want Cache-Control only", inm, w.Header())
+		}
+	}
+	if w = get("/page", `"other"`); w.Code != http.StatusOK || w.Body.String() != "page" {
+		t.Errorf("If-None-Match other = %d %q, want 200", w.Code, w.Body.String())
+	}
+
+	if w = serve(router, http.MethodPost, "/page"); w.Header().Get("ETag") != "" {
+		t.Errorf("POST /page ETag = %q, want none", w.Header().Get("ETag"))
+	}
+	if w = get("/tagged", `"v1"`); w.Code != http.StatusOK || w.Header().Get("ETag") != `"v1"` {
+		t.Errorf("GET /tagged = %d ETag %q, want the handler answer", w.Code, w.Header().Get("ETag"))
+	}
+
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/big", nil))
+	if !streamed || rec.Body.Len() != len(big) || rec.Header().Get("ETag") != "" {
+		t.Errorf("GET /big streamed %v, %d bytes, ETag %q, want 5MB sent as is", streamed, rec.Body.Len(), rec.Header().Get("ETag"))
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/9op/gorouter
 
-go 1.16
+go 1.24
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Group registers routes under a shared path prefix, which may contain params.
+type Group struct {
+	router      *Router
+	prefix      string
+	parent      *Group
+	middlewares []middleware // guarded by router.mu
+}
+
+func (g *Group) Group(prefix string) *Group {
+	return &Group{router: g.router, prefix: g.path(prefix), parent: g}
+}
+
+// Use adds m to the middlewares of the routes registered afterwards through
+// g or its sub-groups, the routes already registered are left unchanged. The
+// group middlewares run inside the router ones and outside the route ones,
+// the first added runs outermost.
+func (g *Group) Use(m middleware) {
+	g.router.mu.Lock()
+	defer g.router.mu.Unlock()
+	g.middlewares = append(g.middlewares, m)
+}
+
+// wrap wraps h in the route middlewares mws, then in the current middlewares
+// of g and of its parents. The routeOption handlers returned by the
+// Consumes, When, Versions and WithDeadline middlewares of mws set the
+// options of the route instead: its constraints run first, in a routeMux the
+// other routes of the path and method join, then its deadline.
+func (g *Group) wrap(h http.Handler, mws []middleware) http.Handler {
+	g.router.mu.Lock()
+	defer g.router.mu.Unlock()
+	h, opts := applyRoute(h, mws)
+	for group := g; group != nil; group = group.parent {
+		h = chain(h, group.middlewares)
+	}
+	if !opts.hasDeadline {
+		opts.deadline = g.router.defaultDeadline
+	}
+	h = withDeadline(opts.deadline)(h)
+	if route := opts.route; route.constrained() {
+		route.h = h
+		h = &routeMux{routes: []constrainedRoute{route}, defaultType: g.router.defaultContentType}
+	}
+	return h
+}
+
+func (g *Group) path(path string) string {
+	if g.prefix == "" {
+		return path
+	}
+	return strings.TrimSuffix(g.prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Handle registers h for the path and method. The route is only added once
+// the whole path is valid and does not conflict with an existing route, an
+// error is returned otherwise. The middlewares mws wrap h for this route
+// only, inside the router middlewares, the first one runs outermost.
+func (g *Group) Handle(path, method string, h http.Handler, mws ...middleware) error {
+	return g.router.handle(g.path(path), []string{method}, g.wrap(h, mws))
+}
+
+func (g *Group) HandleFunc(path, method string, f func(http.ResponseWriter, *http.Request), mws ...middleware) error {
+	return g.Handle(path, method, http.HandlerFunc(f), mws...)
+}
+
+// HandleMethods registers h for every method listed, or none of them when one
+// is already registered on path.
+func (g *Group) HandleMethods(path string, methods []string, h http.Handler, mws ...middleware) error {
+	return g.router.handle(g.path(path), methods, g.wrap(h, mws))
+}
+
+// MustHandle is like Handle but panics when the route is invalid.
+func (g *Group) MustHandle(path, method string, h http.Handler, mws ...middleware) {
+	if err := g.Handle(path, method, h, mws...); err != nil {
+		panic(err)
+	}
+}
+
+// chain wraps h in mws, the first one runs outermost.
+func chain(h http.Handler, mws []middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+func (g *Group) Get(path string, h http.HandlerFunc, mws ...middleware) error {
+	return g.Handle(path, http.MethodGet, h, mws...)
+}
+
+func (g *Group) Head(path string, h http.HandlerFunc, mws ...middleware) error {
+	return g.Handle(path, http.MethodHead, h, mws...)
+}
+
+func (g *Group) Post(path string, h http.HandlerFunc, mws ...middleware) error {
+	return g.Handle(path, http.MethodPost, h, mws...)
+}
+
+func (g *Group) Put(path string, h http.HandlerFunc, mws ...middleware) error {
+	return g.Handle(path, http.MethodPut, h, mws...)
+}
+
+func (g *Group) Patch(path string, h http.HandlerFunc, mws ...middleware) error {
+	return g.Handle(path, http.MethodPatch, h, mws...)
+}
+
+func (g *Group) Delete(path string, h http.HandlerFunc, mws ...middleware) error {
+	return g.Handle(path, http.MethodDelete, h, mws...)
+}
+
+var standardMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// Any registers h for the nine standard HTTP methods.
+func (g *Group) Any(path string, h http.HandlerFunc, mws ...middleware) error {
+	return g.HandleMethods(path, standardMethods, h, mws...)
+}
+
+// Mount serves every request under prefix with sub, which sees the path
+// remainder after the prefix and the vars captured by the prefix. The prefix
+// belongs to sub: routes cannot be registered under it on this router.
+func (g *Group) Mount(prefix string, sub *Router) error {
+	g.router.mu.Lock()
+	defer g.router.mu.Unlock()
+
+	prefix = g.path(prefix)
+	segments, err := g.router.parse(prefix)
+	if err != nil {
+		return g.router.reject(err)
+	}
+	return g.router.reject(g.router.update(func(t *table) error {
+		existing, err := t.trie.find(segments)
+		if err != nil {
+			return err
+		}
+		if existing != nil && !existing.empty() {
+			return fmt.Errorf("router: cannot mount on %s, routes are already registered under it", prefix)
+		}
+
+		t.trie = clonePath(t.trie, segments)
+		node := t.trie.append(segments)
+		node.pattern = prefix
+		node.mount = sub
+		node.depth = len(segments)
+		return nil
+	}))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	router := NewRouter()
+	api := router.Group("/api/v1")
+	api.Get("/home", reply("home"))
+	api.Get("/about", reply("about"))
+
+	tenant := api.Group("/tenants/:tid:^[0-9]+$")
+	tenant.Get("/users/:uid:^[a-z]+$", func(w http.ResponseWriter, r *http.Request) {
+		vars := Vars(r)
+		fmt.Fprintf(w, "%s %s", vars["tid"], vars["uid"])
+	})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/api/v1/home", http.StatusOK, "home"},
+		{"/api/v1/about", http.StatusOK, "about"},
+		{"/api/v1/tenants/7/users/bob", http.StatusOK, "7 bob"},
+		{"/home", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	// groups share the router's trie and its conflict detection
+	if err := router.Get("/api/v1/home", reply("home")); err == nil {
+		t.Error("expected a conflict with the group route")
+	}
+}
+
+func TestMount(t *testing.T) {
+	var trace []string
+	tag := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace = append(trace, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		vars := Vars(r)
+		fmt.Fprintf(w, "%s %s %s", r.URL.Path, vars["tid"], vars["id"])
+	}
+
+	users := NewRouter()
+	users.Get("/", echo)
+	users.Get("/:id:^[0-9]+$", echo)
+	users.Use(tag("users"))
+
+	admin := NewRouter()
+	admin.Get("/stats", echo)
+	admin.Mount("/tenants/:tid:^[0-9]+$/users", users)
+	admin.Use(tag("admin"))
+
+	root := NewRouter()
+	root.Get("/home", reply("home"))
+	if err := root.Mount("/admin", admin); err != nil {
+		t.Fatalf("Mount = %v", err)
+	}
+	root.Use(tag("root"))
+
+	tests := []struct {
+		path  string
+		code  int
+		body  string
+		trace string
+	}{
+		{"/home", http.StatusOK, "home", "[root]"},
+		{"/admin/stats", http.StatusOK, "/stats  ", "[root admin]"},
+		{"/admin/tenants/7/users", http.StatusOK, "/ 7 ", "[root admin users]"},
+		{"/admin/tenants/7/users/42", http.StatusOK, "/42 7 42", "[root admin users]"},
+		{"/admin/tenants/7/users/bob", http.StatusNotFound, "404 page not found\n", "[root admin users]"},
+		{"/admin/nope", http.StatusNotFound, "404 page not found\n", "[root admin]"},
+	}
+	for _, tt := range tests {
+		trace = nil
+		w := serve(root, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if got := fmt.Sprint(trace); got != tt.trace {
+			t.Errorf("GET %s middlewares = %s, want %s", tt.path, got, tt.trace)
+		}
+	}
+
+	if err := root.Get("/admin/other", reply("other")); err == nil {
+		t.Error("expected an error registering under a mounted prefix")
+	}
+	if err := root.Mount("/home", admin); err == nil {
+		t.Error("expected an error mounting on a registered route")
+	}
+}
+
+func TestHandleMethods(t *testing.T) {
+	router := NewRouter()
+	if err := router.HandleMethods("/book", []string{"get", " Head "}, reply("book")); err != nil {
+		t.Fatalf("HandleMethods = %v", err)
+	}
+	router.Any("/echo", reply("echo"))
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		if w := serve(router, method, "/book"); w.Code != http.StatusOK {
+			t.Errorf("%s /book = %d, want %d", method, w.Code, http.StatusOK)
+		}
+	}
+	for _, method := range standardMethods {
+		if w := serve(router, method, "/echo"); w.Code != http.StatusOK {
+			t.Errorf("%s /echo = %d, want %d", method, w.Code, http.StatusOK)
+		}
+	}
+
+	if err := router.HandleMethods("/book", nil, reply("none")); err == nil {
+		t.Error("HandleMethods with no method succeeded")
+	}
+	// GET was registered by the first call, POST must not be added either
+	if err := router.HandleMethods("/book", []string{"POST", "GET"}, reply("dup")); err == nil {
+		t.Error("HandleMethods with a registered method succeeded")
+	}
+	if w := serve(router, http.MethodPost, "/book"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /book = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandleFuncAndMiddlewareFunc(t *testing.T) {
+	banner := func(h http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "> ")
+			h.ServeHTTP(w, r)
+		}
+	}
+
+	router := NewRouter()
+	router.HandleFunc("/home", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "home")
+	})
+	router.Use(MiddlewareFunc(banner))
+
+	if w := serve(router, http.MethodGet, "/home"); w.Body.String() != "> home" {
+		t.Errorf("GET /home = %q, want %q", w.Body.String(), "> home")
+	}
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	var trace []string
+	tag := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace = append(trace, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	boom := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "handler")
+	}
+
+	router := NewRouter()
+	router.Use(tag("global"))
+	router.Get("/admin", handler, tag("auth"), tag("audit"))
+	router.Get("/home", handler)
+	router.Get("/boom", handler, boom)
+	router.PanicHandler(func(w http.ResponseWriter, r *http.Request, err any) {
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+	})
+
+	tests := []struct {
+		path  string
+		trace string
+	}{
+		{"/admin", "[global auth audit handler]"},
+		{"/home", "[global handler]"},
+	}
+	for _, tt := range tests {
+		trace = nil
+		serve(router, http.MethodGet, tt.path)
+		if got := fmt.Sprint(trace); got != tt.trace {
+			t.Errorf("GET %s trace = %s, want %s", tt.path, got, tt.trace)
+		}
+	}
+
+	if w := serve(router, http.MethodGet, "/boom"); w.Code != http.StatusInternalServerError || w.Body.String() != "boom\n" {
+		t.Errorf("GET /boom = %d %q, want the panic handler 500", w.Code, w.Body.String())
+	}
+}
+
+func TestGroupMiddleware(t *testing.T) {
+	var trace []string
+	tag := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace = append(trace, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "handler")
+	}
+
+	router := NewRouter()
+	router.Use(tag("root"))
+	api := router.Group("/api")
+	admin := api.Group("/admin")
+	api.Get("/early", handler)
+	api.Use(tag("auth"))
+	api.Use(tag("audit"))
+	api.Get("/users", handler)
+	api.Get("/upload", handler, tag("limit"))
+	admin.Get("/stats", handler)
+	admin.Use(tag("admin"))
+	admin.Get("/users", handler)
+	router.Get("/home", handler)
+
+	tests := []struct {
+		path  string
+		trace string
+	}{
+		{"/home", "[root handler]"},
+		{"/api/early", "[root handler]"}, // registered before api.Use
+		{"/api/users", "[root auth audit handler]"},
+		{"/api/upload", "[root auth audit limit handler]"},
+		{"/api/admin/stats", "[root auth audit handler]"},
+		{"/api/admin/users", "[root auth audit admin handler]"},
+	}
+	for _, tt := range tests {
+		trace = nil
+		serve(router, http.MethodGet, tt.path)
+		if got := fmt.Sprint(trace); got != tt.trace {
+			t.Errorf("GET %s trace = %s, want %s", tt.path, got, tt.trace)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "net/http"
+
+// enableH2C turns on the unencrypted HTTP/2 of server, next to the protocols
+// it already serves, HTTP/1 and HTTP/2 over TLS by default.
+func enableH2C(server *http.Server) {
+	protocols := new(http.Protocols)
+	if server.Protocols != nil {
+		*protocols = *server.Protocols
+	} else {
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	protocols.SetUnencryptedHTTP2(true)
+	server.Protocols = protocols
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveH2C serves router with h2c enabled until the end of the test, it
+// returns the address served.
+func serveH2C(t *testing.T, router *Router) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{}
+	served := make(chan error, 1)
+	go func() { served <- router.ServeListener(l, ServeOptions{EnableH2C: true, Server: server}) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; err != nil {
+			t.Errorf("Serve = %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func h2cClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
+
+func newH2CRouter() *Router {
+	router := NewRouter()
+	router.Get("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, Param(r, "id")+" "+r.Proto)
+	})
+	return router
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	addr := serveH2C(t, newH2CRouter())
+	for _, tt := range []struct {
+		client *http.Client
+		want   string
+	}{
+		{h2cClient(), "42 HTTP/2.0"},
+		{http.DefaultClient, "42 HTTP/1.1"},
+	} {
+		res, err := tt.client.Get("http://" + addr + "/users/42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("GET /users/42 = %q, want %q", body, tt.want)
+		}
+	}
+}
+
+func TestH2CEventStream(t *testing.T) {
+	read := make(chan struct{})
+	router := NewRouter()
+	router.Get("/events/:topic", func(w http.ResponseWriter, r *http.Request) {
+		events, err := SSE(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer events.Close()
+		events.Send("", "1", Param(r, "topic"))
+		select { // the client must get the event before the handler returns
+		case <-read:
+		case <-time.After(5 * time.Second):
+		}
+		events.Send("", "2", "bye")
+	})
+	addr := serveH2C(t, router)
+
+	res, err := h2cClient().Get("http://" + addr + "/events/news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.ProtoMajor != 2 || res.Header.Get("Connection") != "" {
+		t.Errorf("answered over %s with Connection %q", res.Proto, res.Header.Get("Connection"))
+	}
+	br := bufio.NewReader(res.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read %q: %v", lines, err)
+		}
+		lines = append(lines, line)
+	}
+	close(read)
+	if got := strings.Join(lines, ""); got != "id: 1\ndata: news\n\n" {
+		t.Errorf("first event = %q", got)
+	}
+	if rest, _ := io.ReadAll(br); string(rest) != "id: 2\ndata: bye\n\n" {
+		t.Errorf("second event = %q", rest)
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	router := NewRouter()
+	router.Post("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, r.Proto+" "+string(body))
+	})
+	addr := serveH2C(t, router)
+	for _, body := range []string{"", "hi"} {
+		req, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/echo", strings.NewReader(body))
+		req.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+		req.Header.Set("Upgrade", "h2c")
+		req.Header.Set("HTTP2-Settings", "AAMAAABkAAQAAP__")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || string(got) != "HTTP/1.1 "+body {
+			t.Errorf("upgrade with body %q answered %d %q, want it served over HTTP/1.1", body, res.StatusCode, got)
+		}
+	}
+}
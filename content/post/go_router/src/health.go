@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check is a health check of a dependency, like a database ping.
+type Check struct {
+	Name string
+	// Check reports the dependency healthy by returning nil. It should stop
+	// when ctx is done, the check fails with the context error anyway.
+	Check func(ctx context.Context) error
+	// Timeout bounds the check, 2 seconds by default.
+	Timeout time.Duration
+	// Cache reuses the result of the check for that long, so a storm of
+	// health checks does not reach a slow dependency.
+	Cache time.Duration
+}
+
+// CheckResult is the result of a check in the health summaries.
+type CheckResult struct {
+	Status string `json:"status"` // "ok" or "fail"
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthSummary is the JSON body of the health endpoints.
+type HealthSummary struct {
+	Status string                 `json:"status"` // "ok" or "fail"
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// errDraining fails the readiness of a router shutting down.
+var errDraining = errors.New("shutting down")
+
+// Health registers a GET endpoint on path running checks concurrently. It
+// answers a HealthSummary with a 200 when they all pass, and with a 503
+// naming the ones failing otherwise.
+func (g *Group) Health(path string, checks ...Check) error {
+	return g.health(path, false, checks)
+}
+
+// Ready registers a readiness endpoint, like Health, with the checks of the
+// dependencies the router needs to serve, like a database. It also fails,
+// with a "draining" check, once ListenAndServe of the router is shutting it
+// down, so that the load balancers stop sending it requests.
+func (g *Group) Ready(path string, checks ...Check) error {
+	return g.health(path, true, checks)
+}
+
+// Live registers a liveness endpoint, like Health. It should check only the
+// process itself, often with no check at all: a failing liveness gets the
+// process restarted, which a database down does not cure.
+func (g *Group) Live(path string, checks ...Check) error {
+	return g.health(path, false, checks)
+}
+
+func (g *Group) health(path string, ready bool, checks []Check) error {
+	states := make([]*checkState, len(checks))
+	for i, check := range checks {
+		if check.Timeout == 0 {
+			check.Timeout = 2 * time.Second
+		}
+		states[i] = &checkState{Check: check}
+	}
+	router := g.router
+	return g.Get(path, func(w http.ResponseWriter, r *http.Request) {
+		summary := HealthSummary{Status: "ok", Checks: make(map[string]CheckResult, len(states)+1)}
+		results := make([]error, len(states))
+		var wg sync.WaitGroup
+		for i, state := range states {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = state.run(r.Context())
+			}()
+		}
+		wg.Wait()
+		if ready && router.Draining() {
+			summary.Checks["draining"] = CheckResult{Status: "fail", Error: errDraining.Error()}
+			summary.Status = "fail"
+		}
+		for i, err := range results {
+			result := CheckResult{Status: "ok"}
+			if err != nil {
+				result = CheckResult{Status: "fail", Error: err.Error()}
+				summary.Status = "fail"
+			}
+			summary.Checks[states[i].Name] = result
+		}
+		code := http.StatusOK
+		if summary.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(w, code, summary)
+	})
+}
+
+// checkState is a check with its cached result.
+type checkState struct {
+	Check
+	mu  sync.Mutex
+	at  time.Time // of the cached result, zero for none
+	err error
+}
+
+func (state *checkState) run(parent context.Context) error {
+	if state.Cache > 0 {
+		state.mu.Lock()
+		at, err := state.at, state.err
+		state.mu.Unlock()
+		if !at.IsZero() && time.Since(at) < state.Cache {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, state.Timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- state.Check.Check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err() // the check ignores ctx, it is left running
+	}
+
+	if state.Cache > 0 && parent.Err() == nil { // not the failure of a client gone
+		state.mu.Lock()
+		state.at, state.err = time.Now(), err
+		state.mu.Unlock()
+	}
+	return err
+}
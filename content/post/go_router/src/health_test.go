@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	var pings, cachedPings atomic.Int32
+	db := Check{Name: "db", Check: func(ctx context.Context) error {
+		pings.Add(1)
+		return nil
+	}}
+	cache := Check{Name: "cache", Check: func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}}
+	slow := Check{Name: "search", Timeout: 10 * time.Millisecond, Check: func(ctx context.Context) error {
+		time.Sleep(time.Second) // ignores ctx
+		return nil
+	}}
+	cached := Check{Name: "db", Cache: time.Hour, Check: func(ctx context.Context) error {
+		cachedPings.Add(1)
+		return nil
+	}}
+
+	router := NewRouter()
+	router.Live("/livez")
+	router.Ready("/readyz", db)
+	router.Health("/healthz", db, cache, slow)
+	router.Health("/cached", cached)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/livez", http.StatusOK, `{"status":"ok"}`},
+		{"/readyz", http.StatusOK, `{"status":"ok","checks":{"db":{"status":"ok"}}}`},
+		{"/healthz", http.StatusServiceUnavailable, `{"status":"fail","checks":{"cache":{"status":"fail","error":"connection refused"},"db":{"status":"ok"},"search":{"status":"fail","error":"context deadline exceeded"}}}`},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || strings.TrimSpace(w.Body.String()) != tt.body {
+			t.Errorf("GET %s = %d %s, want %d %s", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("GET %s Cache-Control = %q", tt.path, w.Header().Get("Cache-Control"))
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		serve(router, http.MethodGet, "/readyz")
+		serve(router, http.MethodGet, "/cached")
+	}
+	if pings.Load() != 5 || cachedPings.Load() != 1 {
+		t.Errorf("%d pings uncached, %d cached, want 5 and 1", pings.Load(), cachedPings.Load())
+	}
+
+	router.draining.Store(true)
+	w := serve(router, http.MethodGet, "/readyz")
+	if want := `{"status":"fail","checks":{"db":{"status":"ok"},"draining":{"status":"fail","error":"shutting down"}}}`; w.Code != http.StatusServiceUnavailable || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("GET /readyz while draining = %d %s, want 503 %s", w.Code, w.Body.String(), want)
+	}
+	if w := serve(router, http.MethodGet, "/livez"); w.Code != http.StatusOK {
+		t.Errorf("GET /livez while draining = %d, want 200", w.Code)
+	}
+}
+
+func TestHealthCacheExpires(t *testing.T) {
+	var pings atomic.Int32
+	router := NewRouter()
+	router.Health("/healthz", Check{Name: "db", Cache: 20 * time.Millisecond, Check: func(ctx context.Context) error {
+		pings.Add(1)
+		return nil
+	}})
+	serve(router, http.MethodGet, "/healthz")
+	serve(router, http.MethodGet, "/healthz")
+	time.Sleep(30 * time.Millisecond)
+	serve(router, http.MethodGet, "/healthz")
+	if pings.Load() != 2 {
+		t.Errorf("%d pings, want 2: one cached for the window, one after", pings.Load())
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// EarlyHints sends a 103 Early Hints interim response with a Link header
+// per link, for the client to preload them while the handler prepares the
+// final response. A link is a Link header value, like
+// "</app.css>; rel=preload; as=style", or a bare URL preloaded as what its
+// extension tells. The Link headers are sent with the final response too.
+// EarlyHints does nothing when w is not the writer of a net/http server, or
+// is hidden by a wrapper without an Unwrap method.
+// It fails once the final response header was sent.
+func EarlyHints(w http.ResponseWriter, links []string) error {
+	values := make([]string, len(links))
+	for i, link := range links {
+		if strings.ContainsAny(link, "\r\n") {
+			return errors.New("router: EarlyHints: line break in a link")
+		}
+		values[i] = preloadLink(link)
+	}
+	sent, ok := informational(w)
+	if sent {
+		return errors.New("router: EarlyHints after the response header")
+	}
+	if !ok {
+		return nil
+	}
+	header := w.Header()
+	header["Link"] = append(header["Link"], values...)
+	w.WriteHeader(http.StatusEarlyHints)
+	return nil
+}
+
+// preloadLink returns the Link header value preloading link.
+func preloadLink(link string) string {
+	if strings.HasPrefix(link, "<") {
+		return link
+	}
+	value := "<" + link + ">; rel=preload"
+	ext := path.Ext(strings.TrimRight(strings.SplitN(link, "?", 2)[0], "/"))
+	switch strings.ToLower(ext) {
+	case ".css":
+		value += "; as=style"
+	case ".js", ".mjs":
+		value += "; as=script"
+	case ".woff", ".woff2", ".ttf", ".otf":
+		value += "; as=font; crossorigin" // fonts are fetched in CORS mode
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg":
+		value += "; as=image"
+	}
+	return value
+}
+
+// informational reports whether w, through the Unwrap methods, is the writer
+// of a net/http server, sending 1xx responses: the HTTP/1 one hijacks and
+// the HTTP/2 one pushes. It reports too whether a recorder on the way saw
+// the final response header.
+func informational(w http.ResponseWriter) (sent, ok bool) {
+	for {
+		if rec := recorderOf(w); rec != nil && rec.status != 0 {
+			sent = true
+		}
+		u, wraps := w.(interface{ Unwrap() http.ResponseWriter })
+		if !wraps {
+			break
+		}
+		w = u.Unwrap()
+	}
+	_, hijacker := w.(http.Hijacker)
+	_, pusher := w.(http.Pusher)
+	return sent, hijacker || pusher
+}
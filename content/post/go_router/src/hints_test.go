@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEarlyHints(t *testing.T) {
+	logged := make(logLines, 10)
+	router := NewRouter()
+	router.Use(Logger(logged))
+	router.Use(Compress(-1, 0))
+	router.Get("/page/:name", func(w http.ResponseWriter, r *http.Request) {
+		if err := EarlyHints(w, []string{"/app.css", "/font.woff2?v=2", "</data.json>; rel=preload; as=fetch"}); err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, "page "+Param(r, "name"))
+		if err := EarlyHints(w, []string{"/late.js"}); err == nil {
+			t.Error("EarlyHints succeeded after the response")
+		}
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	hints, res, body := getHints(t, server.URL+"/page/home")
+
+	want := []string{"</app.css>; rel=preload; as=style", "</font.woff2?v=2>; rel=preload; as=font; crossorigin", "</data.json>; rel=preload; as=fetch"}
+	if len(hints) != 1 || strings.Join(hints[0]["Link"], ", ") != strings.Join(want, ", ") {
+		t.Errorf("103 responses %v, want one with the links %q", hints, want)
+	}
+	if res.StatusCode != http.StatusOK || body != "page home" {
+		t.Errorf("final response %d %q", res.StatusCode, body)
+	}
+	if line := <-logged; !strings.Contains(line, " 200 ") {
+		t.Errorf("logged %q, want the final 200", line)
+	}
+}
+
+// getHints gets url, returning the 103 responses then the final one.
+func getHints(t *testing.T, url string) ([]textproto.MIMEHeader, *http.Response, string) {
+	var hints []textproto.MIMEHeader
+	trace := &httptrace.ClientTrace{Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+		if code == http.StatusEarlyHints {
+			hints = append(hints, header)
+		}
+		return nil
+	}}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(t.Context(), trace), http.MethodGet, url, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	return hints, res, string(body)
+}
+
+func TestEarlyHintsWithDeadline(t *testing.T) {
+	router := NewRouter(WithDefaultDeadline(time.Second))
+	router.Get("/page", func(w http.ResponseWriter, r *http.Request) {
+		if err := EarlyHints(w, []string{"/app.css"}); err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, "page")
+	}, WithDeadline(time.Second))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	hints, res, body := getHints(t, server.URL+"/page")
+	if len(hints) != 1 || hints[0].Get("Link") != "</app.css>; rel=preload; as=style" {
+		t.Errorf("103 responses %v, want one preloading /app.css", hints)
+	}
+	if res.StatusCode != http.StatusOK || body != "page" {
+		t.Errorf("final response %d %q", res.StatusCode, body)
+	}
+}
+
+func TestEarlyHintsUnsupported(t *testing.T) {
+	router := NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if err := EarlyHints(w, []string{"/app.css"}); err != nil {
+			t.Error(err)
+		}
+		if err := EarlyHints(w, []string{"/app.css>\r\nSet-Cookie: a=b"}); err == nil {
+			t.Error("EarlyHints accepted a line break")
+		}
+		io.WriteString(w, "page")
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Link") != "" {
+		t.Errorf("answered %d with Link %q, want EarlyHints ignored by the recorder", w.Code, w.Header().Get("Link"))
+	}
+}
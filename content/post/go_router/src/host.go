@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// Host returns the router serving requests for host, created with the same
+// options on first use. Requests for other hosts are served by router itself.
+// The host is matched without its port and regardless of case, and the
+// middlewares of router also wrap the host router. Host panics with
+// ErrCompiled for a new host of a compiled router.
+func (router *Router) Host(host string) *Router {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	host = hostname(host)
+	if sub, ok := router.routes.Load().hosts[host]; ok {
+		return sub
+	}
+	sub := NewRouter(router.opts...)
+	router.mustUpdate(func(t *table) error {
+		t.hosts[host] = sub
+		return nil
+	})
+	return sub
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	router := NewRouter()
+	router.Get("/status", reply("www"))
+	router.Host("api.example.com").Get("/status", reply("api"))
+
+	tests := []struct {
+		host string
+		code int
+		body string
+	}{
+		{"www.example.com", http.StatusOK, "www"},
+		{"api.example.com", http.StatusOK, "api"},
+		{"api.example.com:8443", http.StatusOK, "api"},
+		{"API.Example.COM", http.StatusOK, "api"},
+		{"[::1]:8080", http.StatusOK, "www"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		r.Host = tt.host
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s/status = %d %q, want %d %q", tt.host, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	if router.Host("API.example.com:443") != router.Host("api.example.com") {
+		t.Error("Host returned distinct routers for the same host")
+	}
+}
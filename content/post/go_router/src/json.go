@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultMaxBodyBytes limits the body read by BindJSON when neither the call
+// nor the router sets a limit.
+const defaultMaxBodyBytes = 1 << 20
+
+// jsonConfig holds the WithMaxBodyBytes and WithStrictJSON settings, passed
+// to BindJSON through the request context.
+type jsonConfig struct {
+	maxBytes int64
+	strict   bool
+}
+
+// BodyError reports a request body BindJSON could not decode. Status is the
+// code to answer with: 415 for a wrong Content-Type, 413 for a body over the
+// limit and 400 otherwise. Offset and Field locate the error when known.
+type BodyError struct {
+	Status int
+	Offset int64
+	Field  string
+	Err    error
+}
+
+func (e *BodyError) Error() string {
+	switch {
+	case e.Field != "":
+		return fmt.Sprintf("router: invalid JSON body, field %s at offset %d: %v", e.Field, e.Offset, e.Err)
+	case e.Offset > 0:
+		return fmt.Sprintf("router: invalid JSON body at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("router: invalid JSON body: %v", e.Err)
+}
+
+func (e *BodyError) Unwrap() error {
+	return e.Err
+}
+
+// BindJSON decodes the single JSON value of the body of r into dst. The body
+// must be sent as application/json and is limited to maxBytes, or when it is
+// 0 to the BodyLimit of the route or the WithMaxBodyBytes limit of the router. The body is drained, up to
+// maxBytes more, and closed in every case so the connection can be reused.
+func BindJSON(r *http.Request, dst any, maxBytes int64) error {
+	config, _ := r.Context().Value(jsonKey).(jsonConfig)
+	if limited, ok := r.Body.(*limitedBody); ok && maxBytes == 0 {
+		maxBytes = limited.limit
+	}
+	if maxBytes == 0 {
+		maxBytes = config.maxBytes
+	}
+	if maxBytes == 0 {
+		maxBytes = defaultMaxBodyBytes
+	}
+	body := http.MaxBytesReader(nil, r.Body, maxBytes)
+	defer func() {
+		io.CopyN(io.Discard, r.Body, maxBytes)
+		body.Close()
+	}()
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return &BodyError{Status: http.StatusUnsupportedMediaType, Err: fmt.Errorf("Content-Type %q is not application/json", r.Header.Get("Content-Type"))}
+	}
+
+	dec := json.NewDecoder(body)
+	if config.strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(dst); err != nil {
+		return bodyError(dec, err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after the JSON value")
+		}
+		return bodyError(dec, err)
+	}
+	return nil
+}
+
+func bodyError(dec *json.Decoder, err error) error {
+	var (
+		syntaxErr  *json.SyntaxError
+		typeErr    *json.UnmarshalTypeError
+		maxByteErr *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &maxByteErr):
+		return &BodyError{Status: http.StatusRequestEntityTooLarge, Err: err}
+	case errors.As(err, &syntaxErr):
+		return &BodyError{Status: http.StatusBadRequest, Offset: syntaxErr.Offset, Err: err}
+	case errors.As(err, &typeErr):
+		return &BodyError{Status: http.StatusBadRequest, Offset: typeErr.Offset, Field: typeErr.Field, Err: err}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &BodyError{Status: http.StatusBadRequest, Offset: dec.InputOffset(), Field: field, Err: err}
+	case err == io.EOF:
+		err = errors.New("empty body")
+	}
+	return &BodyError{Status: http.StatusBadRequest, Offset: dec.InputOffset(), Err: err}
+}
+
+// JSONOption changes how WriteJSON encodes a value.
+type JSONOption func(config *jsonWriteConfig)
+
+type jsonWriteConfig struct {
+	indent      string
+	emptySlices bool
+}
+
+// EmptySlices encodes the nil slices found in the value as [] rather than
+// null, so clients can range over a field without checking for null. []byte
+// values are left as is, encoded as base64 strings.
+func EmptySlices() JSONOption {
+	return func(config *jsonWriteConfig) {
+		config.emptySlices = true
+	}
+}
+
+// maxPooledBuffer is the capacity above which a response buffer is left to
+// the garbage collector rather than kept in the pool for the next response.
+const maxPooledBuffer = 64 << 10
+
+var buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// putBuffer returns buf, taken from buffers, to the pool.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		buf.Reset()
+		buffers.Put(buf)
+	}
+}
+
+// WriteJSON answers with code and v encoded as JSON. Content-Type is set to
+// application/json unless the caller set it, and Content-Length to the size
+// of the encoding. v is encoded before anything is written, so a value that
+// cannot be encoded is answered with a plain 500 and the error returned,
+// never with half a document. When the caller already sent the header only
+// the connection can tell the client: the error is logged and the handler
+// aborted with http.ErrAbortHandler.
+func WriteJSON(w http.ResponseWriter, code int, v any, opts ...JSONOption) error {
+	var config jsonWriteConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.emptySlices && v != nil {
+		v = emptySlices(reflect.ValueOf(v), 0).Interface()
+	}
+
+	buf := buffers.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", config.indent)
+	if err := enc.Encode(v); err != nil {
+		if rec := recorderOf(w); rec != nil && rec.status != 0 {
+			log.Printf("router: encoding a JSON response after the header was sent: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return fmt.Errorf("router: encoding a JSON response: %w", err)
+	}
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(code)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteJSONIndent is WriteJSON with the value indented by two spaces, for
+// reading responses while debugging.
+func WriteJSONIndent(w http.ResponseWriter, code int, v any, opts ...JSONOption) error {
+	return WriteJSON(w, code, v, append(opts, func(config *jsonWriteConfig) { config.indent = "  " })...)
+}
+
+// maxEmptySlicesDepth stops emptySlices on cyclic values, encoding/json
+// reports the cycle then.
+const maxEmptySlicesDepth = 1000
+
+var marshalerType = reflect.TypeFor[json.Marshaler]()
+
+// emptySlices returns a copy of v where the nil slices are empty, the
+// values of v are not modified. Values encoding themselves are kept as is.
+func emptySlices(v reflect.Value, depth int) reflect.Value {
+	if !v.IsValid() || depth > maxEmptySlicesDepth ||
+		v.Type().Implements(marshalerType) || reflect.PointerTo(v.Type()).Implements(marshalerType) {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		if v.IsNil() {
+			return reflect.MakeSlice(v.Type(), 0, 0)
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			c.Index(i).Set(emptySlices(v.Index(i), depth+1))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := range v.Len() {
+			c.Index(i).Set(emptySlices(v.Index(i), depth+1))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for it := v.MapRange(); it.Next(); {
+			c.SetMapIndex(it.Key(), emptySlices(it.Value(), depth+1))
+		}
+		return c
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(emptySlices(v.Elem(), depth+1))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(emptySlices(v.Elem(), depth+1))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v) // keeps the unexported fields
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				c.Field(i).Set(emptySlices(v.Field(i), depth+1))
+			}
+		}
+		return c
+	}
+	return v
+}
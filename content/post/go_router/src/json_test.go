@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBindJSON(t *testing.T) {
+	type book struct {
+		Title string `json:"title"`
+		Pages int    `json:"pages"`
+	}
+	tests := []struct {
+		contentType, body string
+		opts              []Option
+		status            int
+		field             string
+	}{
+		{"application/json", `{"title":"Dune","pages":412}`, nil, 0, ""},
+		{"application/json; charset=UTF-8", `{"title":"Dune"}`, nil, 0, ""},
+		{"text/plain", `{"title":"Dune"}`, nil, http.StatusUnsupportedMediaType, ""},
+		{"", `{"title":"Dune"}`, nil, http.StatusUnsupportedMediaType, ""},
+		{"application/json", `{"title":"Dune"} {"title":"Emma"}`, nil, http.StatusBadRequest, ""},
+		{"application/json", `{"title":"Dune"} garbage`, nil, http.StatusBadRequest, ""},
+		{"application/json", `{"title":`, nil, http.StatusBadRequest, ""},
+		{"application/json", ``, nil, http.StatusBadRequest, ""},
+		{"application/json", `{"pages":"many"}`, nil, http.StatusBadRequest, "pages"},
+		{"application/json", `{"title":"Dune","author":"Herbert"}`, nil, 0, ""},
+		{"application/json", `{"title":"Dune","author":"Herbert"}`, []Option{WithStrictJSON()}, http.StatusBadRequest, "author"},
+		{"application/json", `{"title":"` + strings.Repeat("a", 64) + `"}`, []Option{WithMaxBodyBytes(32)}, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		var got error
+		router := NewRouter(tt.opts...)
+		router.Post("/books", func(w http.ResponseWriter, r *http.Request) {
+			var b book
+			got = BindJSON(r, &b, 0)
+		})
+		r := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		var bodyErr *BodyError
+		switch {
+		case tt.status == 0 && got != nil:
+			t.Errorf("BindJSON(%s %q) error = %v", tt.contentType, tt.body, got)
+		case tt.status != 0 && (!errors.As(got, &bodyErr) || bodyErr.Status != tt.status || bodyErr.Field != tt.field):
+			t.Errorf("BindJSON(%s %q) error = %#v, want status %d and field %q", tt.contentType, tt.body, got, tt.status, tt.field)
+		}
+	}
+}
+
+func TestBindJSONLimit(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"title":"Dune"}`))
+	r.Header.Set("Content-Type", "application/json")
+	var v map[string]string
+	var bodyErr *BodyError
+	if err := BindJSON(r, &v, 8); !errors.As(err, &bodyErr) || bodyErr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("BindJSON over maxBytes error = %v, want a 413 BodyError", err)
+	}
+	if n, _ := r.Body.Read(make([]byte, 1)); n != 0 {
+		t.Errorf("BindJSON left the body unread")
+	}
+}
+
+type failingJSON struct{}
+
+func (failingJSON) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("cannot encode")
+}
+
+func TestWriteJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := WriteJSON(w, http.StatusCreated, map[string]int{"id": 42}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != `{"id":42}`+"\n" ||
+		w.Header().Get("Content-Type") != "application/json" || w.Header().Get("Content-Length") != "10" {
+		t.Errorf("WriteJSON = %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	WriteJSONIndent(w, http.StatusOK, map[string]int{"id": 42})
+	if w.Body.String() != "{\n  \"id\": 42\n}\n" || w.Header().Get("Content-Type") != "application/vnd.api+json" {
+		t.Errorf("WriteJSONIndent with a Content-Type = %q %v", w.Body.String(), w.Header())
+	}
+}
+
+func TestWriteJSONFailure(t *testing.T) {
+	values := make([]any, 1000)
+	for i := range values {
+		values[i] = map[string]int{"n": i}
+	}
+	values[900] = failingJSON{}
+
+	w := httptest.NewRecorder()
+	err := WriteJSON(w, http.StatusOK, values)
+	if err == nil || !strings.Contains(err.Error(), "cannot encode") {
+		t.Errorf("WriteJSON of a failing value = %v", err)
+	}
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "server error\n" {
+		t.Errorf("failing value answered %d %q, want a clean 500", w.Code, w.Body.String())
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	router := NewRouter()
+	router.Get("/sent", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		WriteJSON(w, http.StatusOK, values)
+		t.Error("WriteJSON returned after the header was sent")
+	})
+	w = httptest.NewRecorder()
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("WriteJSON after the header panicked with %v, want http.ErrAbortHandler", err)
+			}
+		}()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sent", nil))
+	}()
+	if w.Body.Len() != 0 || !strings.Contains(logged.String(), "after the header was sent: json: error calling MarshalJSON") {
+		t.Errorf("WriteJSON after the header wrote %q and logged %q", w.Body.String(), logged.String())
+	}
+}
+
+func TestWriteJSONEmptySlices(t *testing.T) {
+	type item struct {
+		Tags []string
+	}
+	type page struct {
+		Items  []item
+		Names  []string
+		Raw    []byte
+		Nested *item
+		ByKey  map[string][]int
+		Any    any
+		secret []string
+	}
+	v := page{
+		Items:  []item{{}, {Tags: []string{"a"}}},
+		Nested: &item{},
+		ByKey:  map[string][]int{"k": nil},
+		Any:    item{},
+		secret: []string{"kept out of the JSON"},
+	}
+
+	tests := []struct {
+		opts []JSONOption
+		body string
+	}{
+		{nil, `{"Items":[{"Tags":null},{"Tags":["a"]}],"Names":null,"Raw":null,"Nested":{"Tags":null},"ByKey":{"k":null},"Any":{"Tags":null}}`},
+		{[]JSONOption{EmptySlices()}, `{"Items":[{"Tags":[]},{"Tags":["a"]}],"Names":[],"Raw":null,"Nested":{"Tags":[]},"ByKey":{"k":[]},"Any":{"Tags":[]}}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if err := WriteJSON(w, http.StatusOK, v, tt.opts...); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(w.Body.String(), "\n"); got != tt.body {
+			t.Errorf("WriteJSON with %d options = %s, want %s", len(tt.opts), got, tt.body)
+		}
+	}
+	if v.Items[0].Tags != nil || v.Names != nil || v.Nested.Tags != nil || v.ByKey["k"] != nil {
+		t.Error("EmptySlices modified the value")
+	}
+
+	w := httptest.NewRecorder()
+	WriteJSON(w, http.StatusOK, nil, EmptySlices())
+	if w.Body.String() != "null\n" {
+		t.Errorf("WriteJSON(nil) = %q", w.Body.String())
+	}
+}
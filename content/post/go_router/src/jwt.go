@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTHeader is the JOSE header of a token, passed to the keyfunc of JWT to
+// pick the verification key.
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+type JWTOption func(c *jwtConfig)
+
+type jwtConfig struct {
+	audience string
+	issuer   string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// WithAudience requires the aud claim to be or to contain aud.
+func WithAudience(aud string) JWTOption {
+	return func(c *jwtConfig) {
+		c.audience = aud
+	}
+}
+
+// WithIssuer requires the iss claim to be iss.
+func WithIssuer(iss string) JWTOption {
+	return func(c *jwtConfig) {
+		c.issuer = iss
+	}
+}
+
+// WithLeeway tolerates a clock skew of d when checking the exp and nbf
+// claims.
+func WithLeeway(d time.Duration) JWTOption {
+	return func(c *jwtConfig) {
+		c.leeway = d
+	}
+}
+
+// JWT returns a middleware letting through the requests with a valid Bearer
+// token, its claims are then returned by Claims. keyfunc returns the key
+// verifying a token: a []byte secret for HS256, an *rsa.PublicKey for RS256.
+// Other algorithms, "none" included, are rejected. Requests without a valid
+// token get a 401 with a WWW-Authenticate header describing the error.
+func JWT(keyfunc func(token *JWTHeader) (key any, err error), opts ...JWTOption) middleware {
+	config := &jwtConfig{now: time.Now}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			claims, err := config.verify(token, keyfunc)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, err.Error()))
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+		})
+	}
+}
+
+// Claims returns the claims of the token verified by JWT, or nil.
+func Claims(r *http.Request) map[string]any {
+	claims, _ := r.Context().Value(claimsKey).(map[string]any)
+	return claims
+}
+
+// verify checks the signature then the claims of token.
+func (c *jwtConfig) verify(token string, keyfunc func(*JWTHeader) (any, error)) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header JWTHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	key, err := keyfunc(&header)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed claims")
+	}
+	now := c.now()
+	exp, hasExp, err := numericDate(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if hasExp && now.After(exp.Add(c.leeway)) {
+		return nil, errors.New("token expired")
+	}
+	nbf, hasNbf, err := numericDate(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	if hasNbf && now.Add(c.leeway).Before(nbf) {
+		return nil, errors.New("token not valid yet")
+	}
+	if c.issuer != "" && claims["iss"] != c.issuer {
+		return nil, errors.New("invalid issuer")
+	}
+	if c.audience != "" && !hasAudience(claims["aud"], c.audience) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+// numericDate returns the time of the claim name of claims, and whether it
+// is present. A present claim that is not a number, "0" or null say, is an
+// error rather than no date.
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	seconds, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("malformed %s claim", name)
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks sig for alg, the key type must be the one of alg so
+// that a public key cannot be used as an HMAC secret.
+func verifySignature(alg string, key any, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("invalid key for HS256")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New("invalid signature")
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("invalid key for RS256")
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) != nil {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
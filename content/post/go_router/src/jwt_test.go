@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signJWT builds a token for alg signed with key, a []byte or *rsa.PrivateKey.
+func signJWT(t *testing.T, alg string, key any, claims map[string]any) string {
+	header, _ := json.Marshal(JWTHeader{Alg: alg, Typ: "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("s3cret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyfunc := func(header *JWTHeader) (any, error) {
+		if header.Alg == "RS256" {
+			return &rsaKey.PublicKey, nil
+		}
+		return secret, nil
+	}
+	now := time.Unix(1700000000, 0)
+	leeway := WithLeeway(30 * time.Second)
+	clock := func(c *jwtConfig) { c.now = func() time.Time { return now } }
+
+	router := NewRouter()
+	router.Use(JWT(keyfunc, WithAudience("api"), WithIssuer("auth"), leeway, clock))
+	router.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, Claims(r)["sub"])
+	})
+
+	valid := map[string]any{"sub": "alice", "aud": "api", "iss": "auth", "exp": now.Unix() + 60}
+	with := func(k string, v any) map[string]any {
+		claims := map[string]any{}
+		for key, value := range valid {
+			claims[key] = value
+		}
+		claims[k] = v
+		return claims
+	}
+	none := strings.TrimSuffix(signJWT(t, "none", nil, valid), ".") + "."
+	otherKey := []byte("other")
+
+	tests := []struct {
+		name, token string
+		code        int
+		description string
+	}{
+		{"HS256", signJWT(t, "HS256", secret, valid), http.StatusOK, ""},
+		{"RS256", signJWT(t, "RS256", rsaKey, valid), http.StatusOK, ""},
+		{"audience list", signJWT(t, "HS256", secret, with("aud", []string{"web", "api"})), http.StatusOK, ""},
+		{"expired within leeway", signJWT(t, "HS256", secret, with("exp", now.Unix()-10)), http.StatusOK, ""},
+		{"expired", signJWT(t, "HS256", secret, with("exp", now.Unix()-60)), http.StatusUnauthorized, "token expired"},
+		{"not valid yet", signJWT(t, "HS256", secret, with("nbf", now.Unix()+60)), http.StatusUnauthorized, "token not valid yet"},
+		{"exp string", signJWT(t, "HS256", secret, with("exp", "0")), http.StatusUnauthorized, "malformed exp claim"},
+		{"exp null", signJWT(t, "HS256", secret, with("exp", nil)), http.StatusUnauthorized, "malformed exp claim"},
+		{"nbf string", signJWT(t, "HS256", secret, with("nbf", "0")), http.StatusUnauthorized, "malformed nbf claim"},
+		{"wrong key", signJWT(t, "HS256", otherKey, valid), http.StatusUnauthorized, "invalid signature"},
+		{"alg none", none, http.StatusUnauthorized, `unsupported algorithm \"none\"`},
+		{"audience mismatch", signJWT(t, "HS256", secret, with("aud", "admin")), http.StatusUnauthorized, "invalid audience"},
+		{"issuer mismatch", signJWT(t, "HS256", secret, with("iss", "evil")), http.StatusUnauthorized, "invalid issuer"},
+		{"malformed", "abc.def", http.StatusUnauthorized, "malformed token"},
+		{"missing", "", http.StatusUnauthorized, ""},
+		{"lower case scheme", "bearer " + signJWT(t, "HS256", secret, valid), http.StatusOK, ""},
+		{"other scheme", "Basic " + signJWT(t, "HS256", secret, valid), http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		switch {
+		case strings.Contains(tt.token, " "):
+			r.Header.Set("Authorization", tt.token)
+		case tt.token != "":
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: %d %q, want %d", tt.name, w.Code, w.Body.String(), tt.code)
+			continue
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "alice" {
+			t.Errorf("%s: Claims sub = %q, want alice", tt.name, w.Body.String())
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); tt.description != "" && !strings.Contains(challenge, tt.description) {
+			t.Errorf("%s: WWW-Authenticate = %q, want %q", tt.name, challenge, tt.description)
+		}
+	}
+}
+
+func TestJWTKeyConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An RS256 verifier must not accept an HS256 token signed with a secret.
+	keyfunc := func(header *JWTHeader) (any, error) {
+		return &rsaKey.PublicKey, nil
+	}
+	router := NewRouter()
+	router.Use(JWT(keyfunc))
+	router.Get("/me", reply("me"))
+
+	r := httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.Header.Set("Authorization", "Bearer "+signJWT(t, "HS256", []byte("guess"), map[string]any{"sub": "mallory"}))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("HS256 token with an RSA key = %d, want 401", w.Code)
+	}
+}
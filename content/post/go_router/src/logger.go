@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// access describes a served request for the logging middlewares.
+type access struct {
+	r        *http.Request
+	id       string // see RequestIDs
+	start    time.Time
+	status   int
+	size     int64
+	duration time.Duration
+}
+
+// pattern returns the pattern of the matched route, or "-".
+func (a access) pattern() string {
+	if pattern := RoutePattern(a.r); pattern != "" {
+		return pattern
+	}
+	return "-"
+}
+
+// logRequests returns a middleware calling logf once per request, after the
+// response is written. A panicking handler is logged with a 500, the panic
+// then goes on to the router panic handler.
+func logRequests(logf func(a access)) middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw, rec := newResponseRecorder(w)
+			start := time.Now()
+			defer func() {
+				err := recover()
+				if err != nil && rec.status == 0 {
+					rec.status = http.StatusInternalServerError
+				}
+				logf(access{r: r, id: requestID(w, r), start: start, status: rec.status, size: rec.size, duration: time.Since(start)})
+				if err != nil {
+					panic(err)
+				}
+			}()
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// Logger logs a line per request to out with the client IP, see ClientIP,
+// the method, the path, the matched route pattern, the status, the body size
+// and the duration, then the request ID when there is one.
+func Logger(out io.Writer) middleware {
+	l := log.New(out, "", log.LstdFlags)
+	return logRequests(func(a access) {
+		id := ""
+		if a.id != "" {
+			id = " " + a.id
+		}
+		l.Printf("%s %s %s %s %d %dB %v%s", ClientIP(a.r), a.r.Method, a.r.URL.Path, a.pattern(), a.status, a.size, a.duration, id)
+	})
+}
+
+// StructuredLogger is like Logger for a slog.Logger, a 5xx is logged as an
+// error.
+func StructuredLogger(logger *slog.Logger) middleware {
+	return logRequests(func(a access) {
+		level := slog.LevelInfo
+		if a.status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("ip", ClientIP(a.r)),
+			slog.String("method", a.r.Method),
+			slog.String("path", a.r.URL.Path),
+			slog.String("pattern", a.pattern()),
+			slog.Int("status", a.status),
+			slog.Int64("size", a.size),
+			slog.Duration("duration", a.duration),
+		}
+		if a.id != "" {
+			attrs = append(attrs, slog.String("request_id", a.id))
+		}
+		logger.LogAttrs(a.r.Context(), level, "request", attrs...)
+	})
+}
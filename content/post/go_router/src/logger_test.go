@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	router := NewRouter()
+	router.Use(Logger(&out))
+	router.Get("/book/:id", reply("book"))
+	router.Get("/created", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.Get("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	router.PanicHandler(func(w http.ResponseWriter, r *http.Request, err any) {
+		http.Error(w, "server error", http.StatusInternalServerError)
+	})
+
+	tests := []struct {
+		path, line string
+	}{
+		{"/book/42", "192.0.2.1 GET /book/42 /book/:id 200 4B"},
+		{"/created", "GET /created /created 201 0B"},
+		{"/boom", "GET /boom /boom 500 0B"},
+		{"/nope", "GET /nope - 404 19B"},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		serve(router, http.MethodGet, tt.path)
+		if !strings.Contains(out.String(), tt.line) {
+			t.Errorf("GET %s logged %q, want %q", tt.path, out.String(), tt.line)
+		}
+	}
+}
+
+func TestStructuredLogger(t *testing.T) {
+	var out bytes.Buffer
+	router := NewRouter()
+	router.Use(StructuredLogger(slog.New(slog.NewJSONHandler(&out, nil))))
+	router.Get("/book/:id", reply("book"))
+
+	serve(router, http.MethodGet, "/book/42")
+	var entry struct {
+		Level, IP, Method, Pattern string
+		Status                     int
+		Size                       int64
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Level != "INFO" || entry.IP != "192.0.2.1" || entry.Method != "GET" || entry.Pattern != "/book/:id" || entry.Status != 200 || entry.Size != 4 {
+		t.Errorf("logged %s", out.String())
+	}
+}
+
+func TestLoggerFlusher(t *testing.T) {
+	router := NewRouter()
+	router.Use(Logger(&bytes.Buffer{}))
+	router.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("ResponseWriter lost http.Flusher")
+		}
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("ResponseController.Flush: %v", err)
+		}
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if !w.Flushed {
+		t.Error("response was not flushed")
+	}
+}
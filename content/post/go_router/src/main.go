@@ -9,14 +9,24 @@ import (
 func main() {
 	router := NewRouter()
 
-	router.Handle("/home", "GET", http.HandlerFunc(home))
-	router.Handle("/about", "GET", http.HandlerFunc(about))
-	router.Handle("/book/:id:.*", "GET", http.HandlerFunc(book))
+	must(router.Get("/home", home))
+	must(router.Get("/about", about))
+	must(router.Get("/book/:id", book))
 
-	router.Use(helloMiddleware)
+	api := router.Group("/api/v1")
+	must(api.Get("/home", home))
+	must(api.Get("/about", about))
+
+	must(router.HandleFunc("/contact", "GET", contact))
+
+	router.Use(MiddlewareFunc(helloMiddleware))
 
 	http.Handle("/", router)
 
+	for _, route := range router.Routes() {
+		log.Printf("%-7s %s", route.Method, route.Pattern)
+	}
+
 	addr := fmt.Sprintf("localhost:%v", 8080)
 	srv := &http.Server{
 		Addr:    addr,
@@ -26,6 +36,12 @@ func main() {
 	log.Fatal(srv.ListenAndServe())
 }
 
+func must(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func home(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "home\n")
 }
@@ -39,8 +55,12 @@ func book(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "book: %s\n", vars)
 }
 
-func helloMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func contact(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "contact\n")
+}
+
+func helloMiddleware(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		// before the handler is executed
 		w.Write([]byte("I am a middleware!\n"))
 
@@ -48,5 +68,5 @@ func helloMiddleware(h http.Handler) http.Handler {
 
 		// after the handler is executed
 		w.Write([]byte("Bip bip bop!\n"))
-	})
+	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsBuckets are the upper bounds in seconds of the latency histogram
+// buckets, the Prometheus defaults.
+var metricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics counts the requests and their latency per route pattern, method
+// and status class. Labeling by pattern rather than path keeps the number
+// of series bounded, the requests matching no route share the "unmatched"
+// route. Metrics serves them in the Prometheus text format, so it can be
+// registered as the /metrics route.
+type Metrics struct {
+	mu     sync.Mutex
+	series map[seriesKey]*series
+}
+
+type seriesKey struct {
+	method, route, status string
+}
+
+type series struct {
+	buckets []uint64 // cumulative counts, one per metricsBuckets bound
+	count   uint64
+	sum     float64
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{series: map[seriesKey]*series{}}
+}
+
+// Middleware records the requests it wraps. A panicking handler is counted
+// as a 500.
+func (m *Metrics) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw, rec := newResponseRecorder(w)
+		start := time.Now()
+		defer func() {
+			err := recover()
+			if err != nil && rec.status == 0 {
+				rec.status = http.StatusInternalServerError
+			}
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			m.observe(r, rec.status, time.Since(start))
+			if err != nil {
+				panic(err)
+			}
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+func (m *Metrics) observe(r *http.Request, status int, d time.Duration) {
+	route := RoutePattern(r)
+	if route == "" {
+		route = "unmatched"
+	}
+	key := seriesKey{method: metricsMethod(r.Method), route: route, status: strconv.Itoa(status/100) + "xx"}
+	seconds := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{buckets: make([]uint64, len(metricsBuckets))}
+		m.series[key] = s
+	}
+	for i, bound := range metricsBuckets {
+		if seconds <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+// ServeHTTP writes the http_requests_total counters and the
+// http_request_duration_seconds histograms.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	keys := make([]seriesKey, 0, len(m.series))
+	snapshot := make(map[seriesKey]series, len(m.series))
+	for key, s := range m.series {
+		keys = append(keys, key)
+		snapshot[key] = series{buckets: append([]uint64(nil), s.buckets...), count: s.count, sum: s.sum}
+	}
+	m.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	var b strings.Builder
+	b.WriteString("# HELP http_requests_total Requests served, by route, method and status class.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "http_requests_total{%s} %d\n", key.labels(), snapshot[key].count)
+	}
+	b.WriteString("# HELP http_request_duration_seconds Request latency, by route, method and status class.\n")
+	b.WriteString("# TYPE http_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		s, labels := snapshot[key], key.labels()
+		for i, bound := range metricsBuckets {
+			fmt.Fprintf(&b, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(bound, 'g', -1, 64), s.buckets[i])
+		}
+		fmt.Fprintf(&b, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, s.count)
+		fmt.Fprintf(&b, "http_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "http_request_duration_seconds_count{%s} %d\n", labels, s.count)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (key seriesKey) labels() string {
+	return fmt.Sprintf(`method="%s",route="%s",status="%s"`,
+		labelEscaper.Replace(key.method), labelEscaper.Replace(key.route), key.status)
+}
+
+// metricsMethod returns the label of method, "OTHER" for a method not
+// defined by RFC 9110 or RFC 5789 so that clients cannot add series.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
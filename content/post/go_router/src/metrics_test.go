@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	router := NewRouter()
+	router.Use(metrics.Middleware)
+	router.Get("/book/:id", reply("book"))
+	router.Post("/book/:id", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusBadRequest)
+	})
+
+	serve(router, http.MethodGet, "/book/1")
+	serve(router, http.MethodGet, "/book/2")
+	serve(router, http.MethodPost, "/book/3")
+	serve(router, http.MethodGet, "/nope")
+	serve(router, http.MethodGet, "/other")
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/book/:id",status="2xx"} 2`,
+		`http_requests_total{method="POST",route="/book/:id",status="4xx"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/book/:id",status="2xx",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="unmatched",status="4xx"} 2`,
+		"# TYPE http_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics miss %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "/book/1") {
+		t.Errorf("metrics are labeled by path:\n%s", body)
+	}
+}
+
+func TestMetricsCardinality(t *testing.T) {
+	metrics := NewMetrics()
+	router := NewRouter()
+	router.Use(metrics.Middleware)
+	router.HandleMethods("/any", []string{"FOO", "BAR", "BAZ"}, reply("any"))
+	mux := http.NewServeMux()
+	mux.Handle("/api/", router)
+
+	for _, method := range []string{"FOO", "BAR", "BAZ"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/any", nil))
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/nope", nil))
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="OTHER",route="/any",status="2xx"} 3`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics miss %q:\n%s", line, body)
+		}
+	}
+	for _, label := range []string{`"FOO"`, `"/api/"`} {
+		if strings.Contains(body, label) {
+			t.Errorf("metrics are labeled %s:\n%s", label, body)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Negotiate returns the offer, a media type like "application/json", that
+// the Accept header of r prefers, RFC 7231 section 5.3.2. The q of an offer
+// is the one of the most specific media range matching it, "text/html"
+// before "text/*" before "*/*", and offers of the same q are preferred in
+// order. Negotiate returns "" when every offer is excluded, by a q=0 or by
+// no range matching it, and the first offer when r has no Accept header or
+// a malformed one.
+func Negotiate(r *http.Request, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	ranges, ok := parseAccept(strings.Join(r.Header.Values("Accept"), ","))
+	if !ok || len(ranges) == 0 {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := offerQ(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// mediaRange is an element of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	params       []string // "name=value", lowercased, without q
+	q            float64
+}
+
+// parseAccept parses an Accept header, it reports whether it is well formed.
+func parseAccept(accept string) ([]mediaRange, bool) {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue // empty list elements are allowed
+		}
+		mediaType, params, _ := strings.Cut(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mediaType)), "/")
+		if !ok || !validToken(typ) || !validToken(subtype) || typ == "*" && subtype != "*" {
+			return nil, false
+		}
+		mr := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range strings.Split(params, ";") {
+			if param = strings.TrimSpace(param); param == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(param, "=")
+			name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+			if !ok || !validToken(name) {
+				return nil, false
+			}
+			if name != "q" {
+				mr.params = append(mr.params, name+"="+strings.ToLower(strings.Trim(value, `"`)))
+				continue
+			}
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 || len(value) > 5 {
+				return nil, false
+			}
+			mr.q = q
+			break // the params after q are accept-ext, not of the media type
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges, true
+}
+
+// offerQ returns the q of the most specific range matching offer, 0 when
+// none does.
+func offerQ(ranges []mediaRange, offer string) float64 {
+	mediaType, params, _ := strings.Cut(strings.ToLower(offer), ";")
+	typ, subtype, _ := strings.Cut(strings.TrimSpace(mediaType), "/")
+	var offerParams []string
+	for _, param := range strings.Split(params, ";") {
+		if param = strings.TrimSpace(param); param != "" {
+			offerParams = append(offerParams, strings.ReplaceAll(param, " ", ""))
+		}
+	}
+
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := 0
+		switch {
+		case mr.typ == "*":
+		case mr.typ != typ:
+			continue
+		case mr.subtype == "*":
+			s = 1
+		case mr.subtype != subtype:
+			continue
+		default:
+			s = 2 + len(mr.params)
+		}
+		if !allIn(mr.params, offerParams) {
+			continue
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q
+}
+
+func allIn(params, in []string) bool {
+	for _, param := range params {
+		if !slices.Contains(in, param) {
+			return false
+		}
+	}
+	return true
+}
+
+// validToken reports whether s is a token of RFC 7230, "*" included.
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleNegotiated registers for the path and method the handlers of the
+// representations of a resource, keyed by their media type. A request is
+// served by the handler of the type Negotiate prefers, the types offered in
+// sorted order, and gets a 406 listing them when it accepts none. The
+// responses vary on Accept.
+func (g *Group) HandleNegotiated(path, method string, handlers map[string]http.Handler, mws ...middleware) error {
+	handlers = maps.Clone(handlers)
+	offers := slices.Sorted(maps.Keys(handlers))
+	notAcceptable := &HTTPError{
+		Code:       http.StatusNotAcceptable,
+		Msg:        "acceptable types: " + strings.Join(offers, ", "),
+		Extensions: map[string]any{"offers": offers},
+	}
+	return g.Handle(path, method, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType := Negotiate(r, offers...)
+		if mediaType == "" {
+			WriteError(w, r, notAcceptable)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		handlers[mediaType].ServeHTTP(w, r)
+	}), mws...)
+}
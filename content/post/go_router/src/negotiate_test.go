@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/html", "text/plain"}
+	tests := []struct{ accept, want string }{
+		{"", "application/json"},
+		{"text/html", "text/html"},
+		{"TEXT/HTML", "text/html"},
+		{"text/plain;q=0.5, text/html;q=0.8, application/json;q=0.2", "text/html"},
+		{"*/*", "application/json"},
+		{"text/*", "text/html"},
+		{"text/*;q=0.5, text/plain", "text/plain"},            // the more specific range wins
+		{"text/*, text/html;q=0", "text/plain"},               // text/html excluded, text/* kept
+		{"*/*;q=0.1, application/json;q=0", "text/html"},      // */* below, offers in order
+		{"application/xml, image/*", ""},                      // nothing acceptable
+		{"application/*;q=0, */*", "text/html"},               // a whole type excluded
+		{"text/html;level=1, text/plain;q=0.5", "text/plain"}, // params must match
+		{"application/json;q=1.0;ext=x, text/html;q=0.9", "application/json"},
+		{"text/html;q=2", "application/json"}, // malformed: the first offer
+		{"text", "application/json"},
+		{"*/html", "application/json"},
+		{";q=0.5", "application/json"},
+		{"text/html;q=abc", "application/json"},
+		{"text/html, , text/plain", "text/html"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := Negotiate(r, offers...); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Add("Accept", "text/plain;q=0.1")
+	r.Header.Add("Accept", "text/html")
+	if got := Negotiate(r, offers...); got != "text/html" {
+		t.Errorf("Negotiate of two Accept headers = %q, want text/html", got)
+	}
+	if got := Negotiate(r); got != "" {
+		t.Errorf("Negotiate without offers = %q", got)
+	}
+}
+
+func TestHandleNegotiated(t *testing.T) {
+	router := NewRouter()
+	router.HandleNegotiated("/books/:id", http.MethodGet, map[string]http.Handler{
+		"text/html":        replyID("html"),
+		"application/json": replyID("json"),
+	})
+
+	tests := []struct {
+		accept string
+		code   int
+		body   string
+	}{
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", http.StatusOK, "html 7"},
+		{"application/json", http.StatusOK, "json 7"},
+		{"*/*", http.StatusOK, "json 7"},
+		{"text/*", http.StatusOK, "html 7"},
+		{"image/png", http.StatusNotAcceptable, "acceptable types: application/json, text/html\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/books/7", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("Accept %q answered %d %q, want %d %q", tt.accept, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept" {
+			t.Errorf("Accept %q: Vary %q", tt.accept, vary)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/books/7", nil)
+	r.Header.Set("Accept", "application/xml, application/problem+json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	var problem struct {
+		Status int
+		Offers []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || problem.Status != http.StatusNotAcceptable || len(problem.Offers) != 2 {
+		t.Errorf("406 problem = %q, %v", w.Body.String(), err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type Option func(router *Router)
+
+// WithoutAutoHead disables answering HEAD requests with the GET handler of
+// routes that have no explicit HEAD handler.
+func WithoutAutoHead() Option {
+	return func(router *Router) {
+		router.autoHead = false
+	}
+}
+
+// WithoutAutoOptions disables the generated 204 answer to OPTIONS requests on
+// routes that have no explicit OPTIONS handler.
+func WithoutAutoOptions() Option {
+	return func(router *Router) {
+		router.autoOptions = false
+	}
+}
+
+// WithRedirectTrailingSlash redirects "/about/" to "/about" when the latter
+// is a route, instead of serving both paths. GET and HEAD requests get a 301,
+// other methods a 308 so the method and body are kept.
+func WithRedirectTrailingSlash() Option {
+	return func(router *Router) {
+		router.redirectTrailingSlash = true
+	}
+}
+
+// WithRedirectFixedPath redirects a path with empty, "." or ".." segments,
+// such as "/a//b" or "/a/../b", to its cleaned form when the latter is a
+// route. Without it the cleaned path is served directly.
+func WithRedirectFixedPath() Option {
+	return func(router *Router) {
+		router.redirectFixedPath = true
+	}
+}
+
+// WithCaseInsensitive matches static path segments regardless of case, so
+// "/About" is served by "/about". Param values keep the caller's casing.
+func WithCaseInsensitive() Option {
+	return func(router *Router) {
+		router.caseInsensitive = true
+	}
+}
+
+// WithOverride lets Handle replace the handler of an already registered
+// path and method instead of returning an error.
+func WithOverride() Option {
+	return func(router *Router) {
+		router.override = true
+	}
+}
+
+// WithDefaultContentType gives the requests without a Content-Type the media
+// type mediaType for the routes registered with Consumes, they get a 415
+// otherwise.
+func WithDefaultContentType(mediaType string) Option {
+	return func(router *Router) {
+		router.defaultContentType = baseMediaType(mediaType)
+	}
+}
+
+// WithoutUnmatchedMiddleware restores running the middlewares only around
+// matched routes, leaving 404, 405 and trailing slash redirect answers
+// unwrapped.
+func WithoutUnmatchedMiddleware() Option {
+	return func(router *Router) {
+		router.wrapUnmatched = false
+	}
+}
+
+// WithProblemDetails answers the 404s and 405s of the router with
+// WriteError, so a client accepting JSON gets the same problem documents as
+// from the HandleE handlers. A handler passed to NotFound replaces the 404.
+func WithProblemDetails() Option {
+	return func(router *Router) {
+		router.problemDetails = true
+	}
+}
+
+// WithMaxBodyBytes sets the body size limit of BindJSON calls passing a
+// maxBytes of 0.
+func WithMaxBodyBytes(n int64) Option {
+	return func(router *Router) {
+		router.json.maxBytes = n
+	}
+}
+
+// WithStrictJSON makes BindJSON reject objects with fields that dst does not
+// have.
+func WithStrictJSON() Option {
+	return func(router *Router) {
+		router.json.strict = true
+	}
+}
+
+// WithEncodedPath matches routes against the escaped request path, so that
+// "/book/a%2Fb" is served by "/book/:id" with the id "a/b". Param regexes see
+// the escaped segment, Vars hold the unescaped values.
+func WithEncodedPath() Option {
+	return func(router *Router) {
+		router.encodedPath = true
+	}
+}
+
+// WithPanicLog replaces the log.Printf call of the default panic handler
+// with log, passed the request, the recovered value and the stack trace.
+func WithPanicLog(log func(r *http.Request, err any, stack []byte)) Option {
+	return func(router *Router) {
+		router.panicLog = log
+	}
+}
+
+// WithTracer starts a span with tracer for every request, before the
+// middlewares run so they find it in the request context.
+func WithTracer(tracer Tracer) Option {
+	return func(router *Router) {
+		router.tracer = tracer
+	}
+}
+
+// WithBaseContext derives the request contexts of the router from ctx as
+// well as from the context given by the server, http.Server.BaseContext
+// included: they carry the values of ctx, looked up first, and are cancelled
+// when either is. Cancelling ctx cancels the requests in flight. See
+// SetValue.
+func WithBaseContext(ctx context.Context) Option {
+	return func(router *Router) {
+		router.baseContext = ctx
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MethodOverride returns a middleware letting the POST requests of HTML
+// forms stand for one of methods, PUT, PATCH and DELETE by default, named by
+// the X-HTTP-Method-Override header or the _method form field. The other
+// methods are never overridden, nor is POST by a method not listed.
+// OriginalMethod returns the method that was sent. The router matches
+// r.Method before running the Use middlewares, so MethodOverride is added
+// with Pre: router.Pre(MethodOverride()).
+func MethodOverride(methods ...string) middleware {
+	if len(methods) == 0 {
+		methods = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	allowed := map[string]bool{}
+	for _, method := range methods {
+		allowed[strings.ToUpper(method)] = true
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				h.ServeHTTP(w, r)
+				return
+			}
+			method := r.Header.Get("X-HTTP-Method-Override")
+			if method == "" && isForm(r) {
+				method = r.PostFormValue("_method")
+			}
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if !allowed[method] {
+				h.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), methodKey, r.Method))
+			r.Method = method
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// OriginalMethod returns the method r was sent with, before MethodOverride.
+func OriginalMethod(r *http.Request) string {
+	if method, ok := r.Context().Value(methodKey).(string); ok {
+		return method
+	}
+	return r.Method
+}
+
+func isForm(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMethodOverride(t *testing.T) {
+	router := NewRouter()
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodPut} {
+		router.HandleFunc("/book", method, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Method + " from " + OriginalMethod(r)))
+		})
+	}
+	h := MethodOverride()(router)
+
+	tests := []struct {
+		name, method, header, field, want string
+	}{
+		{"form field", http.MethodPost, "", "delete", "DELETE from POST"},
+		{"header", http.MethodPost, "PUT", "", "PUT from POST"},
+		{"header first", http.MethodPost, "PUT", "DELETE", "PUT from POST"},
+		{"not allowed", http.MethodPost, "CONNECT", "", "POST from POST"},
+		{"none", http.MethodPost, "", "", "POST from POST"},
+		{"GET ignored", http.MethodGet, "DELETE", "", "GET from GET"},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		if tt.field != "" {
+			form.Set("_method", tt.field)
+		}
+		r := httptest.NewRequest(tt.method, "/book", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.header != "" {
+			r.Header.Set("X-HTTP-Method-Override", tt.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Body.String() != tt.want {
+			t.Errorf("%s: %s = %q, want %q", tt.name, tt.method, w.Body.String(), tt.want)
+		}
+	}
+}
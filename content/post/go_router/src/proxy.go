@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// ProxyOptions configures Proxy.
+type ProxyOptions struct {
+	// StripPrefix removes the prefix from the path before it is joined to the
+	// path of the target: "/api/users" under "/api" reaches "/users".
+	StripPrefix bool
+	// VarHeaders, when set, passes the vars captured by the prefix to the
+	// upstream as headers of that prefix: "X-Var-" sends the var id as
+	// X-Var-Id. The headers of the prefix sent by the client are removed.
+	VarHeaders string
+	// Transport sends the requests upstream, http.DefaultTransport by default.
+	Transport http.RoundTripper
+}
+
+// Proxy serves every request under prefix, whatever its method, with the
+// upstream target. The query is kept, the path is joined to the one of target
+// and the X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers are
+// set. Those sent by the client are only kept when its address is a trusted
+// proxy, see Router.SetTrustedProxies. Websocket upgrades are proxied too.
+// An upstream unreachable is answered with a 502 by the router ErrorHandler,
+// and one timing out with a 504. Under prefix, Param(r, "path") is the rest
+// of the path.
+func (g *Group) Proxy(prefix string, target *url.URL, opts ProxyOptions) error {
+	router := g.router
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			in, out := pr.In, pr.Out
+			if opts.StripPrefix {
+				out.URL.Path = "/" + Param(in, "path")
+				out.URL.RawPath = ""
+			}
+			if _, err := url.ParseQuery(in.URL.RawQuery); err == nil {
+				out.URL.RawQuery = in.URL.RawQuery // as sent, not reencoded by ReverseProxy
+			}
+			pr.SetURL(target)
+
+			trust := trustedPeer(in)
+			if trust {
+				out.Header["X-Forwarded-For"] = in.Header["X-Forwarded-For"]
+			}
+			pr.SetXForwarded()
+			if trust {
+				for _, name := range []string{"X-Forwarded-Host", "X-Forwarded-Proto"} {
+					if value := in.Header.Get(name); value != "" {
+						out.Header.Set(name, value)
+					}
+				}
+			}
+
+			if opts.VarHeaders != "" {
+				headerPrefix := http.CanonicalHeaderKey(opts.VarHeaders)
+				for name := range out.Header {
+					if strings.HasPrefix(name, headerPrefix) {
+						out.Header.Del(name)
+					}
+				}
+				for _, v := range RouteParams(in) {
+					if v.Name != "path" {
+						out.Header.Set(headerPrefix+v.Name, v.Value)
+					}
+				}
+			}
+		},
+		Transport: opts.Transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() != nil {
+				return // the client is gone
+			}
+			logError(w, r, err, "from the upstream")
+			httpErr := NewHTTPError(http.StatusBadGateway, "upstream unavailable")
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+				httpErr = NewHTTPError(http.StatusGatewayTimeout, "upstream timed out")
+			}
+			router.routes.Load().onError(w, r, httpErr)
+		},
+	}
+	h := func(w http.ResponseWriter, r *http.Request) {
+		proxy.ServeHTTP(proxyWriter{w}, r)
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	if err := g.Any(prefix+"/*path", h); err != nil {
+		return err
+	}
+	if prefix == "" {
+		prefix = "/"
+	}
+	return g.Any(prefix, h)
+}
+
+// trustedPeer reports whether r comes from a trusted proxy.
+func trustedPeer(r *http.Request) bool {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	proxies, _ := r.Context().Value(proxiesKey).([]netip.Prefix)
+	addr, err := netip.ParseAddr(remote)
+	return err == nil && trusted(proxies, addr)
+}
+
+// proxyWriter marks the response upgraded when the reverse proxy hijacks
+// the connection, for the middlewares to see its 101.
+type proxyWriter struct{ http.ResponseWriter }
+
+func (w proxyWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w proxyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		upgraded(w.ResponseWriter)
+	}
+	return conn, brw, err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// echoUpstream answers the path, query and headers it received as JSON.
+func echoUpstream(t *testing.T) *url.URL {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]any{
+			"method": r.Method,
+			"uri":    r.RequestURI,
+			"header": r.Header,
+		})
+	}))
+	t.Cleanup(upstream.Close)
+	target, _ := url.Parse(upstream.URL + "/base")
+	return target
+}
+
+type proxied struct {
+	Method string
+	URI    string
+	Header http.Header
+}
+
+func getProxied(t *testing.T, server *httptest.Server, method, path string, header map[string]string) proxied {
+	req, _ := http.NewRequest(method, server.URL+path, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var p proxied
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		t.Fatalf("%s %s: %d: %v", method, path, res.StatusCode, err)
+	}
+	return p
+}
+
+func TestProxyPath(t *testing.T) {
+	target := echoUpstream(t)
+	router := NewRouter()
+	router.Proxy("/keep", target, ProxyOptions{})
+	router.Proxy("/strip/", target, ProxyOptions{StripPrefix: true})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := []struct{ method, path, uri string }{
+		{"GET", "/keep/users/42?sort=name&q=a+b", "/base/keep/users/42?sort=name&q=a+b"},
+		{"DELETE", "/keep", "/base/keep"},
+		{"POST", "/strip/users/42?sort=name", "/base/users/42?sort=name"},
+		{"PATCH", "/strip", "/base/"},
+		{"GET", "/strip/?page=2", "/base/?page=2"},
+	}
+	for _, tt := range tests {
+		p := getProxied(t, server, tt.method, tt.path, nil)
+		if p.Method != tt.method || p.URI != tt.uri {
+			t.Errorf("%s %s reached %s %s, want %s", tt.method, tt.path, p.Method, p.URI, tt.uri)
+		}
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	target := echoUpstream(t)
+	router := NewRouter()
+	router.Proxy("/tenants/:tenant", target, ProxyOptions{StripPrefix: true, VarHeaders: "X-Var-"})
+	server := httptest.NewServer(router)
+	defer server.Close()
+	spoofed := map[string]string{
+		"X-Forwarded-For":   "203.0.113.7",
+		"X-Forwarded-Host":  "evil.example",
+		"X-Forwarded-Proto": "https",
+		"X-Var-Tenant":      "other",
+		"X-Var-Admin":       "1",
+	}
+
+	p := getProxied(t, server, "GET", "/tenants/acme/orders", spoofed)
+	if p.URI != "/base/orders" {
+		t.Errorf("reached %s, want /base/orders", p.URI)
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	for name, want := range map[string]string{
+		"X-Forwarded-For":   "127.0.0.1",
+		"X-Forwarded-Host":  host,
+		"X-Forwarded-Proto": "http",
+		"X-Var-Tenant":      "acme",
+		"X-Var-Admin":       "",
+		"X-Var-Path":        "",
+	} {
+		if got := strings.Join(p.Header[name], ", "); got != want {
+			t.Errorf("untrusted client: %s = %q, want %q", name, got, want)
+		}
+	}
+
+	router.SetTrustedProxies([]string{"127.0.0.1"})
+	p = getProxied(t, server, "GET", "/tenants/acme/orders", spoofed)
+	for name, want := range map[string]string{
+		"X-Forwarded-For":   "203.0.113.7, 127.0.0.1",
+		"X-Forwarded-Host":  "evil.example",
+		"X-Forwarded-Proto": "https",
+		"X-Var-Tenant":      "acme",
+	} {
+		if got := strings.Join(p.Header[name], ", "); got != want {
+			t.Errorf("trusted proxy: %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestProxyDeadUpstream(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target, _ := url.Parse(upstream.URL)
+	upstream.Close()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	router := NewRouter()
+	router.Proxy("/api", target, ProxyOptions{})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	r.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("dead upstream answered %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var handled error
+	router.ErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(http.StatusTeapot)
+	})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if w.Code != http.StatusTeapot || handled == nil || !strings.HasPrefix(handled.Error(), "502 ") {
+		t.Errorf("dead upstream answered %d, the error handler saw %v", w.Code, handled)
+	}
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	upstream, _ := newWSServer(t, func(conn *WSConn) {
+		opcode, data, err := conn.ReadMessage()
+		if err == nil {
+			conn.WriteMessage(opcode, append([]byte(conn.Request().URL.Path+": "), data...))
+		}
+		conn.ReadMessage() // the close
+	})
+	target, _ := url.Parse(upstream.URL)
+	logged := make(logLines, 100)
+	router := NewRouter()
+	router.Use(Logger(logged))
+	router.Proxy("/chat", target, ProxyOptions{StripPrefix: true})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := dialWS(t, server, "/chat/ws/lobby", nil)
+	if c.res.StatusCode != http.StatusSwitchingProtocols || c.res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake answered %d %v", c.res.StatusCode, c.res.Header)
+	}
+	c.write(true, WSText, []byte("hello"))
+	if opcode, payload := c.read(t); opcode != WSText || string(payload) != "/ws/lobby: hello" {
+		t.Errorf("echo = %d %q", opcode, payload)
+	}
+	c.write(true, wsClose, closePayload(WSCloseNormal, ""))
+	if code := c.readClose(t); code != WSCloseNormal {
+		t.Errorf("close echoed %d", code)
+	}
+	c.conn.Close()
+	if line := <-logged; !strings.Contains(line, " 101 ") {
+		t.Errorf("logged %q, want the 101", line)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"container/list"
+	"hash/maphash"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxLimiterKeys bounds the buckets kept by a Limiter.
+const maxLimiterKeys = 1 << 16
+
+// limiterShards is the number of independently locked parts of a Limiter,
+// so that the requests of different keys rarely wait for each other.
+const limiterShards = 64
+
+// Limiter is a token bucket rate limiter per client key. Its Middleware can
+// be shared by several routes, or each route can have its own Limiter.
+type Limiter struct {
+	rps   float64
+	burst float64
+	key   func(r *http.Request) string
+	now   func() time.Time
+
+	seed    maphash.Seed
+	shards  []*limiterShard
+	maxKeys int           // over all the shards
+	idle    time.Duration // time for an empty bucket to refill
+}
+
+// limiterShard holds the buckets of the keys hashed to it, the least
+// recently seen at the back, so that evicting one is O(1) however many keys
+// a scan of addresses brings.
+type limiterShard struct {
+	mu      sync.Mutex
+	buckets map[string]*list.Element // of *bucket
+	lru     list.List
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter allowing rps requests per second per key,
+// with bursts of burst requests. keyFn returns the key of a request, nil
+// uses ClientIP. It panics when rps is not positive or burst is below 1,
+// which would refuse every request.
+func NewLimiter(rps float64, burst int, keyFn func(r *http.Request) string) *Limiter {
+	if !(rps > 0) || math.IsInf(rps, 1) {
+		panic("router: Limiter rate must be positive and finite")
+	}
+	if burst < 1 {
+		panic("router: Limiter burst must be at least 1")
+	}
+	if keyFn == nil {
+		keyFn = ClientIP
+	}
+	l := &Limiter{
+		rps:     rps,
+		burst:   float64(burst),
+		key:     keyFn,
+		now:     time.Now,
+		seed:    maphash.MakeSeed(),
+		shards:  make([]*limiterShard, limiterShards),
+		maxKeys: maxLimiterKeys,
+		idle:    time.Duration(math.MaxInt64),
+	}
+	if idle := float64(burst) / rps * float64(time.Second); idle < math.MaxInt64 {
+		l.idle = time.Duration(idle)
+	}
+	for i := range l.shards {
+		l.shards[i] = &limiterShard{buckets: map[string]*list.Element{}}
+	}
+	return l
+}
+
+// RateLimit returns the Middleware of a new Limiter, see NewLimiter.
+func RateLimit(rps float64, burst int, keyFn func(r *http.Request) string) middleware {
+	return NewLimiter(rps, burst, keyFn).Middleware
+}
+
+// Middleware answers with a 429 and a Retry-After header the requests over
+// the limit of their key.
+func (l *Limiter) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.Allow(l.key(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Allow takes a token from the bucket of key. When it is empty, Allow
+// returns false and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	shard := l.shards[maphash.String(l.seed, key)%uint64(len(l.shards))]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := l.now()
+	shard.evict(now, l.idle, max(l.maxKeys/len(l.shards), 1))
+	var b *bucket
+	if e, ok := shard.buckets[key]; ok {
+		shard.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		b = &bucket{key: key, tokens: l.burst, last: now}
+		shard.buckets[key] = shard.lru.PushFront(b)
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evict drops the least recently seen buckets idle long enough to be full
+// again, which is the same as not having them, then the least recently seen
+// ones while the shard holds maxKeys or more, leaving room for a new key.
+// Every bucket examined but the last is dropped, so a scan of new keys costs
+// O(1) per key.
+func (shard *limiterShard) evict(now time.Time, idle time.Duration, maxKeys int) {
+	for e := shard.lru.Back(); e != nil; e = shard.lru.Back() {
+		b := e.Value.(*bucket)
+		if now.Sub(b.last) < idle && shard.lru.Len() < maxKeys {
+			return
+		}
+		shard.lru.Remove(e)
+		delete(shard.buckets, b.key)
+	}
+}
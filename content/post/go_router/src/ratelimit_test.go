@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock advanced by the tests.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	limiter := NewLimiter(2, 3, nil)
+	limiter.now = clock.now
+	router := NewRouter()
+	router.Get("/api", reply("ok"), limiter.Middleware)
+
+	get := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := get("10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d of the burst = %d", i, w.Code)
+		}
+	}
+	w := get("10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("request over the burst = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("another client = %d, want 200", w.Code)
+	}
+
+	clock.t = clock.t.Add(500 * time.Millisecond) // refills one token
+	if w := get("10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("after refill = %d, want 200", w.Code)
+	}
+	if w := get("10.0.0.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("after the refilled token = %d, want 429", w.Code)
+	}
+}
+
+func TestRateLimitConcurrent(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	limiter := NewLimiter(1, 10, func(r *http.Request) string { return "key" })
+	limiter.now = clock.now
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := limiter.Allow("key"); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed.Load() != 10 {
+		t.Errorf("%d concurrent requests allowed, want the burst of 10", allowed.Load())
+	}
+}
+
+func TestRateLimitEviction(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	limiter := NewLimiter(1, 2, nil)
+	limiter.now = clock.now
+	limiter.shards = limiter.shards[:1]
+	limiter.maxKeys = 100
+
+	for i := 0; i < 1000; i++ {
+		limiter.Allow(strconv.Itoa(i))
+		if n := limiterKeys(limiter); n > 100 {
+			t.Fatalf("%d buckets after %d keys, want at most 100", n, i+1)
+		}
+	}
+	if ok, _ := limiter.Allow("999"); !ok {
+		t.Error("the most recent key was evicted")
+	}
+
+	clock.t = clock.t.Add(2 * time.Second) // every bucket is full again
+	limiter.Allow("new")
+	if n := limiterKeys(limiter); n != 1 {
+		t.Errorf("%d buckets after the idle time, want only the new one", n)
+	}
+}
+
+func limiterKeys(l *Limiter) int {
+	n := 0
+	for _, shard := range l.shards {
+		shard.mu.Lock()
+		n += len(shard.buckets)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+func TestRateLimitScan(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	limiter := NewLimiter(1, 2, nil)
+	limiter.now = clock.now
+	limiter.maxKeys = 1 << 10
+
+	const scan = 200000
+	start := time.Now()
+	for i := 0; i < scan; i++ {
+		limiter.Allow(strconv.Itoa(i))
+	}
+	if n := limiterKeys(limiter); n > limiter.maxKeys {
+		t.Errorf("%d buckets after a scan of %d keys, want at most %d", n, scan, limiter.maxKeys)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("a scan of %d keys took %v", scan, elapsed)
+	}
+}
+
+func TestRateLimitRate(t *testing.T) {
+	for _, rps := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewLimiter(%v, 1, nil) did not panic", rps)
+				}
+			}()
+			NewLimiter(rps, 1, nil)
+		}()
+	}
+	for _, burst := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewLimiter(1, %d, nil) did not panic", burst)
+				}
+			}()
+			NewLimiter(1, burst, nil)
+		}()
+	}
+	limiter := NewLimiter(1e-300, 10, nil)
+	if limiter.idle <= 0 {
+		t.Errorf("idle time of a tiny rate = %v, want positive", limiter.idle)
+	}
+}
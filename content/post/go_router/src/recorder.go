@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// responseRecorder records the status code and the body size of a response,
+// for the middlewares logging or measuring it and for the panic handler. The
+// first status of 200 or more is the one sent, a later WriteHeader is dropped
+// like net/http does but without logging. Unwrap lets http.ResponseController
+// reach the wrapped ResponseWriter.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	onHeader func() // called once before the header is sent, see Sessions
+}
+
+// newResponseRecorder wraps w in a recorder serving exactly the optional
+// interfaces w implements among http.Flusher, http.Hijacker, io.ReaderFrom
+// and http.Pusher, so wrapping it keeps SSE, websockets and sendfile
+// working and lets handlers test for them. It returns the ResponseWriter to
+// pass on and its recorder, in one allocation.
+func newResponseRecorder(w http.ResponseWriter) (http.ResponseWriter, *responseRecorder) {
+	var can int
+	if _, ok := w.(http.Flusher); ok {
+		can |= canFlush
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		can |= canHijack
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		can |= canReadFrom
+	}
+	if _, ok := w.(http.Pusher); ok {
+		can |= canPush
+	}
+	switch can {
+	case canFlush:
+		c := &struct {
+			responseRecorder
+			flusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, _, _, _ = c.mixins()
+		return c, &c.responseRecorder
+	case canHijack:
+		c := &struct {
+			responseRecorder
+			hijacker
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		_, c.hijacker, _, _ = c.mixins()
+		return c, &c.responseRecorder
+	case canFlush | canHijack:
+		c := &struct {
+			responseRecorder
+			flusher
+			hijacker
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, c.hijacker, _, _ = c.mixins()
+		return c, &c.responseRecorder
+	case canReadFrom:
+		c := &struct {
+			responseRecorder
+			readerFrom
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		_, _, c.readerFrom, _ = c.mixins()
+		return c, &c.responseRecorder
+	case canFlush | canReadFrom:
+		c := &struct {
+			responseRecorder
+			flusher
+			readerFrom
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, _, c.readerFrom, _ = c.mixins()
+		return c, &c.responseRecorder
+	case canHijack | canReadFrom:
+		c := &struct {
+			responseRecorder
+			hijacker
+			readerFrom
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		_, c.hijacker, c.readerFrom, _ = c.mixins()
+		return c, &c.responseRecorder
+	case canFlush | canHijack | canReadFrom:
+		c := &struct {
+			responseRecorder
+			flusher
+			hijacker
+			readerFrom
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, c.hijacker, c.readerFrom, _ = c.mixins()
+		return c, &c.responseRecorder
+	case canPush:
+		c := &struct {
+			responseRecorder
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		_, _, _, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	case canFlush | canPush:
+		c := &struct {
+			responseRecorder
+			flusher
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, _, _, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	case canHijack | canPush:
+		c := &struct {
+			responseRecorder
+			hijacker
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		_, c.hijacker, _, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	case canFlush | canHijack | canPush:
+		c := &struct {
+			responseRecorder
+			flusher
+			hijacker
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, c.hijacker, _, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	case canReadFrom | canPush:
+		c := &struct {
+			responseRecorder
+			readerFrom
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		_, _, c.readerFrom, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	case canFlush | canReadFrom | canPush:
+		c := &struct {
+			responseRecorder
+			flusher
+			readerFrom
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, _, c.readerFrom, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	case canHijack | canReadFrom | canPush:
+		c := &struct {
+			responseRecorder
+			hijacker
+			readerFrom
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		_, c.hijacker, c.readerFrom, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	case canFlush | canHijack | canReadFrom | canPush:
+		c := &struct {
+			responseRecorder
+			flusher
+			hijacker
+			readerFrom
+			pusher
+		}{responseRecorder: responseRecorder{ResponseWriter: w}}
+		c.flusher, c.hijacker, c.readerFrom, c.pusher = c.mixins()
+		return c, &c.responseRecorder
+	}
+	rec := &responseRecorder{ResponseWriter: w}
+	return rec, rec
+}
+
+const (
+	canFlush = 1 << iota
+	canHijack
+	canReadFrom
+	canPush
+)
+
+// recorderOf returns the recorder of a ResponseWriter returned by
+// newResponseRecorder, or nil.
+func recorderOf(w http.ResponseWriter) *responseRecorder {
+	if r, ok := w.(interface{ recorder() *responseRecorder }); ok {
+		return r.recorder()
+	}
+	return nil
+}
+
+// upgraded records the 101 of a connection hijacked to switch protocols on
+// the recorders wrapping w, for the loggers and the panic handler.
+func upgraded(w http.ResponseWriter) {
+	for w != nil {
+		if rec := recorderOf(w); rec != nil && rec.status == 0 {
+			rec.status = http.StatusSwitchingProtocols
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+func (w *responseRecorder) recorder() *responseRecorder {
+	return w
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code) // 1xx answers precede the final one
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.begin(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// begin records code as the status sent, the header is sent next.
+func (w *responseRecorder) begin(code int) {
+	w.status = code
+	if onHeader := w.onHeader; onHeader != nil {
+		w.onHeader = nil
+		onHeader()
+	}
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.begin(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// mixins returns the optional methods of the recorder, each embedded by the
+// wrappers of newResponseRecorder serving its interface.
+func (w *responseRecorder) mixins() (flusher, hijacker, readerFrom, pusher) {
+	return flusher{w}, hijacker{w}, readerFrom{w}, pusher{w}
+}
+
+type flusher struct{ w *responseRecorder }
+
+func (f flusher) Flush() {
+	if f.w.status == 0 {
+		f.w.begin(http.StatusOK)
+	}
+	f.w.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijacker struct{ w *responseRecorder }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type readerFrom struct{ w *responseRecorder }
+
+func (rf readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	if rf.w.status == 0 {
+		rf.w.begin(http.StatusOK)
+	}
+	n, err := rf.w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	rf.w.size += n
+	return n, err
+}
+
+type pusher struct{ w *responseRecorder }
+
+func (p pusher) Push(target string, opts *http.PushOptions) error {
+	return p.w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
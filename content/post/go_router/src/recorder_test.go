@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeHijacker struct{}
+
+func (fakeHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hijacked")
+}
+
+type fakePusher struct{}
+
+func (fakePusher) Push(target string, opts *http.PushOptions) error {
+	return errors.New("pushed " + target)
+}
+
+func TestResponseRecorderInterfaces(t *testing.T) {
+	rec := httptest.NewRecorder()
+	type (
+		rw = http.ResponseWriter
+		f  = http.Flusher
+		h  = http.Hijacker
+		rf = io.ReaderFrom
+		p  = http.Pusher
+	)
+	var buf bytes.Buffer
+	writers := []http.ResponseWriter{
+		struct{ rw }{rec},
+		struct {
+			rw
+			f
+		}{rec, rec},
+		struct {
+			rw
+			h
+		}{rec, fakeHijacker{}},
+		struct {
+			rw
+			f
+			h
+		}{rec, rec, fakeHijacker{}},
+		struct {
+			rw
+			f
+			h
+			rf
+		}{rec, rec, fakeHijacker{}, &buf},
+		struct {
+			rw
+			f
+			p
+		}{rec, rec, fakePusher{}},
+		struct {
+			rw
+			f
+			h
+			rf
+			p
+		}{rec, rec, fakeHijacker{}, &buf, fakePusher{}},
+	}
+	interfaces := func(w http.ResponseWriter) string {
+		var s []string
+		if _, ok := w.(http.Flusher); ok {
+			s = append(s, "Flusher")
+		}
+		if _, ok := w.(http.Hijacker); ok {
+			s = append(s, "Hijacker")
+		}
+		if _, ok := w.(io.ReaderFrom); ok {
+			s = append(s, "ReaderFrom")
+		}
+		if _, ok := w.(http.Pusher); ok {
+			s = append(s, "Pusher")
+		}
+		return strings.Join(s, ",")
+	}
+	for _, w := range writers {
+		wrapped, r := newResponseRecorder(w)
+		if got, want := interfaces(wrapped), interfaces(w); got != want {
+			t.Errorf("recorder of a writer with [%s] has [%s]", want, got)
+		}
+		if recorderOf(wrapped) != r {
+			t.Errorf("recorderOf the recorder of [%s] = %p, want %p", interfaces(w), recorderOf(wrapped), r)
+		}
+		_, canFlush := w.(http.Flusher)
+		if err := http.NewResponseController(wrapped).Flush(); (err == nil) != canFlush {
+			t.Errorf("ResponseController.Flush on the recorder of [%s] = %v", interfaces(w), err)
+		}
+	}
+
+	wrapped, _ := newResponseRecorder(writers[len(writers)-1])
+	if _, _, err := wrapped.(http.Hijacker).Hijack(); err == nil || err.Error() != "hijacked" {
+		t.Errorf("Hijack = %v, want the error of the wrapped writer", err)
+	}
+	if err := wrapped.(http.Pusher).Push("/style.css", nil); err == nil || err.Error() != "pushed /style.css" {
+		t.Errorf("Push = %v, want the error of the wrapped writer", err)
+	}
+	if recorderOf(rec) != nil {
+		t.Error("recorderOf a plain writer is not nil")
+	}
+}
+
+func TestResponseRecorderWrites(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w, r := newResponseRecorder(rec)
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError) // dropped
+	w.Write([]byte("hello"))
+	if r.status != http.StatusCreated || r.size != 5 || rec.Code != http.StatusCreated {
+		t.Errorf("status %d, size %d, sent %d, want 201, 5, 201", r.status, r.size, rec.Code)
+	}
+
+	var buf bytes.Buffer
+	w, r = newResponseRecorder(struct {
+		http.ResponseWriter
+		io.ReaderFrom
+	}{rec, &buf})
+	n, err := w.(io.ReaderFrom).ReadFrom(strings.NewReader("abc"))
+	if n != 3 || err != nil || r.status != http.StatusOK || r.size != 3 || buf.String() != "abc" {
+		t.Errorf("ReadFrom = %d, %v with status %d and size %d", n, err, r.status, r.size)
+	}
+}
+
+// TestLoggerHijack upgrades a connection through the Logger middleware, as
+// a websocket handler does.
+func TestLoggerHijack(t *testing.T) {
+	var log bytes.Buffer
+	router := NewRouter()
+	router.Use(Logger(&log))
+	router.Get("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\nhi")
+		buf.Flush()
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+	got, _ := io.ReadAll(conn)
+	if !strings.HasPrefix(string(got), "HTTP/1.1 101") || !strings.HasSuffix(string(got), "hi") {
+		t.Errorf("upgraded connection read %q", got)
+	}
+}
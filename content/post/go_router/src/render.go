@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Renderer renders the template name with data, see Render.
+type Renderer interface {
+	Render(w io.Writer, name string, data any) error
+}
+
+// SetRenderer sets the renderer of the Render calls of the handlers of
+// router, and of the routers it mounts unless they have their own.
+func (router *Router) SetRenderer(renderer Renderer) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		t.renderer = renderer
+		return nil
+	})
+}
+
+// Render answers r with code and the template name rendered with data by
+// the renderer of the router, as text/html unless the handler set a
+// Content-Type. The template is rendered into a buffer before anything is
+// written, so a template error is answered with a plain 500, not with half
+// a page, and returned.
+func Render(w http.ResponseWriter, r *http.Request, code int, name string, data any) error {
+	renderer, _ := r.Context().Value(rendererKey).(Renderer)
+	if renderer == nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return errors.New("router: no renderer, see Router.SetRenderer")
+	}
+	buf := buffers.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+	var err error
+	if html, ok := renderer.(*HTMLRenderer); ok {
+		err = html.render(buf, name, data, CSPNonce(r))
+	} else {
+		err = renderer.Render(buf, name, data)
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return fmt.Errorf("router: rendering %s: %w", name, err)
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(code)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// HTMLOptions configures an HTMLRenderer.
+type HTMLOptions struct {
+	// Pages are the glob patterns of the templates rendered, a page is
+	// named by its path in the fs.FS, like "pages/index.html".
+	Pages []string
+	// Shared are the glob patterns of the layouts and partials parsed with
+	// every page, under their path too. A page can define the blocks of a
+	// layout without changing the other pages.
+	Shared []string
+	// Funcs are added to the template funcs, with "cspNonce" returning the
+	// nonce of SecureHeaders for the request rendered through Render.
+	Funcs template.FuncMap
+	// Dev parses the templates again on every render, to see the changed
+	// files without restarting.
+	Dev bool
+}
+
+// HTMLRenderer is a Renderer of html/template pages.
+type HTMLRenderer struct {
+	fsys  fs.FS
+	opts  HTMLOptions
+	pages map[string]*htmlPage
+}
+
+// NewHTMLRenderer parses the templates of fsys selected by opts, os.DirFS
+// reads them from a directory.
+func NewHTMLRenderer(fsys fs.FS, opts HTMLOptions) (*HTMLRenderer, error) {
+	renderer := &HTMLRenderer{fsys: fsys, opts: opts}
+	pages, err := renderer.parse()
+	if err != nil {
+		return nil, err
+	}
+	renderer.pages = pages
+	return renderer, nil
+}
+
+func (renderer *HTMLRenderer) Render(w io.Writer, name string, data any) error {
+	return renderer.render(w, name, data, "")
+}
+
+func (renderer *HTMLRenderer) render(w io.Writer, name string, data any, nonce string) error {
+	pages := renderer.pages
+	if renderer.opts.Dev {
+		var err error
+		if pages, err = renderer.parse(); err != nil {
+			return err
+		}
+	}
+	page, ok := pages[name]
+	if !ok {
+		return fmt.Errorf("router: no template %q", name)
+	}
+	return page.execute(w, name, data, nonce)
+}
+
+func (renderer *HTMLRenderer) parse() (map[string]*htmlPage, error) {
+	funcs := template.FuncMap{}
+	for name, f := range renderer.opts.Funcs {
+		funcs[name] = f
+	}
+	funcs["cspNonce"] = func() string { return "" } // replaced in every clone
+	shared := template.New("").Funcs(funcs)
+	names, err := glob(renderer.fsys, renderer.opts.Shared)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := parseFile(shared, renderer.fsys, name); err != nil {
+			return nil, err
+		}
+	}
+
+	names, err = glob(renderer.fsys, renderer.opts.Pages)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("router: no template matches %q", renderer.opts.Pages)
+	}
+	pages := make(map[string]*htmlPage, len(names))
+	for _, name := range names {
+		master, err := shared.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if err := parseFile(master, renderer.fsys, name); err != nil {
+			return nil, err
+		}
+		pages[name] = &htmlPage{master: master}
+	}
+	return pages, nil
+}
+
+// glob returns the sorted paths of fsys matching any of the patterns.
+func glob(fsys fs.FS, patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	var names []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("router: template pattern %q: %w", pattern, err)
+		}
+		for _, name := range matches {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func parseFile(t *template.Template, fsys fs.FS, name string) error {
+	text, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return fmt.Errorf("router: template %s: %w", name, err)
+	}
+	if _, err := t.New(name).Parse(string(text)); err != nil {
+		return fmt.Errorf("router: template %s: %w", name, err)
+	}
+	return nil
+}
+
+// htmlPage is a page and the templates it uses. html/template cannot clone
+// a template once executed, so master never is: the renders execute clones
+// of it, each used by one render at a time to pass it the nonce.
+type htmlPage struct {
+	master *template.Template
+	clones sync.Pool // of *htmlClone
+}
+
+type htmlClone struct {
+	t     *template.Template
+	nonce string
+}
+
+func (page *htmlPage) execute(w io.Writer, name string, data any, nonce string) error {
+	c, _ := page.clones.Get().(*htmlClone)
+	if c == nil {
+		t, err := page.master.Clone()
+		if err != nil {
+			return err
+		}
+		c = &htmlClone{t: t}
+		t.Funcs(template.FuncMap{"cspNonce": func() string { return c.nonce }})
+	}
+	c.nonce = nonce
+	err := c.t.ExecuteTemplate(w, name, data)
+	page.clones.Put(c)
+	return err
+}
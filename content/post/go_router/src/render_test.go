@@ -0,0 +1,116 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var templates = fstest.MapFS{
+	"layouts/base.html":  {Data: []byte(`<title>{{block "title" .}}Site{{end}}</title>{{template "content" .}}`)},
+	"partials/nav.html":  {Data: []byte(`<nav>{{.User}}</nav>`)},
+	"pages/home.html":    {Data: []byte(`{{template "layouts/base.html" .}}{{define "content"}}{{template "partials/nav.html" .}}home{{end}}`)},
+	"pages/about.html":   {Data: []byte(`{{template "layouts/base.html" .}}{{define "title"}}About{{end}}{{define "content"}}about{{end}}`)},
+	"pages/items.html":   {Data: []byte(`<p>start</p>{{index .Items 5}}`)},
+	"pages/script.html":  {Data: []byte(`<script nonce="{{cspNonce}}">go()</script>`)},
+	"pages/upper.html":   {Data: []byte(`{{upper .User}}`)},
+	"other/ignored.html": {Data: []byte(`ignored`)},
+}
+
+func newTestRenderer(t *testing.T) *Router {
+	renderer, err := NewHTMLRenderer(templates, HTMLOptions{
+		Pages:  []string{"pages/*.html"},
+		Shared: []string{"layouts/*.html", "partials/*.html"},
+		Funcs:  map[string]any{"upper": strings.ToUpper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter()
+	router.SetRenderer(renderer)
+	return router
+}
+
+func TestRender(t *testing.T) {
+	router := newTestRenderer(t)
+	router.Use(SecureHeaders(SecureHeadersOptions{ContentSecurityPolicy: "script-src 'nonce-{nonce}'"}))
+	var renderErr error
+	router.Get("/:page", func(w http.ResponseWriter, r *http.Request) {
+		renderErr = Render(w, r, http.StatusOK, "pages/"+Param(r, "page")+".html", map[string]any{"User": "ada", "Items": []int{1}})
+	})
+
+	tests := []struct {
+		page, body, err string
+		code            int
+	}{
+		{"home", "<title>Site</title><nav>ada</nav>home", "", http.StatusOK},
+		{"about", "<title>About</title>about", "", http.StatusOK}, // its blocks do not leak into home
+		{"upper", "ADA", "", http.StatusOK},
+		{"missing", "server error\n", `no template "pages/missing.html"`, http.StatusInternalServerError},
+		{"items", "server error\n", "index out of range", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		renderErr = nil
+		w := serve(router, http.MethodGet, "/"+tt.page)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET /%s = %d %q, want %d %q", tt.page, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if tt.err == "" && renderErr != nil || tt.err != "" && (renderErr == nil || !strings.Contains(renderErr.Error(), tt.err)) {
+			t.Errorf("GET /%s Render error = %v, want %q", tt.page, renderErr, tt.err)
+		}
+		if tt.code == http.StatusOK && w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("GET /%s Content-Type = %q", tt.page, w.Header().Get("Content-Type"))
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		w := serve(router, http.MethodGet, "/script")
+		csp := w.Header().Get("Content-Security-Policy")
+		nonce := strings.TrimSuffix(strings.TrimPrefix(csp, "script-src 'nonce-"), "'")
+		if nonce == "" || html.UnescapeString(w.Body.String()) != `<script nonce="`+nonce+`">go()</script>` {
+			t.Errorf("script page %q with the policy %q", w.Body.String(), csp)
+		}
+	}
+}
+
+func TestRenderWithoutRenderer(t *testing.T) {
+	router := NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if err := Render(w, r, http.StatusOK, "home", nil); err == nil {
+			t.Error("Render without a renderer succeeded")
+		}
+	})
+	if w := serve(router, http.MethodGet, "/"); w.Code != http.StatusInternalServerError {
+		t.Errorf("Render without a renderer answered %d", w.Code)
+	}
+	if _, err := NewHTMLRenderer(templates, HTMLOptions{Pages: []string{"nope/*.html"}}); err == nil {
+		t.Error("NewHTMLRenderer without pages succeeded")
+	}
+}
+
+func TestRenderDev(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	os.WriteFile(page, []byte("before"), 0o644)
+	renderer, err := NewHTMLRenderer(os.DirFS(dir), HTMLOptions{Pages: []string{"*.html"}, Dev: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter()
+	router.SetRenderer(renderer)
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		Render(w, r, http.StatusOK, "page.html", nil)
+	})
+
+	if w := serve(router, http.MethodGet, "/"); w.Body.String() != "before" {
+		t.Errorf("GET / = %q", w.Body.String())
+	}
+	os.WriteFile(page, []byte("after"), 0o644)
+	if w := serve(router, http.MethodGet, "/"); w.Body.String() != "after" {
+		t.Errorf("GET / after the change = %q, want the page reloaded", w.Body.String())
+	}
+}
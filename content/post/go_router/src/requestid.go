@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+const maxRequestIDLen = 128
+
+// RequestIDs is a middleware giving every request an ID, set as the
+// X-Request-Id response header and returned by RequestID. With trustHeader,
+// the X-Request-Id of the request is kept when it has at most 128 printable
+// ASCII characters, a new UUIDv4 is generated otherwise.
+func RequestIDs(trustHeader bool) middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get("X-Request-Id")
+			if !trustHeader || !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set("X-Request-Id", id)
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+		})
+	}
+}
+
+// RequestID returns the ID given to r by the RequestIDs middleware, or "".
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// requestID is RequestID for the code running outside the RequestIDs
+// middleware, such as the panic handler, which reads the response header.
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := RequestID(r); id != "" {
+		return id
+	}
+	return w.Header().Get("X-Request-Id")
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
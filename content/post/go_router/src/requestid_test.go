@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		trust   bool
+		inbound string
+		echoed  bool
+	}{
+		{true, "req-42", true},
+		{true, "", false},
+		{true, strings.Repeat("a", 129), false},
+		{true, "bad id", false},
+		{false, "req-42", false},
+	}
+	for _, tt := range tests {
+		router := NewRouter()
+		router.Use(RequestIDs(tt.trust))
+		var got string
+		router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			got = RequestID(r)
+		})
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.inbound != "" {
+			r.Header.Set("X-Request-Id", tt.inbound)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if header := w.Header().Get("X-Request-Id"); header != got {
+			t.Errorf("X-Request-Id %q, RequestID %q", header, got)
+		}
+		if tt.echoed && got != tt.inbound {
+			t.Errorf("trust %v, inbound %q: RequestID = %q, want it echoed", tt.trust, tt.inbound, got)
+		}
+		if !tt.echoed && !uuid.MatchString(got) {
+			t.Errorf("trust %v, inbound %.10q: RequestID = %q, want a new UUIDv4", tt.trust, tt.inbound, got)
+		}
+	}
+}
+
+func TestRequestIDLogged(t *testing.T) {
+	var out bytes.Buffer
+	router := NewRouter()
+	router.Use(Logger(&out))
+	router.Use(RequestIDs(true))
+	router.Get("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	r := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	r.Header.Set("X-Request-Id", "req-42")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !strings.Contains(out.String(), "GET /boom /boom 500 0B") || !strings.Contains(out.String(), "req-42\n") {
+		t.Errorf("access log misses the request ID:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "panic serving GET /boom [req-42]: boom") {
+		t.Errorf("panic log misses the request ID:\n%s", out.String())
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+)
+
+// RouteEntry is a route of the documents read by LoadRoutes and written by
+// DumpRoutes.
+type RouteEntry struct {
+	Path        string   `json:"path"`
+	Method      string   `json:"method"`
+	Handler     string   `json:"handler,omitempty"`
+	Middlewares []string `json:"middlewares,omitempty"` // the first one runs outermost
+}
+
+// LoadRoutes registers the routes of the JSON array of RouteEntry read from
+// r, their handler and middlewares named in handlers and middlewares. The
+// routes are registered together: an unknown name, an invalid path or a
+// conflict is returned with the line and index of its entry, and leaves the
+// router unchanged. YAML documents are to be converted to JSON first, the
+// router has no dependency to parse them.
+func (router *Router) LoadRoutes(r io.Reader, handlers map[string]http.Handler, middlewares map[string]func(http.Handler) http.Handler) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("router: routes: %w", err)
+	}
+	entries, lines, err := decodeRoutes(data)
+	if err != nil {
+		return err
+	}
+
+	wrapped := make([]http.Handler, len(entries))
+	for i, entry := range entries {
+		entryErr := func(err error) error {
+			return fmt.Errorf("router: routes line %d, entry %d: %w", lines[i], i, err)
+		}
+		if entry.Handler == "" {
+			return entryErr(errors.New("no handler named"))
+		}
+		h, ok := handlers[entry.Handler]
+		if !ok {
+			return entryErr(fmt.Errorf("unknown handler %q", entry.Handler))
+		}
+		mws := make([]middleware, len(entry.Middlewares))
+		for j, name := range entry.Middlewares {
+			if mws[j], ok = middlewares[name]; !ok {
+				return entryErr(fmt.Errorf("unknown middleware %q", name))
+			}
+		}
+		wrapped[i] = router.wrap(h, mws)
+	}
+
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.reject(router.update(func(t *table) error {
+		for i, entry := range entries {
+			if err := router.register(t, entry.Path, []string{entry.Method}, wrapped[i]); err != nil {
+				return fmt.Errorf("router: routes line %d, entry %d: %w", lines[i], i, err)
+			}
+		}
+		t.loaded = maps.Clone(t.loaded)
+		if t.loaded == nil {
+			t.loaded = map[string]RouteEntry{}
+		}
+		for _, entry := range entries {
+			segments, _ := router.parse(entry.Path)
+			entry.Method = normalize([]string{entry.Method})[0]
+			t.loaded[router.loadedKey(entry.Method, segments)] = entry
+		}
+		return nil
+	}))
+}
+
+// loadedKey returns the key of the route of method and segments among the
+// routes of LoadRoutes.
+func (router *Router) loadedKey(method string, segments []segment) string {
+	return method + " " + router.fold(segments)
+}
+
+// unload forgets the LoadRoutes entry of key, replaced or removed.
+func (t *table) unload(key string) {
+	if _, ok := t.loaded[key]; ok {
+		t.loaded = maps.Clone(t.loaded)
+		delete(t.loaded, key)
+	}
+}
+
+// decodeRoutes decodes the entries of data with the line each starts on.
+func decodeRoutes(data []byte) (entries []RouteEntry, lines []int, err error) {
+	line := func(offset int64) int {
+		rest := data[offset:]
+		offset += int64(len(rest) - len(bytes.TrimLeft(rest, " \t\r\n,")))
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	// decodeErr locates the syntax and type errors, the others are of the
+	// entry starting on line start.
+	decodeErr := func(err error, start int) error {
+		var syntax *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntax):
+			return fmt.Errorf("router: routes line %d: %w", line(syntax.Offset), err)
+		case errors.As(err, &typeErr):
+			return fmt.Errorf("router: routes line %d, entry %d: %w", line(typeErr.Offset), len(entries), err)
+		case errors.Is(err, io.EOF):
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("router: routes line %d, entry %d: %w", start, len(entries), err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, decodeErr(err, line(0))
+	}
+	if tok != json.Delim('[') {
+		return nil, nil, fmt.Errorf("router: routes line %d: not an array of routes", line(0))
+	}
+	for dec.More() {
+		start := line(dec.InputOffset())
+		var entry RouteEntry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, nil, decodeErr(err, start)
+		}
+		entries = append(entries, entry)
+		lines = append(lines, start)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, decodeErr(err, line(dec.InputOffset()))
+	}
+	return entries, lines, nil
+}
+
+// DumpRoutes writes the routes of the router as the JSON array LoadRoutes
+// reads, sorted like Routes. The handler and middlewares are named for the
+// routes registered by LoadRoutes only, and not replaced or removed since:
+// the others are written without a handler, which LoadRoutes rejects, so
+// only a router made by LoadRoutes loads its dump back.
+func (router *Router) DumpRoutes(w io.Writer) error {
+	router.mu.Lock()
+	loaded := router.routes.Load().loaded
+	entries := []RouteEntry{}
+	for _, route := range router.Routes() {
+		entry := RouteEntry{Path: route.Pattern, Method: route.Method}
+		if segments, err := router.parse(route.Pattern); err == nil {
+			if named, ok := loaded[router.loadedKey(route.Method, segments)]; ok {
+				entry = named
+			}
+		}
+		entries = append(entries, entry)
+	}
+	router.mu.Unlock()
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const routesJSON = `[
+  {"path": "/", "method": "GET", "handler": "home"},
+  {"path": "/book/:id:^[0-9]+$", "method": "get", "handler": "book", "middlewares": ["tag", "json"]},
+  {"path": "/book", "method": "POST", "handler": "book", "middlewares": ["json"]}
+]`
+
+func routeRegistries() (map[string]http.Handler, map[string]func(http.Handler) http.Handler) {
+	handlers := map[string]http.Handler{
+		"home": reply("home"),
+		"book": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("book " + Param(r, "id")))
+		}),
+	}
+	header := func(name, value string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add(name, value)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	middlewares := map[string]func(http.Handler) http.Handler{
+		"tag":  header("X-Tag", "book"),
+		"json": header("Content-Type", "application/json"),
+	}
+	return handlers, middlewares
+}
+
+func TestLoadRoutesRoundTrip(t *testing.T) {
+	handlers, middlewares := routeRegistries()
+	router := NewRouter()
+	if err := router.LoadRoutes(strings.NewReader(routesJSON), handlers, middlewares); err != nil {
+		t.Fatal(err)
+	}
+	var dumped bytes.Buffer
+	if err := router.DumpRoutes(&dumped); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewRouter()
+	if err := loaded.LoadRoutes(bytes.NewReader(dumped.Bytes()), handlers, middlewares); err != nil {
+		t.Fatalf("loading the dump: %v\n%s", err, dumped.String())
+	}
+	var again bytes.Buffer
+	if err := loaded.DumpRoutes(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != dumped.String() {
+		t.Errorf("dump of the loaded dump =\n%s\nwant\n%s", again.String(), dumped.String())
+	}
+	if got, want := routeTable(loaded), routeTable(router); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes() = %q, want %q", got, want)
+	}
+
+	for _, req := range [][2]string{{"GET", "/"}, {"GET", "/book/12"}, {"POST", "/book"}, {"GET", "/book/x"}} {
+		want, got := serve(router, req[0], req[1]), serve(loaded, req[0], req[1])
+		if got.Code != want.Code || got.Body.String() != want.Body.String() || !reflect.DeepEqual(got.Header(), want.Header()) {
+			t.Errorf("%s %s = %d %q %v, want %d %q %v", req[0], req[1],
+				got.Code, got.Body.String(), got.Header(), want.Code, want.Body.String(), want.Header())
+		}
+	}
+	if got := serve(loaded, "GET", "/book/12"); got.Body.String() != "book 12" || got.Header().Get("X-Tag") != "book" {
+		t.Errorf("GET /book/12 = %q %v, want the book handler with the tag middleware", got.Body.String(), got.Header())
+	}
+}
+
+func TestDumpRoutesUnnamed(t *testing.T) {
+	router := NewRouter()
+	router.Get("/ping", reply("pong"))
+	var dumped bytes.Buffer
+	if err := router.DumpRoutes(&dumped); err != nil {
+		t.Fatal(err)
+	}
+	want := "[\n  {\n    \"path\": \"/ping\",\n    \"method\": \"GET\"\n  }\n]\n"
+	if dumped.String() != want {
+		t.Errorf("DumpRoutes() =\n%s\nwant\n%s", dumped.String(), want)
+	}
+}
+
+func TestLoadRoutesErrors(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		want      []string
+	}{
+		{"unknown handler", `[
+  {"path": "/a", "method": "GET", "handler": "home"},
+  {"path": "/b", "method": "GET", "handler": "missing"}
+]`, []string{"line 3, entry 1", `unknown handler "missing"`}},
+		{"unknown middleware", `[{"path": "/a", "method": "GET", "handler": "home", "middlewares": ["json", "auth"]}]`,
+			[]string{"line 1, entry 0", `unknown middleware "auth"`}},
+		{"bad regex", `[
+  {"path": "/a", "method": "GET", "handler": "home"},
+  {"path": "/b/:id:[0-9", "method": "GET", "handler": "home"}
+]`, []string{"line 3, entry 1"}},
+		{"conflict", `[
+  {"path": "/a", "method": "GET", "handler": "home"},
+
+  {"path": "/a", "method": "GET", "handler": "book"}
+]`, []string{"line 4, entry 1"}},
+		{"unknown field", `[
+  {"path": "/a", "method": "GET", "handlr": "home"}
+]`, []string{"line 2, entry 0", "handlr"}},
+		{"syntax", "[\n  {\"path\": \"/a\",, }\n]", []string{"line 2"}},
+		{"not an array", `{"path": "/a"}`, []string{"line 1", "not an array"}},
+		{"truncated", `[{"path": "/a", "method": "GET", "handler": "home"}`, []string{"line 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers, middlewares := routeRegistries()
+			router := NewRouter()
+			router.Get("/ping", reply("pong"))
+			err := router.LoadRoutes(strings.NewReader(tt.doc), handlers, middlewares)
+			if err == nil {
+				t.Fatal("LoadRoutes() = nil, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("LoadRoutes() = %q, want it to contain %q", err, want)
+				}
+			}
+			if got := routeTable(router); !reflect.DeepEqual(got, []string{"GET /ping"}) {
+				t.Errorf("Routes() after the error = %q, want the router unchanged", got)
+			}
+		})
+	}
+}
+
+func TestDumpRoutesAfterChanges(t *testing.T) {
+	handlers, middlewares := routeRegistries()
+	router := NewRouter(WithOverride())
+	if err := router.LoadRoutes(strings.NewReader(routesJSON), handlers, middlewares); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Unhandle("/book", "POST"); err != nil {
+		t.Fatal(err)
+	}
+	router.Get("/", reply("replaced"))
+
+	var dumped bytes.Buffer
+	if err := router.DumpRoutes(&dumped); err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "path": "/",
+    "method": "GET"
+  },
+  {
+    "path": "/book/:id:^[0-9]+$",
+    "method": "GET",
+    "handler": "book",
+    "middlewares": [
+      "tag",
+      "json"
+    ]
+  }
+]
+`
+	if dumped.String() != want {
+		t.Errorf("DumpRoutes() =\n%s\nwant\n%s", dumped.String(), want)
+	}
+
+	err := NewRouter().LoadRoutes(&dumped, handlers, middlewares)
+	if err == nil || !strings.Contains(err.Error(), "entry 0: no handler named") {
+		t.Errorf("LoadRoutes() of a route not loaded = %v, want no handler named", err)
+	}
+}
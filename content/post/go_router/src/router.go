@@ -2,71 +2,944 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
+	"path"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// split returns the components of path, the root "/" has none and is served
+// by the root node of the trie.
 func split(path string) []string {
 	path = strings.TrimSpace(path)
 	path = strings.TrimPrefix(path, "/")
 	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return []string{}
+	}
 	return strings.Split(path, "/")
 }
 
+// segments iterates over the segments of a request path without allocating,
+// every segment is a substring of the path. Registration, where allocations
+// do not matter, uses split.
+type segments string
+
+// pathSegments returns the segments of path, without its leading and
+// trailing slash.
+func pathSegments(path string) segments {
+	path = strings.TrimPrefix(path, "/")
+	return segments(strings.TrimSuffix(path, "/"))
+}
+
+// next returns the first segment and the ones after it.
+func (s segments) next() (string, segments) {
+	if i := strings.IndexByte(string(s), '/'); i >= 0 {
+		return string(s[:i]), s[i+1:]
+	}
+	return string(s), ""
+}
+
+// skip drops the first n segments.
+func (s segments) skip(n int) segments {
+	for ; n > 0 && s != ""; n-- {
+		_, s = s.next()
+	}
+	return s
+}
+
 type middleware = func(h http.Handler) http.Handler
 
+// MiddlewareFunc adapts a middleware returning an http.HandlerFunc, so it can
+// be passed to Use.
+func MiddlewareFunc(m func(h http.Handler) http.HandlerFunc) middleware {
+	return func(h http.Handler) http.Handler {
+		return m(h)
+	}
+}
+
+// group is the root group of a Router, the alias keeps the embedded field from
+// clashing with the Group method.
+type group = Group
+
 type Router struct {
-	trie        *node
-	middlewares []middleware
+	*group
+
+	mu     sync.Mutex // serializes the writers of routes, guards names
+	routes atomic.Pointer[table]
+	names  map[string][]segment
+
+	opts        []Option
+	json        jsonConfig
+	autoHead    bool
+	autoOptions bool
+
+	wrapUnmatched         bool
+	problemDetails        bool
+	encodedPath           bool
+	redirectFixedPath     bool
+	redirectTrailingSlash bool
+	caseInsensitive       bool
+	override              bool
+	defaultContentType    string        // see WithDefaultContentType
+	defaultDeadline       time.Duration // see WithDefaultDeadline
+
+	panicLog func(r *http.Request, err any, stack []byte) // see WithPanicLog
+	tracer   Tracer                                       // see WithTracer
+
+	baseContext context.Context // see WithBaseContext
+
+	inFlight atomic.Int64 // see Stats
+	draining atomic.Bool  // see Draining
+
+	cacheSize   int // see WithMatchCache
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+
+	compiled bool    // see Compile, guarded by mu
+	rejected []error // registrations that failed, guarded by mu
 }
 
-func NewRouter() *Router {
-	return &Router{
-		trie:        newNode(),
-		middlewares: []middleware{},
+// table is what a request is served with. It is never modified once served:
+// writers update a copy under Router.mu and swap it in, so ServeHTTP reads it
+// without locking.
+type table struct {
+	trie          *node
+	static        map[string]*node // see match, nil with WithEncodedPath
+	cache         *matchCache      // nil without WithMatchCache
+	hosts         map[string]*Router
+	notFound      http.Handler
+	notFoundChain http.Handler // notFound wrapped in the middlewares
+	notFoundSet   bool         // notFound is not the default, see ServeFileDownload
+	onPanic       func(w http.ResponseWriter, r *http.Request, err any)
+	onError       func(w http.ResponseWriter, r *http.Request, err error) // see HandleE
+	middlewares   []use
+	proxies       []netip.Prefix  // see SetTrustedProxies
+	renderer      Renderer        // see SetRenderer
+	base          context.Context // see withBase, nil without a base context or values
+	pre           []middleware
+	preChain      http.Handler          // dispatch wrapped in pre, nil without pre
+	generation    uint64                // see Swap
+	loaded        map[string]RouteEntry // see loadedKey, replaced rather than modified
+}
+
+// clone returns a copy of t that can be modified while t is served, except
+// for the trie which is shared: see clonePath.
+func (t *table) clone() *table {
+	c := *t
+	c.hosts = make(map[string]*Router, len(t.hosts))
+	for host, sub := range t.hosts {
+		c.hosts[host] = sub
+	}
+	c.middlewares = append([]use{}, t.middlewares...)
+	c.pre = append([]middleware(nil), t.pre...)
+	return &c
+}
+
+func NewRouter(opts ...Option) *Router {
+	router := &Router{
+		names:       map[string][]segment{},
+		opts:        opts,
+		autoHead:    true,
+		autoOptions: true,
+
+		wrapUnmatched: true,
+	}
+	router.routes.Store(&table{
+		trie:          newNode(),
+		hosts:         map[string]*Router{},
+		notFound:      http.NotFoundHandler(),
+		notFoundChain: http.NotFoundHandler(),
+		onPanic:       router.defaultPanicHandler,
+		onError:       defaultErrorHandler,
+		middlewares:   []use{},
+	})
+	router.group = &Group{router: router}
+	for _, opt := range opts {
+		opt(router)
 	}
+	if router.baseContext != nil {
+		router.routes.Load().base = router.baseContext
+	}
+	if router.problemDetails {
+		t := router.routes.Load()
+		t.notFound = errorHandler(ErrNotFound)
+		t.notFoundChain, t.notFoundSet = t.notFound, true
+	}
+	return router
+}
+
+// NotFound replaces the handler of requests matching no route. It sees the
+// same request context as route handlers, with empty Vars.
+func (router *Router) NotFound(h http.Handler) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		t.notFound, t.notFoundChain, t.notFoundSet = h, t.wrap(h), true
+		return nil
+	})
+}
+
+func (router *Router) notFoundHandler() http.Handler {
+	return router.routes.Load().notFound
+}
+
+// update applies fn to a copy of the served table, and serves the copy when
+// fn succeeds so that a failed change is never partially visible. The caller
+// holds router.mu. It fails with ErrCompiled on a compiled router.
+func (router *Router) update(fn func(t *table) error) error {
+	if router.compiled {
+		return ErrCompiled
+	}
+	t := router.routes.Load().clone()
+	if err := fn(t); err != nil {
+		return err
+	}
+	if !router.encodedPath {
+		t.static = map[string]*node{}
+		t.trie.statics(nil, t.static)
+	}
+	if router.cacheSize > 0 {
+		t.cache = newMatchCache(router.cacheSize)
+	}
+	if len(t.pre) > 0 {
+		t.preChain = chain(router.dispatcher(t), t.pre)
+	}
+	router.routes.Store(t)
+	return nil
+}
+
+// PanicHandler replaces the handler called with the value recovered from a
+// panicking handler. The default one logs the value and the stack trace, see
+// WithPanicLog, and answers with a 500. http.ErrAbortHandler is never passed
+// to h, it is re-panicked so net/http aborts the connection silently.
+func (router *Router) PanicHandler(h func(w http.ResponseWriter, r *http.Request, err any)) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		t.onPanic = h
+		return nil
+	})
+}
+
+// defaultPanicHandler passes the panic and the stack trace to the
+// WithPanicLog function, then answers with a 500 when the header was not
+// sent yet. Otherwise the response cannot be fixed, the connection is
+// aborted so the client does not take it for complete.
+func (router *Router) defaultPanicHandler(w http.ResponseWriter, r *http.Request, err any) {
+	stack := debug.Stack()
+	if router.panicLog != nil {
+		router.panicLog(r, err, stack)
+	} else {
+		logPanic(w, r, err, stack)
+	}
+	if rec := recorderOf(w); rec != nil && rec.status != 0 {
+		panic(http.ErrAbortHandler)
+	}
+	http.Error(w, "server error", http.StatusInternalServerError)
+}
+
+func logPanic(w http.ResponseWriter, r *http.Request, err any, stack []byte) {
+	id := ""
+	if rid := requestID(w, r); rid != "" {
+		id = " [" + rid + "]"
+	}
+	log.Printf("router: panic serving %s %s%s: %v\n%s", r.Method, r.URL.Path, id, err, stack)
+}
+
+// Use adds m to the middlewares wrapping every answer of the router, they run
+// in the order they were added: the first added runs outermost. The handlers
+// of the routes are wrapped once, at registration time and again on every
+// call to Use.
+func (router *Router) Use(m middleware) error {
+	return router.useAt(-1, use{m: m})
+}
+
+// UseFirst adds m before the middlewares already added, so it runs outermost
+// like a recover or logging middleware must, whenever it is added.
+func (router *Router) UseFirst(m middleware) error {
+	return router.useAt(0, use{m: m})
+}
+
+// UseExcept is like Use but m does not wrap the routes listed, each written
+// as registered with or without a method: "GET /health", "/public/:file".
+// The routes are skipped when their handler is wrapped, so m costs nothing
+// on them. A GET route skipped also skips the HEAD requests it answers.
+func (router *Router) UseExcept(m middleware, routes ...string) error {
+	return router.useAt(-1, use{m: m, except: routeSet(routes)})
 }
 
-func (router *Router) Use(m middleware) {
-	router.middlewares = append(router.middlewares, m)
+// routeSet returns the set of routes written "METHOD pattern" or "pattern".
+func routeSet(routes []string) map[string]bool {
+	set := map[string]bool{}
+	for _, route := range routes {
+		if method, path, ok := strings.Cut(route, " "); ok {
+			route = strings.ToUpper(method) + " " + strings.TrimSpace(path)
+		}
+		set[route] = true
+	}
+	return set
+}
+
+// UseNamed is like Use, and names m so it can be referred to by UseBefore,
+// ReplaceMiddleware and RemoveMiddleware, for instance to stub an auth
+// middleware in tests. The names are unique.
+func (router *Router) UseNamed(name string, m middleware) error {
+	return router.editMiddlewares(func(mws []use) ([]use, error) {
+		if name == "" {
+			return nil, fmt.Errorf("router: empty middleware name")
+		}
+		if indexOf(mws, name) >= 0 {
+			return nil, fmt.Errorf("router: middleware %q already used", name)
+		}
+		return append(mws, use{m: m, name: name}), nil
+	})
+}
+
+// UseBefore adds m just before the middleware named name, so m runs outside
+// of it.
+func (router *Router) UseBefore(name string, m middleware) error {
+	return router.editMiddlewares(func(mws []use) ([]use, error) {
+		i := indexOf(mws, name)
+		if i < 0 {
+			return nil, fmt.Errorf("router: no middleware named %q", name)
+		}
+		return append(mws[:i:i], append([]use{{m: m}}, mws[i:]...)...), nil
+	})
+}
+
+// ReplaceMiddleware replaces the middleware named name with m, in the same
+// position and skipping the same routes.
+func (router *Router) ReplaceMiddleware(name string, m middleware) error {
+	return router.editMiddlewares(func(mws []use) ([]use, error) {
+		i := indexOf(mws, name)
+		if i < 0 {
+			return nil, fmt.Errorf("router: no middleware named %q", name)
+		}
+		mws[i].m = m
+		return mws, nil
+	})
+}
+
+// RemoveMiddleware removes the middleware named name.
+func (router *Router) RemoveMiddleware(name string) error {
+	return router.editMiddlewares(func(mws []use) ([]use, error) {
+		i := indexOf(mws, name)
+		if i < 0 {
+			return nil, fmt.Errorf("router: no middleware named %q", name)
+		}
+		return append(mws[:i:i], mws[i+1:]...), nil
+	})
+}
+
+// use is a router middleware, its name if any, and the routes it skips.
+type use struct {
+	m      middleware
+	name   string
+	except map[string]bool // "METHOD pattern" or "pattern"
+}
+
+func (u use) skips(method, pattern string) bool {
+	return u.except[pattern] || u.except[method+" "+pattern]
+}
+
+func indexOf(mws []use, name string) int {
+	for i, u := range mws {
+		if u.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// useAt inserts u at index i of the middlewares, or appends it when i < 0.
+func (router *Router) useAt(i int, u use) error {
+	return router.editMiddlewares(func(mws []use) ([]use, error) {
+		if i < 0 {
+			i = len(mws)
+		}
+		return append(mws[:i:i], append([]use{u}, mws[i:]...)...), nil
+	})
+}
+
+// editMiddlewares replaces the middlewares with the result of edit, then
+// wraps the handlers of the routes again.
+func (router *Router) editMiddlewares(edit func(mws []use) ([]use, error)) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		mws, err := edit(t.middlewares)
+		if err != nil {
+			return err
+		}
+		t.middlewares = mws
+		t.trie = rechain(t.trie, t.wrapRoute)
+		t.notFoundChain = t.wrap(t.notFound)
+		return nil
+	})
+}
+
+func (router *Router) handle(path string, methods []string, h http.Handler) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.reject(router.update(func(t *table) error {
+		return router.register(t, path, methods, h)
+	}))
+}
+
+// register adds h for the path and methods to t. The route is only added once
+// the whole path is valid and none of the methods conflicts with an existing
+// route, an error is returned otherwise.
+func (router *Router) register(t *table, path string, methods []string, h http.Handler) error {
+	if len(methods) == 0 {
+		return fmt.Errorf("router: no method for %s", path)
+	}
+	methods = normalize(methods)
+	segments, err := router.parse(path)
+	if err != nil {
+		return err
+	}
+	existing, err := t.trie.find(segments)
+	if err != nil {
+		return fmt.Errorf("%w in %s %s", err, strings.Join(methods, ","), path)
+	}
+	for _, method := range methods {
+		if method == "" {
+			return fmt.Errorf("router: empty method for %s", path)
+		}
+		if existing == nil || router.override {
+			continue
+		}
+		if old, ok := existing.handlers[method]; ok && conflicting(old, h) {
+			return fmt.Errorf("router: %s %s conflicts with existing %s %s", method, path, method, existing.pattern)
+		}
+	}
+
+	t.trie = clonePath(t.trie, segments)
+	node := t.trie.append(segments)
+	if node.pattern == "" {
+		node.pattern = path
+	}
+	for _, method := range methods {
+		t.unload(router.loadedKey(method, segments))
+		h := h
+		if old, ok := node.handlers[method]; ok {
+			h = withRoutes(old, h, router.defaultContentType)
+		}
+		node.handle(method, h, t.wrapRoute(h, method, node.pattern))
+	}
+	return nil
+}
+
+// normalize upper-cases methods and drops the duplicates.
+func normalize(methods []string) []string {
+	seen := map[string]bool{}
+	normalized := make([]string, 0, len(methods))
+	for _, method := range methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if !seen[method] {
+			seen[method] = true
+			normalized = append(normalized, method)
+		}
+	}
+	return normalized
+}
+
+// parse parses path into the segments stored in the trie, lower-casing static
+// segments for a case-insensitive router.
+func (router *Router) parse(path string) ([]segment, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if router.caseInsensitive {
+		for i, seg := range segments {
+			if seg.regex == nil && !seg.wildcard {
+				segments[i].text = strings.ToLower(seg.text)
+			}
+		}
+	}
+	return segments, nil
+}
+
+// Unhandle removes the handler of path and method, and prunes the trie nodes
+// left without routes. The method is matched like the registered ones, in
+// any case. It can be called while the router is serving.
+func (router *Router) Unhandle(path, method string) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	method = strings.ToUpper(strings.TrimSpace(method))
+	segments, err := router.parse(path)
+	if err != nil {
+		return err
+	}
+	return router.update(func(t *table) error {
+		node, _ := t.trie.find(segments)
+		if node == nil || node.handlers[method] == nil {
+			return fmt.Errorf("router: no route %s %s", method, path)
+		}
+		t.trie = clonePath(t.trie, segments)
+		node, _ = t.trie.find(segments)
+		t.trie.remove(segments, method)
+		t.unload(router.loadedKey(method, segments))
+		if len(node.handlers) == 0 {
+			for name, named := range router.names {
+				if router.fold(named) == router.fold(segments) {
+					delete(router.names, name)
+				}
+			}
+		}
+		return nil
+	})
 }
 
-func (router *Router) Handle(path, method string, h http.Handler) {
-	node := router.trie.append(split(path))
-	node.handlers[method] = h
+// fold returns the trie key of segments.
+func (router *Router) fold(segments []segment) string {
+	texts := make([]string, len(segments))
+	for i, seg := range segments {
+		texts[i] = seg.text
+		if router.caseInsensitive && seg.regex == nil && !seg.wildcard {
+			texts[i] = strings.ToLower(seg.text)
+		}
+	}
+	return strings.Join(texts, "/")
 }
 
 func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	router.serve(router.routes.Load(), w, r)
+}
+
+// serve serves r with the routes of t, through the Pre middlewares.
+func (router *Router) serve(t *table, w http.ResponseWriter, r *http.Request) {
+	router.inFlight.Add(1)
+	defer router.inFlight.Add(-1)
+	if t.base != nil {
+		var stop func()
+		r, stop = withBase(r, t.base)
+		defer stop()
+	}
+	if t.preChain == nil {
+		router.dispatch(t, w, r)
+		return
+	}
+	rw, _ := newResponseRecorder(w)
 	defer func() {
 		if err := recover(); err != nil {
-			http.Error(w, "server error", http.StatusInternalServerError)
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			t.onPanic(rw, r, err)
 		}
 	}()
+	t.preChain.ServeHTTP(rw, r)
+}
+
+// SetValue attaches value under key to the request contexts of the router,
+// for the dependencies of the handlers like a database pool. The values are
+// kept in one context, the parent of the request contexts built once here,
+// so a request is not given a context per value.
+func (router *Router) SetValue(key, value any) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		parent := t.base
+		if parent == nil {
+			parent = context.Background()
+		}
+		t.base = context.WithValue(parent, key, value)
+		return nil
+	})
+}
 
-	handler := http.NotFoundHandler()
-	vars := map[string]string{}
+// baseContext is the context of a request to a router with a base context
+// or values, looking up values in base before the request context.
+type baseContext struct {
+	context.Context
+	base context.Context
+}
+
+func (c *baseContext) Value(key any) any {
+	if value := c.base.Value(key); value != nil {
+		return value
+	}
+	return c.Context.Value(key)
+}
 
-	if h := router.match(r, vars); h != nil {
-		handler = h
-		for _, m := range router.middlewares {
-			handler = m(handler)
+// withBase returns r with a context carrying the values of base, and
+// cancelled with it when base can be, and the func releasing the context
+// once r is served.
+func withBase(r *http.Request, base context.Context) (*http.Request, func()) {
+	ctx, stop := r.Context(), func() {}
+	if base.Done() != nil {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		unregister := context.AfterFunc(base, func() { cancel(context.Cause(base)) })
+		stop = func() {
+			unregister()
+			cancel(nil)
 		}
 	}
+	return r.WithContext(&baseContext{Context: ctx, base: base}), stop
+}
+
+// Pre adds m to the middlewares running before the request is matched, so
+// they can rewrite its path, host or method: a route is matched against the
+// request they pass on. They run for every request, in the order they were
+// added, and their panics reach the panic handler too.
+func (router *Router) Pre(m middleware) error {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.update(func(t *table) error {
+		t.pre = append(t.pre, m)
+		return nil
+	})
+}
 
-	ctx := context.WithValue(r.Context(), "vars", vars)
-	handler.ServeHTTP(w, r.WithContext(ctx))
+// dispatcher returns the handler matching the requests against t, wrapped
+// in the Pre middlewares.
+func (router *Router) dispatcher(t *table) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.dispatch(t, w, r)
+	})
 }
 
-func (router *Router) match(r *http.Request, vars map[string]string) http.Handler {
-	if node := router.trie.search(split(r.URL.Path), vars); node != nil {
-		return node.handlers[r.Method]
+// dispatch serves r with the route of t it matches.
+func (router *Router) dispatch(t *table, w http.ResponseWriter, r *http.Request) {
+	rw, rec := newResponseRecorder(w) // tells the panic handler whether the header was sent
+	var (
+		span     Span
+		panicked any
+	)
+	defer func() {
+		if span != nil {
+			endSpan(span, rec.status, panicked) // even when the panic handler aborts
+		}
+	}()
+	defer func() {
+		if err := recover(); err != nil {
+			panicked = err
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			t.onPanic(rw, r, err)
+		}
+	}()
+
+	var buf [4]Var
+	handler, node, params := router.route(t, r, buf[:0])
+
+	ctx := r.Context()
+	var pattern string
+	if node != nil {
+		pattern = node.pattern
+		mount, mounted := ctx.Value(routeKey).(*routeContext)
+		if mounted && mount.node == nil {
+			pattern = joinPattern(mount.pattern, node.pattern)
+		}
+		if span, ok := ctx.Value(mountSpanKey).(Span); ok {
+			nameSpan(span, r.Method, pattern) // of the router this one is mounted on
+		}
+		r.Pattern = pattern // like http.ServeMux, for the middlewares
+		if len(params) > 0 {
+			c := &paramsContext{routeContext: routeContext{Context: ctx, router: router, node: node, pattern: pattern}}
+			c.params = append(c.buf[:0], params...) // copied, buf stays on the stack
+			ctx = &c.routeContext
+		} else {
+			ctx = &routeContext{Context: ctx, router: router, node: node, pattern: pattern}
+		}
+	} else if len(params) > 0 {
+		ctx = context.WithValue(ctx, varsKey, append(Params(nil), params...)) // captured by a mount prefix
 	}
-	return nil
+	if router.json != (jsonConfig{}) {
+		ctx = context.WithValue(ctx, jsonKey, router.json)
+	}
+	if t.proxies != nil {
+		ctx = context.WithValue(ctx, proxiesKey, t.proxies)
+	}
+	if t.renderer != nil {
+		ctx = context.WithValue(ctx, rendererKey, t.renderer)
+	}
+	if t.notFoundSet {
+		ctx = context.WithValue(ctx, notFoundKey, t.notFound)
+	}
+	if router.tracer != nil {
+		ctx, span = startSpan(ctx, router.tracer, r, pattern)
+		if node == nil {
+			ctx = context.WithValue(ctx, mountSpanKey, span) // named by a mounted router
+		}
+	}
+	if ctx != r.Context() {
+		r = r.WithContext(ctx)
+	}
+	handler.ServeHTTP(rw, r)
+}
+
+// route returns the handler serving r wrapped in the middlewares, and the
+// node of the route when one matched, with the vars captured appended to
+// params. Without wrapUnmatched, the not found, redirect and 405 handlers are
+// not wrapped.
+func (router *Router) route(t *table, r *http.Request, params Params) (http.Handler, *node, Params) {
+	if sub, ok := t.hosts[hostname(r.Host)]; ok {
+		return t.wrap(sub), nil, params
+	}
+
+	node, params := router.match(t, r, params)
+	if node == nil {
+		if router.wrapUnmatched {
+			return t.notFoundChain, nil, params
+		}
+		return t.notFound, nil, params
+	}
+	if node.mount != nil {
+		return t.wrap(router.mountHandler(node)), nil, params
+	}
+	if target := router.redirect(r); target != "" {
+		return router.unmatched(t, redirectHandler(target, r)), nil, params
+	}
+	if h, ok := node.chains[r.Method]; ok {
+		return h, node, params
+	}
+	if h := router.handler(node, r.Method); h != nil {
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet // answered by the GET handler
+		}
+		return t.wrapRoute(h, method, node.pattern), node, params
+	}
+	return router.unmatched(t, router.methodNotAllowed(router.allowed(node))), nil, params
+}
+
+func (router *Router) unmatched(t *table, h http.Handler) http.Handler {
+	if router.wrapUnmatched {
+		return t.wrap(h)
+	}
+	return h
+}
+
+func (t *table) wrap(h http.Handler) http.Handler {
+	return t.wrapRoute(h, "", "")
+}
+
+// wrapRoute wraps the handler of method on the route pattern in the
+// middlewares not skipping it.
+func (t *table) wrapRoute(h http.Handler, method, pattern string) http.Handler {
+	for i := len(t.middlewares) - 1; i >= 0; i-- {
+		if u := t.middlewares[i]; pattern == "" || !u.skips(method, pattern) {
+			h = u.m(h)
+		}
+	}
+	return h
 }
 
-func Vars(r *http.Request) map[string]string {
-	if vars := r.Context().Value("vars"); vars != nil {
-		return vars.(map[string]string) // type cast
+// match returns the trie node for the request path, or nil when no route
+// is registered on it, and params with the vars captured appended. A route
+// made of static segments only is looked up in t.static before the trie, it
+// would win over the params there anyway, then the path is looked up in the
+// match cache. The node may still lack a handler for r.Method.
+func (router *Router) match(t *table, r *http.Request, params Params) (*node, Params) {
+	params = append(params, RouteParams(r)...) // set by a parent router this one is mounted on
+	path := cleanPath(router.path(r))
+	if node, ok := t.static[router.staticKey(path)]; ok {
+		return node, params
+	}
+	if t.cache != nil {
+		if e, ok := t.cache.get(path); ok {
+			router.cacheHits.Add(1)
+			return e.node, append(params, e.params...)
+		}
+		router.cacheMisses.Add(1)
+	}
+	base := len(params)
+	mode := searchMode{fold: router.caseInsensitive, unescape: router.encodedPath}
+	node, params := t.trie.search(pathSegments(path), params, mode)
+	if node != nil && (len(node.handlers) > 0 || node.mount != nil) {
+		if t.cache != nil {
+			t.cache.add(path, node, params[base:])
+		}
+		return node, params
+	}
+	return nil, params
+}
+
+// staticKey returns the key of the cleaned path in the static routes, which
+// ignore the trailing slash like the trie.
+func (router *Router) staticKey(path string) string {
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if router.caseInsensitive {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// path returns the path matched against the trie.
+func (router *Router) path(r *http.Request) string {
+	if router.encodedPath {
+		return r.URL.EscapedPath()
+	}
+	return r.URL.Path
+}
+
+// redirect returns the path a matched request is redirected to by
+// WithRedirectFixedPath and WithRedirectTrailingSlash, or "" when it is
+// served as is.
+func (router *Router) redirect(r *http.Request) string {
+	path := router.path(r)
+	target := path
+	if router.redirectFixedPath {
+		target = cleanPath(path)
+	}
+	if router.redirectTrailingSlash && target != "/" && strings.HasSuffix(target, "/") {
+		target = "/" + strings.Trim(target, "/")
+	}
+	if target == path {
+		return ""
+	}
+	return target
+}
+
+// cleanPath resolves the empty, "." and ".." segments of p like path.Clean,
+// a ".." cannot go above the root. The trailing slash of p is kept.
+func cleanPath(p string) string {
+	if isClean(p) {
+		return p
+	}
+	cleaned := path.Clean("/" + p)
+	if cleaned != "/" && strings.HasSuffix(p, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// isClean reports whether p is rooted and has no empty, "." or ".." segment
+// but the trailing one, so cleanPath returns it without allocating.
+func isClean(p string) bool {
+	if p == "" || p[0] != '/' {
+		return false
+	}
+	s := segments(strings.TrimSuffix(p[1:], "/"))
+	if s == "" {
+		return p == "/"
+	}
+	if strings.HasSuffix(string(s), "/") {
+		return false // the iterator would not yield the empty last segment
+	}
+	for s != "" {
+		var seg string
+		seg, s = s.next()
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (router *Router) handler(node *node, method string) http.Handler {
+	if h, ok := node.handlers[method]; ok {
+		return h
+	}
+	if h, ok := node.handlers[http.MethodGet]; ok && method == http.MethodHead && router.autoHead {
+		return headHandler(h)
+	}
+	if method == http.MethodOptions && router.autoOptions {
+		return optionsHandler(router.allowed(node))
 	}
 	return nil
 }
+
+// allowed lists the methods served by node, including the implicit ones.
+func (router *Router) allowed(node *node) []string {
+	methods := node.methods()
+	if _, ok := node.handlers[http.MethodHead]; !ok && router.autoHead {
+		if _, ok := node.handlers[http.MethodGet]; ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	if _, ok := node.handlers[http.MethodOptions]; !ok && router.autoOptions {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func (router *Router) methodNotAllowed(methods []string) http.Handler {
+	problem := router.problemDetails
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		if problem {
+			WriteError(w, r, &HTTPError{Code: http.StatusMethodNotAllowed})
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
+}
+
+func redirectHandler(path string, r *http.Request) http.Handler {
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	code := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		code = http.StatusPermanentRedirect
+	}
+	return http.RedirectHandler(path, code)
+}
+
+func optionsHandler(methods []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// headWriter forwards headers and status code but drops the body, so a GET
+// handler can answer a HEAD request.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func headHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(headWriter{w}, r)
+	})
+}
+
+// mountHandler strips the prefix of the mount node from the request path
+// before handing the request to the mounted router.
+func (router *Router) mountHandler(node *node) http.Handler {
+	encoded := router.encodedPath
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		full := r.URL.Path
+		if encoded {
+			full = r.URL.EscapedPath()
+		}
+		full = cleanPath(full)
+		path := "/" + string(pathSegments(full).skip(node.depth))
+		if path != "/" && strings.HasSuffix(full, "/") {
+			path += "/"
+		}
+		prefix := node.pattern
+		if mount, ok := r.Context().Value(routeKey).(*routeContext); ok && mount.node == nil {
+			prefix = joinPattern(mount.pattern, prefix)
+		}
+		r2 := r.Clone(&routeContext{Context: r.Context(), pattern: prefix})
+		r2.URL.Path, r2.URL.RawPath = path, ""
+		if encoded {
+			r2.URL.Path, r2.URL.RawPath = unescape(path), path
+		}
+		node.mount.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,1435 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func serve(router http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func reply(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}
+}
+
+// replyID is reply followed by the id param, for the routes of a path told
+// apart by something else.
+func replyID(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body, " ", Param(r, "id"))
+	}
+}
+
+func TestMethodHelpers(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book", reply("get"))
+	router.Post("/book", reply("post"))
+	router.Delete("/book/:id:[0-9]+", reply("delete"))
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{http.MethodGet, "/book", http.StatusOK, "get"},
+		{http.MethodPost, "/book", http.StatusOK, "post"},
+		{http.MethodDelete, "/book/42", http.StatusOK, "delete"},
+		{http.MethodGet, "/nope", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		w := serve(router, tt.method, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	router := NewRouter()
+	router.Put("/book/:id:[0-9]+", reply("put"))
+	router.Get("/book/:id:[0-9]+", reply("get"))
+	router.Delete("/book/:id:[0-9]+", reply("delete"))
+	router.Get("/about", reply("about"))
+
+	tests := []struct {
+		method, path string
+		allow        string
+	}{
+		{http.MethodPost, "/book/42", "DELETE, GET, HEAD, OPTIONS, PUT"},
+		{http.MethodPost, "/about", "GET, HEAD, OPTIONS"},
+	}
+	for _, tt := range tests {
+		w := serve(router, tt.method, tt.path)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, http.StatusMethodNotAllowed)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s Allow = %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+	}
+
+	// an intermediate node without handlers is not a route
+	if w := serve(router, http.MethodGet, "/book"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /book = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAutoHead(t *testing.T) {
+	book := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "8")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "book: 42")
+	}
+
+	router := NewRouter()
+	router.Get("/book/:id:.*", book)
+
+	get := serve(router, http.MethodGet, "/book/42")
+	head := serve(router, http.MethodHead, "/book/42")
+	if head.Code != get.Code {
+		t.Errorf("HEAD code = %d, want %d", head.Code, get.Code)
+	}
+	for _, key := range []string{"Content-Type", "Content-Length"} {
+		if head.Header().Get(key) != get.Header().Get(key) {
+			t.Errorf("HEAD %s = %q, want %q", key, head.Header().Get(key), get.Header().Get(key))
+		}
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("HEAD body = %q, want empty", head.Body.String())
+	}
+
+	if allow := serve(router, http.MethodPost, "/book/42").Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("Allow = %q, want %q", allow, "GET, HEAD, OPTIONS")
+	}
+
+	strict := NewRouter(WithoutAutoHead())
+	strict.Get("/book/:id:.*", book)
+	if w := serve(strict, http.MethodHead, "/book/42"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("strict HEAD = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAutoOptions(t *testing.T) {
+	calls := 0
+	counter := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			h.ServeHTTP(w, r)
+		})
+	}
+
+	router := NewRouter()
+	router.Get("/book", reply("get"))
+	router.Post("/book", reply("post"))
+	router.Get("/about", reply("about"))
+	router.Handle("/about", http.MethodOptions, reply("options"))
+	router.Use(counter)
+
+	w := serve(router, http.MethodOptions, "/book")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS /book = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("OPTIONS /book Allow = %q, want %q", allow, "GET, HEAD, OPTIONS, POST")
+	}
+	if calls != 1 {
+		t.Errorf("middleware calls = %d, want 1", calls)
+	}
+
+	if w := serve(router, http.MethodOptions, "/about"); w.Body.String() != "options" {
+		t.Errorf("OPTIONS /about = %q, want explicit handler", w.Body.String())
+	}
+
+	strict := NewRouter(WithoutAutoOptions())
+	strict.Get("/book", reply("get"))
+	w = serve(strict, http.MethodOptions, "/book")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("strict OPTIONS /book = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("strict Allow = %q, want %q", allow, "GET, HEAD")
+	}
+}
+
+func TestRedirectTrailingSlash(t *testing.T) {
+	router := NewRouter(WithRedirectTrailingSlash())
+	router.Get("/", reply("root"))
+	router.Get("/about", reply("about"))
+	router.Post("/about", reply("about"))
+
+	tests := []struct {
+		method, path string
+		code         int
+		location     string
+	}{
+		{http.MethodGet, "/about/", http.StatusMovedPermanently, "/about"},
+		{http.MethodPost, "/about/", http.StatusPermanentRedirect, "/about"},
+		{http.MethodGet, "/about/?page=2&sort=asc", http.StatusMovedPermanently, "/about?page=2&sort=asc"},
+		{http.MethodGet, "/about", http.StatusOK, ""},
+		{http.MethodGet, "/", http.StatusOK, ""},
+		{http.MethodGet, "/missing/", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serve(router, tt.method, tt.path)
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+		}
+	}
+
+	// without the option both forms are served
+	router = NewRouter()
+	router.Get("/about", reply("about"))
+	if w := serve(router, http.MethodGet, "/about/"); w.Code != http.StatusOK {
+		t.Errorf("GET /about/ = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	router := NewRouter(WithCaseInsensitive())
+	router.Get("/about", reply("about"))
+	router.Get("/Book/:id:[a-zA-Z]+", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.URL.Path, Vars(r)["id"])
+	})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/ABOUT", http.StatusOK, "about"},
+		{"/About", http.StatusOK, "about"},
+		{"/BOOK/AbC", http.StatusOK, "/BOOK/AbC AbC"},
+		{"/book/xyz", http.StatusOK, "/book/xyz xyz"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	// "/ABOUT" and "/about" are the same route
+	if err := router.Get("/ABOUT", reply("ABOUT")); err == nil {
+		t.Error("GET /ABOUT registered, want a conflict with /about")
+	}
+
+	router = NewRouter()
+	router.Get("/about", reply("about"))
+	if w := serve(router, http.MethodGet, "/ABOUT"); w.Code != http.StatusNotFound {
+		t.Errorf("case sensitive GET /ABOUT = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRoot(t *testing.T) {
+	router := NewRouter()
+	if err := router.Get("/", reply("root")); err != nil {
+		t.Fatalf("Get(/) = %v", err)
+	}
+	router.Get("/home", reply("home"))
+
+	for _, path := range []string{"/", "//"} {
+		if w := serve(router, http.MethodGet, path); w.Body.String() != "root" {
+			t.Errorf("GET %s = %d %q, want %q", path, w.Code, w.Body.String(), "root")
+		}
+	}
+	if len(router.routes.Load().trie.handlers) == 0 {
+		t.Error("root route is not served by the root node")
+	}
+
+	for _, path := range []string{"", " ", "/a//b"} {
+		if err := router.Get(path, reply("empty")); err == nil {
+			t.Errorf("Get(%q) = nil, want an error", path)
+		}
+	}
+
+	router = NewRouter(WithRedirectTrailingSlash())
+	router.Get("/", reply("root"))
+	if w := serve(router, http.MethodGet, "//"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/" {
+		t.Errorf("GET // = %d %q, want a redirect to /", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestUnhandle(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book", reply("books"))
+	before := router.routes.Load().trie.count()
+
+	router.Get("/book/:id:[0-9]+/reviews", reply("reviews"))
+	router.HandleNamed("review", "/book/:id:[0-9]+/reviews/:review", http.MethodGet, reply("review"))
+	router.Get("/book/:slug:[a-z]+", reply("slug"))
+	router.Get("/book/files/*path", reply("file"))
+
+	for _, path := range []string{"/book/:id:[0-9]+/reviews", "/book/:id:[0-9]+/reviews/:review", "/book/:slug:[a-z]+", "/book/files/*path"} {
+		if err := router.Unhandle(path, http.MethodGet); err != nil {
+			t.Fatalf("Unhandle(%s) = %v", path, err)
+		}
+	}
+	for _, path := range []string{"/book/42/reviews", "/book/42/reviews/1", "/book/abc", "/book/files/a.txt"} {
+		if w := serve(router, http.MethodGet, path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/book"); w.Body.String() != "books" {
+		t.Errorf("GET /book = %q, want %q", w.Body.String(), "books")
+	}
+	if after := router.routes.Load().trie.count(); after != before {
+		t.Errorf("trie has %d nodes, want %d", after, before)
+	}
+	if book, _ := router.routes.Load().trie.leaves.get("book"); len(book.params) != 0 {
+		t.Error("param leaves were not pruned")
+	}
+	if _, err := router.URL("review", "id", "1", "review", "2"); err == nil {
+		t.Error("URL of a removed route succeeded")
+	}
+
+	if err := router.Unhandle("/book", http.MethodPost); err == nil {
+		t.Error("Unhandle of a missing method succeeded")
+	}
+	if err := router.Unhandle("/nope", http.MethodGet); err == nil {
+		t.Error("Unhandle of a missing route succeeded")
+	}
+
+	router.HandleMethods("/shelf", []string{"post"}, reply("shelf"))
+	if err := router.Unhandle("/shelf", " post "); err != nil {
+		t.Errorf("Unhandle(/shelf, \" post \") = %v", err)
+	}
+	if w := serve(router, http.MethodPost, "/shelf"); w.Code != http.StatusNotFound {
+		t.Errorf("POST /shelf after Unhandle = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUnhandleWhileServing(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book/:id", reply("book"))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					serve(router, http.MethodGet, "/plugin/42")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		router.Get("/plugin/:id", reply("plugin"))
+		router.Unhandle("/plugin/:id", http.MethodGet)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestNotFound(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book/:id", reply("book"))
+	router.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if vars := Vars(r); vars == nil || len(vars) != 0 {
+			t.Errorf("NotFound Vars = %v, want an empty map", vars)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"not found"}`)
+	}))
+
+	w := serve(router, http.MethodGet, "/author/42")
+	if w.Code != http.StatusNotFound || w.Body.String() != `{"error":"not found"}` {
+		t.Errorf("GET /author/42 = %d %q, want the JSON 404", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", ct, "application/json")
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	router := NewRouter()
+	router.Get("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	router.Get("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	if w := serve(router, http.MethodGet, "/boom"); w.Code != http.StatusInternalServerError || w.Body.String() != "server error\n" {
+		t.Errorf("GET /boom = %d %q, want the default 500", w.Code, w.Body.String())
+	}
+
+	var recovered any
+	router.PanicHandler(func(w http.ResponseWriter, r *http.Request, err any) {
+		recovered = err
+		http.Error(w, "custom", http.StatusServiceUnavailable)
+	})
+	if w := serve(router, http.MethodGet, "/boom"); w.Code != http.StatusServiceUnavailable || recovered != "boom" {
+		t.Errorf("GET /boom = %d with %v recovered, want 503 with %q", w.Code, recovered, "boom")
+	}
+
+	recovered = nil
+	w := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler re-panicked", err)
+			}
+		}()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abort", nil))
+	}()
+	if recovered != nil || w.Body.Len() != 0 || w.Code != http.StatusOK {
+		t.Errorf("abort wrote %d %q and called the panic handler with %v", w.Code, w.Body.String(), recovered)
+	}
+}
+
+func TestPanicLog(t *testing.T) {
+	type logged struct {
+		err   any
+		stack string
+	}
+	var logs []logged
+	router := NewRouter(WithPanicLog(func(r *http.Request, err any, stack []byte) {
+		logs = append(logs, logged{err, string(stack)})
+	}))
+	errBoom := errors.New("boom")
+	router.Get("/string", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	router.Get("/error", func(w http.ResponseWriter, r *http.Request) {
+		panic(errBoom)
+	})
+	router.Get("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("late")
+	})
+	router.Get("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	for _, tt := range []struct {
+		path string
+		err  any
+	}{
+		{"/string", "boom"},
+		{"/error", errBoom},
+	} {
+		logs = nil
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != http.StatusInternalServerError || w.Body.String() != "server error\n" {
+			t.Errorf("GET %s = %d %q, want the default 500", tt.path, w.Code, w.Body.String())
+		}
+		if len(logs) != 1 || logs[0].err != tt.err || !strings.Contains(logs[0].stack, "TestPanicLog") {
+			t.Errorf("GET %s logged %v, want %v with the stack of the handler", tt.path, logs, tt.err)
+		}
+	}
+
+	// the 200 was sent, the 500 cannot replace it so the connection is aborted
+	for _, path := range []string{"/partial", "/abort"} {
+		logs = nil
+		w := httptest.NewRecorder()
+		func() {
+			defer func() {
+				if err := recover(); err != http.ErrAbortHandler {
+					t.Errorf("GET %s recovered %v, want http.ErrAbortHandler", path, err)
+				}
+			}()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		}()
+		if strings.Contains(w.Body.String(), "server error") {
+			t.Errorf("GET %s = %d %q, want no 500 written", path, w.Code, w.Body.String())
+		}
+		if wantLogs := map[string]int{"/partial": 1, "/abort": 0}[path]; len(logs) != wantLogs {
+			t.Errorf("GET %s logged %d panics, want %d", path, len(logs), wantLogs)
+		}
+	}
+}
+
+func TestMiddlewareOnUnmatched(t *testing.T) {
+	calls := 0
+	count := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			h.ServeHTTP(w, r)
+		})
+	}
+	tests := []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/home", http.StatusOK},
+		{http.MethodGet, "/nope", http.StatusNotFound},
+		{http.MethodPost, "/home", http.StatusMethodNotAllowed},
+		{http.MethodOptions, "/nope", http.StatusNotFound},
+		{http.MethodGet, "/home/", http.StatusMovedPermanently},
+	}
+
+	router := NewRouter(WithRedirectTrailingSlash())
+	router.Get("/home", reply("home"))
+	router.Use(count)
+	for _, tt := range tests {
+		calls = 0
+		if w := serve(router, tt.method, tt.path); w.Code != tt.code || calls != 1 {
+			t.Errorf("%s %s = %d with %d middleware calls, want %d with 1", tt.method, tt.path, w.Code, calls, tt.code)
+		}
+	}
+
+	router = NewRouter(WithRedirectTrailingSlash(), WithoutUnmatchedMiddleware())
+	router.Get("/home", reply("home"))
+	router.Use(count)
+	for _, tt := range tests {
+		calls = 0
+		want := 0
+		if tt.code == http.StatusOK {
+			want = 1
+		}
+		if w := serve(router, tt.method, tt.path); w.Code != tt.code || calls != want {
+			t.Errorf("WithoutUnmatchedMiddleware: %s %s = %d with %d middleware calls, want %d with %d", tt.method, tt.path, w.Code, calls, tt.code, want)
+		}
+	}
+}
+
+func TestEncodedPath(t *testing.T) {
+	id := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Param(r, "id")))
+	}
+	router := NewRouter(WithEncodedPath())
+	router.Get("/book/:id", id)
+	router.Get("/100%/off", reply("sale"))
+	router.Get("/files/*id", id)
+	api := NewRouter(WithEncodedPath())
+	api.Get("/book/:id", id)
+	router.Mount("/api", api)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/book/a%2Fb", http.StatusOK, "a/b"},
+		{"/book/a%20b", http.StatusOK, "a b"},
+		{"/book/a/b", http.StatusNotFound, "404 page not found\n"},
+		{"/100%25/off", http.StatusOK, "sale"},
+		{"/files/a%2Fb/c", http.StatusOK, "a/b/c"},
+		{"/api/book/a%2Fb", http.StatusOK, "a/b"},
+	}
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	router = NewRouter()
+	router.Get("/book/:id", id)
+	router.Get("/100%/off", reply("sale"))
+	if w := serve(router, http.MethodGet, "/book/a%2Fb"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /book/a%%2Fb without WithEncodedPath = %d, want 404", w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/100%25/off"); w.Body.String() != "sale" {
+		t.Errorf("GET /100%%25/off without WithEncodedPath = %q, want %q", w.Body.String(), "sale")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/a//b", http.StatusOK, ""},
+		{"/a/./b", http.StatusOK, ""},
+		{"/./a/b", http.StatusOK, ""},
+		{"/a/../home", http.StatusOK, ""},
+		{"/../../home", http.StatusOK, ""},
+		{"/home//", http.StatusOK, ""},
+		{"/../../etc/passwd", http.StatusNotFound, ""},
+		{"/a/b/../../..", http.StatusNotFound, ""},
+	}
+	router := NewRouter()
+	router.Get("/a/b", reply("ab"))
+	router.Get("/home", reply("home"))
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+
+	router = NewRouter(WithRedirectFixedPath())
+	router.Get("/a/b", reply("ab"))
+	router.Get("/home", reply("home"))
+	redirects := []struct {
+		path, location string
+	}{
+		{"/a//b?x=1", "/a/b?x=1"},
+		{"/./a/b", "/a/b"},
+		{"/../../home", "/home"},
+		{"/a/../home/", "/home/"},
+		{"/a/b//", "/a/b/"},
+	}
+	for _, tt := range redirects {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s = %d to %q, want 301 to %q", tt.path, w.Code, w.Header().Get("Location"), tt.location)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/a/b"); w.Code != http.StatusOK {
+		t.Errorf("GET /a/b = %d, want 200", w.Code)
+	}
+}
+
+func TestPathSegments(t *testing.T) {
+	paths := []string{"", "/", "/a", "/a/", "/a/b/c", "/a/b/c/", "a/b", "/a//b", "//", "/./a/../b/"}
+	for _, p := range paths {
+		var got []string
+		for s := pathSegments(p); s != ""; {
+			var seg string
+			seg, s = s.next()
+			got = append(got, seg)
+		}
+		if want := split(p); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("segments of %q = %q, want %q", p, got, want)
+		}
+		if want := path.Clean("/" + p); cleanPath(p) != want && cleanPath(p) != want+"/" {
+			t.Errorf("cleanPath(%q) = %q, want %q", p, cleanPath(p), want)
+		}
+	}
+
+	router := NewRouter()
+	router.Get("/api/v1/users/settings/profile", reply("profile"))
+	routes := router.routes.Load()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/users/settings/profile/", nil)
+	allocs := testing.AllocsPerRun(100, func() {
+		if node, _ := router.match(routes, r, nil); node == nil {
+			t.Fatal("route not matched")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("matching a static route allocates %v times, want 0", allocs)
+	}
+}
+
+func TestStaticRoutes(t *testing.T) {
+	router := NewRouter(WithCaseInsensitive(), WithRedirectTrailingSlash())
+	router.Get("/", reply("root"))
+	router.Get("/users/new", reply("new"))
+	router.Get("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "user ", Param(r, "id"))
+	})
+	router.Get("/users/new/:step", reply("step"))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/", http.StatusOK, "root"},
+		{"/users/new", http.StatusOK, "new"},
+		{"/Users/NEW", http.StatusOK, "new"},
+		{"/users/new/", http.StatusMovedPermanently, ""},
+		{"/users/42", http.StatusOK, "user 42"},
+		{"/users/new/1", http.StatusOK, "step"},
+		{"/users", http.StatusNotFound, "404 page not found\n"},
+	}
+	check := func() {
+		t.Helper()
+		for _, tt := range tests {
+			w := serve(router, http.MethodGet, tt.path)
+			if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+				t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+			}
+		}
+	}
+	check()
+	if n := len(router.routes.Load().static); n != 2 {
+		t.Errorf("%d static routes, want 2", n)
+	}
+
+	// the middlewares rebuild the nodes, the static routes follow
+	router.Use(func(h http.Handler) http.Handler { return h })
+	check()
+
+	router.Unhandle("/users/new", http.MethodGet)
+	if w := serve(router, http.MethodGet, "/users/new"); w.Body.String() != "user new" {
+		t.Errorf("GET /users/new after Unhandle = %q, want the param route", w.Body.String())
+	}
+	if w := serve(router, http.MethodGet, "/users/new/1"); w.Body.String() != "step" {
+		t.Errorf("GET /users/new/1 after Unhandle = %q", w.Body.String())
+	}
+}
+
+// TestRegisterWhileServing is meant for go test -race.
+func TestRegisterWhileServing(t *testing.T) {
+	router := NewRouter()
+	router.Get("/home", reply("home"))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					if w := serve(router, http.MethodGet, "/home"); w.Body.String() != "home" {
+						t.Errorf("GET /home = %q while registering", w.Body.String())
+						return
+					}
+					serve(router, http.MethodGet, fmt.Sprintf("/r/%d/x", i))
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := router.Get(fmt.Sprintf("/r/%d/:id", i), reply("r")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if w := serve(router, http.MethodGet, "/r/999/x"); w.Body.String() != "r" {
+		t.Errorf("GET /r/999/x = %q, want %q", w.Body.String(), "r")
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var trace []string
+	tag := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace = append(trace, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	router := NewRouter()
+	router.Get("/before", reply("before"))
+	router.Use(tag("first"))
+	router.Get("/between", reply("between"))
+	router.Use(tag("second"))
+	router.Get("/after", reply("after"))
+
+	for _, path := range []string{"/before", "/between", "/after", "/nope"} {
+		trace = nil
+		serve(router, http.MethodGet, path)
+		if got := fmt.Sprint(trace); got != "[first second]" {
+			t.Errorf("GET %s middlewares = %s, want [first second]", path, got)
+		}
+	}
+}
+
+func BenchmarkMiddleware(b *testing.B) {
+	router := NewRouter()
+	router.Get("/home", reply("home"))
+	for i := 0; i < 3; i++ {
+		router.Use(func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h.ServeHTTP(w, r)
+			})
+		})
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/home", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	router := NewRouter()
+	router.Get("/api/v1/users/settings/profile", reply("profile"))
+	routes := router.routes.Load()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/users/settings/profile", nil)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		router.match(routes, r, nil)
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	router := NewRouter()
+	router.Get("/about", reply("about"))
+	router.Get("/book/:id/reviews/:review", reply("review"))
+
+	for _, path := range []string{"/about", "/book/42/reviews/7"} {
+		b.Run(path, func(b *testing.B) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				router.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func TestUseFirst(t *testing.T) {
+	wrapper := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, name+">")
+				h.ServeHTTP(w, r)
+				fmt.Fprint(w, "<"+name)
+			})
+		}
+	}
+	tests := []struct {
+		name  string
+		setup func(router *Router)
+		body  string
+	}{
+		{"Use", func(router *Router) {
+			router.Use(wrapper("a"))
+			router.Use(wrapper("b"))
+			router.Use(wrapper("c"))
+		}, "a>b>c>home<c<b<a"},
+		{"UseFirst", func(router *Router) {
+			router.Use(wrapper("a"))
+			router.Use(wrapper("b"))
+			router.UseFirst(wrapper("c"))
+		}, "c>a>b>home<b<a<c"},
+		{"UseFirst twice", func(router *Router) {
+			router.UseFirst(wrapper("a"))
+			router.Use(wrapper("b"))
+			router.UseFirst(wrapper("c"))
+		}, "c>a>b>home<b<a<c"},
+	}
+	for _, tt := range tests {
+		router := NewRouter()
+		router.Get("/home", reply("home"))
+		tt.setup(router)
+		if w := serve(router, http.MethodGet, "/home"); w.Body.String() != tt.body {
+			t.Errorf("%s: GET /home = %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestUseExcept(t *testing.T) {
+	auth := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}
+	router := NewRouter()
+	router.Get("/health", reply("ok"))
+	router.Post("/health", reply("ok"))
+	router.Get("/metrics", reply("metrics"))
+	router.Get("/public/:file", reply("file"))
+	router.Get("/private/:file", reply("file"))
+	router.UseExcept(auth, "GET /health", "/metrics", "get /public/:file")
+
+	tests := []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/health", http.StatusOK},
+		{http.MethodHead, "/health", http.StatusOK},
+		{http.MethodPost, "/health", http.StatusUnauthorized},
+		{http.MethodGet, "/metrics", http.StatusOK},
+		{http.MethodGet, "/public/logo.svg", http.StatusOK},
+		{http.MethodGet, "/private/cv.pdf", http.StatusUnauthorized},
+		{http.MethodGet, "/nope", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := serve(router, tt.method, tt.path); w.Code != tt.code {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.code)
+		}
+	}
+
+	// A route registered after UseExcept is skipped too.
+	router.Get("/version", reply("v1"))
+	router.UseExcept(auth, "/version")
+	router.Get("/ready", reply("ok"))
+	if w := serve(router, http.MethodGet, "/ready"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /ready = %d, want 401", w.Code)
+	}
+	router = NewRouter()
+	router.UseExcept(auth, "/version")
+	router.Get("/version", reply("v1"))
+	if w := serve(router, http.MethodGet, "/version"); w.Code != http.StatusOK {
+		t.Errorf("GET /version registered after UseExcept = %d, want 200", w.Code)
+	}
+}
+
+func TestNamedMiddleware(t *testing.T) {
+	var trace []string
+	tag := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace = append(trace, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	deny := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "denied", http.StatusUnauthorized)
+		})
+	}
+
+	router := NewRouter()
+	router.Use(tag("log"))
+	if err := router.UseNamed("auth", deny); err != nil {
+		t.Fatal(err)
+	}
+	router.UseNamed("audit", tag("audit"))
+	router.Get("/admin", reply("admin"))
+
+	if w := serve(router, http.MethodGet, "/admin"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin = %d, want the auth 401", w.Code)
+	}
+	if err := router.ReplaceMiddleware("auth", tag("fake auth")); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.UseBefore("audit", tag("before audit")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		edit  func() error
+		trace string
+	}{
+		{func() error { return nil }, "[log fake auth before audit audit]"},
+		{func() error { return router.RemoveMiddleware("audit") }, "[log fake auth before audit]"},
+		{func() error { return router.RemoveMiddleware("auth") }, "[log before audit]"},
+	}
+	for _, tt := range tests {
+		if err := tt.edit(); err != nil {
+			t.Fatal(err)
+		}
+		trace = nil
+		if w := serve(router, http.MethodGet, "/admin"); w.Code != http.StatusOK {
+			t.Errorf("GET /admin = %d, want 200", w.Code)
+		}
+		if got := fmt.Sprint(trace); got != tt.trace {
+			t.Errorf("trace = %s, want %s", got, tt.trace)
+		}
+	}
+
+	router.UseNamed("auth", deny)
+	if err := router.UseNamed("auth", deny); err == nil {
+		t.Error("UseNamed accepted a name already used")
+	}
+	for name, err := range map[string]error{
+		"remove":  router.RemoveMiddleware("nope"),
+		"replace": router.ReplaceMiddleware("nope", deny),
+		"before":  router.UseBefore("nope", deny),
+		"empty":   router.UseNamed("", deny),
+	} {
+		if err == nil {
+			t.Errorf("%s with a missing name succeeded", name)
+		}
+	}
+}
+
+func TestPre(t *testing.T) {
+	var trace []string
+	tag := func(name string) middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace = append(trace, name+" "+r.URL.Path)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	stripV1 := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if path, ok := strings.CutPrefix(r.URL.Path, "/v1/"); ok {
+				r = r.Clone(r.Context())
+				r.URL.Path = "/" + path
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+
+	var recovered any
+	router := NewRouter(WithoutUnmatchedMiddleware())
+	router.PanicHandler(func(w http.ResponseWriter, r *http.Request, err any) {
+		recovered = err
+		http.Error(w, "recovered", http.StatusInternalServerError)
+	})
+	router.Pre(tag("pre"))
+	router.Pre(stripV1)
+	router.Use(tag("use"))
+	router.Get("/home", reply("home"))
+
+	tests := []struct {
+		path  string
+		code  int
+		trace string
+	}{
+		{"/v1/home", http.StatusOK, "[pre /v1/home use /home]"},
+		{"/home", http.StatusOK, "[pre /home use /home]"},
+		{"/v1/nope", http.StatusNotFound, "[pre /v1/nope]"},
+	}
+	for _, tt := range tests {
+		trace = nil
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+		if got := fmt.Sprint(trace); got != tt.trace {
+			t.Errorf("GET %s trace = %s, want %s", tt.path, got, tt.trace)
+		}
+	}
+
+	// Pre runs before matching, so MethodOverride can pick the route
+	router.Delete("/home", reply("deleted"))
+	router.Pre(MethodOverride())
+	r := httptest.NewRequest(http.MethodPost, "/home", nil)
+	r.Header.Set("X-HTTP-Method-Override", http.MethodDelete)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Body.String() != "deleted" {
+		t.Errorf("POST /home overridden to DELETE = %d %q, want deleted", w.Code, w.Body.String())
+	}
+
+	// the panics of the Pre middlewares are recovered
+	router.Pre(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("pre")
+		})
+	})
+	if w := serve(router, http.MethodGet, "/home"); w.Code != http.StatusInternalServerError || recovered != "pre" {
+		t.Errorf("GET /home with a panicking Pre = %d, recovered %v", w.Code, recovered)
+	}
+}
+
+// githubAPI is the route table of the GitHub API v3 the router benchmarks
+// usually compare routers with.
+var githubAPI = []struct {
+	method, path string
+}{
+	{"GET", "/authorizations"},
+	{"GET", "/authorizations/:id"},
+	{"POST", "/authorizations"},
+	{"DELETE", "/authorizations/:id"},
+	{"GET", "/applications/:client_id/tokens/:access_token"},
+	{"DELETE", "/applications/:client_id/tokens"},
+	{"DELETE", "/applications/:client_id/tokens/:access_token"},
+	{"GET", "/events"},
+	{"GET", "/repos/:owner/:repo/events"},
+	{"GET", "/networks/:owner/:repo/events"},
+	{"GET", "/orgs/:org/events"},
+	{"GET", "/users/:user/received_events"},
+	{"GET", "/users/:user/received_events/public"},
+	{"GET", "/users/:user/events"},
+	{"GET", "/users/:user/events/public"},
+	{"GET", "/users/:user/events/orgs/:org"},
+	{"GET", "/feeds"},
+	{"GET", "/notifications"},
+	{"GET", "/repos/:owner/:repo/notifications"},
+	{"PUT", "/notifications"},
+	{"PUT", "/repos/:owner/:repo/notifications"},
+	{"GET", "/notifications/threads/:id"},
+	{"GET", "/notifications/threads/:id/subscription"},
+	{"PUT", "/notifications/threads/:id/subscription"},
+	{"DELETE", "/notifications/threads/:id/subscription"},
+	{"GET", "/repos/:owner/:repo/stargazers"},
+	{"GET", "/users/:user/starred"},
+	{"GET", "/user/starred"},
+	{"GET", "/user/starred/:owner/:repo"},
+	{"PUT", "/user/starred/:owner/:repo"},
+	{"DELETE", "/user/starred/:owner/:repo"},
+	{"GET", "/repos/:owner/:repo/subscribers"},
+	{"GET", "/users/:user/subscriptions"},
+	{"GET", "/user/subscriptions"},
+	{"GET", "/repos/:owner/:repo/subscription"},
+	{"PUT", "/repos/:owner/:repo/subscription"},
+	{"DELETE", "/repos/:owner/:repo/subscription"},
+	{"GET", "/user/subscriptions/:owner/:repo"},
+	{"PUT", "/user/subscriptions/:owner/:repo"},
+	{"DELETE", "/user/subscriptions/:owner/:repo"},
+	{"GET", "/users/:user/gists"},
+	{"GET", "/gists"},
+	{"GET", "/gists/:id"},
+	{"POST", "/gists"},
+	{"PUT", "/gists/:id/star"},
+	{"DELETE", "/gists/:id/star"},
+	{"GET", "/gists/:id/star"},
+	{"POST", "/gists/:id/forks"},
+	{"DELETE", "/gists/:id"},
+	{"GET", "/repos/:owner/:repo/git/blobs/:sha"},
+	{"POST", "/repos/:owner/:repo/git/blobs"},
+	{"GET", "/repos/:owner/:repo/git/commits/:sha"},
+	{"POST", "/repos/:owner/:repo/git/commits"},
+	{"GET", "/repos/:owner/:repo/git/refs"},
+	{"POST", "/repos/:owner/:repo/git/refs"},
+	{"GET", "/repos/:owner/:repo/git/tags/:sha"},
+	{"POST", "/repos/:owner/:repo/git/tags"},
+	{"GET", "/repos/:owner/:repo/git/trees/:sha"},
+	{"POST", "/repos/:owner/:repo/git/trees"},
+	{"GET", "/issues"},
+	{"GET", "/user/issues"},
+	{"GET", "/orgs/:org/issues"},
+	{"GET", "/repos/:owner/:repo/issues"},
+	{"GET", "/repos/:owner/:repo/issues/:number"},
+	{"POST", "/repos/:owner/:repo/issues"},
+	{"GET", "/repos/:owner/:repo/assignees"},
+	{"GET", "/repos/:owner/:repo/assignees/:assignee"},
+	{"GET", "/repos/:owner/:repo/issues/:number/comments"},
+	{"POST", "/repos/:owner/:repo/issues/:number/comments"},
+	{"GET", "/repos/:owner/:repo/issues/:number/events"},
+	{"GET", "/repos/:owner/:repo/labels"},
+	{"GET", "/repos/:owner/:repo/labels/:name"},
+	{"POST", "/repos/:owner/:repo/labels"},
+	{"DELETE", "/repos/:owner/:repo/labels/:name"},
+	{"GET", "/repos/:owner/:repo/issues/:number/labels"},
+	{"POST", "/repos/:owner/:repo/issues/:number/labels"},
+	{"DELETE", "/repos/:owner/:repo/issues/:number/labels/:name"},
+	{"PUT", "/repos/:owner/:repo/issues/:number/labels"},
+	{"DELETE", "/repos/:owner/:repo/issues/:number/labels"},
+	{"GET", "/repos/:owner/:repo/milestones/:number/labels"},
+	{"GET", "/repos/:owner/:repo/milestones"},
+	{"GET", "/repos/:owner/:repo/milestones/:number"},
+	{"POST", "/repos/:owner/:repo/milestones"},
+	{"DELETE", "/repos/:owner/:repo/milestones/:number"},
+	{"GET", "/emojis"},
+	{"GET", "/gitignore/templates"},
+	{"GET", "/gitignore/templates/:name"},
+	{"POST", "/markdown"},
+	{"POST", "/markdown/raw"},
+	{"GET", "/meta"},
+	{"GET", "/rate_limit"},
+	{"GET", "/users/:user/orgs"},
+	{"GET", "/user/orgs"},
+	{"GET", "/orgs/:org"},
+	{"GET", "/orgs/:org/members"},
+	{"GET", "/orgs/:org/members/:user"},
+	{"DELETE", "/orgs/:org/members/:user"},
+	{"GET", "/orgs/:org/public_members"},
+	{"GET", "/orgs/:org/public_members/:user"},
+	{"PUT", "/orgs/:org/public_members/:user"},
+	{"DELETE", "/orgs/:org/public_members/:user"},
+	{"GET", "/orgs/:org/teams"},
+	{"GET", "/teams/:id"},
+	{"POST", "/orgs/:org/teams"},
+	{"DELETE", "/teams/:id"},
+	{"GET", "/teams/:id/members"},
+	{"GET", "/teams/:id/members/:user"},
+	{"PUT", "/teams/:id/members/:user"},
+	{"DELETE", "/teams/:id/members/:user"},
+	{"GET", "/teams/:id/repos"},
+	{"GET", "/teams/:id/repos/:owner/:repo"},
+	{"PUT", "/teams/:id/repos/:owner/:repo"},
+	{"DELETE", "/teams/:id/repos/:owner/:repo"},
+	{"GET", "/user/teams"},
+	{"GET", "/repos/:owner/:repo/pulls"},
+	{"GET", "/repos/:owner/:repo/pulls/:number"},
+	{"POST", "/repos/:owner/:repo/pulls"},
+	{"GET", "/repos/:owner/:repo/pulls/:number/commits"},
+	{"GET", "/repos/:owner/:repo/pulls/:number/files"},
+	{"GET", "/repos/:owner/:repo/pulls/:number/merge"},
+	{"PUT", "/repos/:owner/:repo/pulls/:number/merge"},
+	{"GET", "/repos/:owner/:repo/pulls/:number/comments"},
+	{"PUT", "/repos/:owner/:repo/pulls/:number/comments"},
+	{"GET", "/user/repos"},
+	{"GET", "/users/:user/repos"},
+	{"GET", "/orgs/:org/repos"},
+	{"GET", "/repositories"},
+	{"POST", "/user/repos"},
+	{"POST", "/orgs/:org/repos"},
+	{"GET", "/repos/:owner/:repo"},
+	{"GET", "/repos/:owner/:repo/contributors"},
+	{"GET", "/repos/:owner/:repo/languages"},
+	{"GET", "/repos/:owner/:repo/teams"},
+	{"GET", "/repos/:owner/:repo/tags"},
+	{"GET", "/repos/:owner/:repo/branches"},
+	{"GET", "/repos/:owner/:repo/branches/:branch"},
+	{"DELETE", "/repos/:owner/:repo"},
+	{"GET", "/repos/:owner/:repo/collaborators"},
+	{"GET", "/repos/:owner/:repo/collaborators/:user"},
+	{"PUT", "/repos/:owner/:repo/collaborators/:user"},
+	{"DELETE", "/repos/:owner/:repo/collaborators/:user"},
+	{"GET", "/repos/:owner/:repo/comments"},
+	{"GET", "/repos/:owner/:repo/commits/:sha/comments"},
+	{"POST", "/repos/:owner/:repo/commits/:sha/comments"},
+	{"GET", "/repos/:owner/:repo/comments/:id"},
+	{"DELETE", "/repos/:owner/:repo/comments/:id"},
+	{"GET", "/repos/:owner/:repo/commits"},
+	{"GET", "/repos/:owner/:repo/commits/:sha"},
+	{"GET", "/repos/:owner/:repo/readme"},
+	{"GET", "/repos/:owner/:repo/contents/*path"},
+	{"DELETE", "/repos/:owner/:repo/contents/*path"},
+	{"GET", "/repos/:owner/:repo/:archive_format/:ref"},
+	{"GET", "/repos/:owner/:repo/keys"},
+	{"GET", "/repos/:owner/:repo/keys/:id"},
+	{"POST", "/repos/:owner/:repo/keys"},
+	{"DELETE", "/repos/:owner/:repo/keys/:id"},
+	{"GET", "/repos/:owner/:repo/downloads"},
+	{"GET", "/repos/:owner/:repo/downloads/:id"},
+	{"DELETE", "/repos/:owner/:repo/downloads/:id"},
+	{"GET", "/repos/:owner/:repo/forks"},
+	{"POST", "/repos/:owner/:repo/forks"},
+	{"GET", "/repos/:owner/:repo/hooks"},
+	{"GET", "/repos/:owner/:repo/hooks/:id"},
+	{"POST", "/repos/:owner/:repo/hooks"},
+	{"POST", "/repos/:owner/:repo/hooks/:id/tests"},
+	{"DELETE", "/repos/:owner/:repo/hooks/:id"},
+	{"POST", "/repos/:owner/:repo/merges"},
+	{"GET", "/repos/:owner/:repo/releases"},
+	{"GET", "/repos/:owner/:repo/releases/:id"},
+	{"POST", "/repos/:owner/:repo/releases"},
+	{"DELETE", "/repos/:owner/:repo/releases/:id"},
+	{"GET", "/repos/:owner/:repo/releases/:id/assets"},
+	{"GET", "/repos/:owner/:repo/stats/contributors"},
+	{"GET", "/repos/:owner/:repo/stats/commit_activity"},
+	{"GET", "/repos/:owner/:repo/stats/code_frequency"},
+	{"GET", "/repos/:owner/:repo/stats/participation"},
+	{"GET", "/repos/:owner/:repo/stats/punch_card"},
+	{"GET", "/repos/:owner/:repo/statuses/:ref"},
+	{"POST", "/repos/:owner/:repo/statuses/:ref"},
+	{"GET", "/search/repositories"},
+	{"GET", "/search/code"},
+	{"GET", "/search/issues"},
+	{"GET", "/search/users"},
+	{"GET", "/legacy/issues/search/:owner/:repository/:state/:keyword"},
+	{"GET", "/legacy/repos/search/:keyword"},
+	{"GET", "/legacy/user/search/:keyword"},
+	{"GET", "/legacy/user/email/:email"},
+	{"GET", "/users/:user"},
+	{"GET", "/user"},
+	{"GET", "/users"},
+	{"GET", "/user/emails"},
+	{"POST", "/user/emails"},
+	{"DELETE", "/user/emails"},
+	{"GET", "/users/:user/followers"},
+	{"GET", "/user/followers"},
+	{"GET", "/users/:user/following"},
+	{"GET", "/user/following"},
+	{"GET", "/user/following/:user"},
+	{"GET", "/users/:user/following/:target_user"},
+	{"PUT", "/user/following/:user"},
+	{"DELETE", "/user/following/:user"},
+	{"GET", "/users/:user/keys"},
+	{"GET", "/user/keys"},
+	{"GET", "/user/keys/:id"},
+	{"POST", "/user/keys"},
+	{"DELETE", "/user/keys/:id"},
+}
+
+// benchmarkServe serves the requests of paths to router in turn.
+func benchmarkServe(b *testing.B, router http.Handler, method string, paths ...string) {
+	w := httptest.NewRecorder()
+	requests := make([]*http.Request, len(paths))
+	for i, path := range paths {
+		requests[i] = httptest.NewRequest(method, path, nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(w, requests[i%len(requests)])
+	}
+}
+
+func BenchmarkStaticRoute(b *testing.B) {
+	router := NewRouter()
+	router.Get("/user/settings/profile", reply("profile"))
+	benchmarkServe(b, router, http.MethodGet, "/user/settings/profile")
+}
+
+func BenchmarkParamRoute(b *testing.B) {
+	router := NewRouter()
+	router.Get("/user/:name", reply("user"))
+	benchmarkServe(b, router, http.MethodGet, "/user/gordon")
+}
+
+func BenchmarkRegexRoute(b *testing.B) {
+	router := NewRouter()
+	router.Get("/book/:id:[0-9]+/reviews/:review:[a-z0-9-]+", reply("review"))
+	benchmarkServe(b, router, http.MethodGet, "/book/42/reviews/great-read")
+}
+
+func BenchmarkNotFound(b *testing.B) {
+	router := NewRouter()
+	router.Get("/user/:name", reply("user"))
+	router.Get("/book/:id:[0-9]+", reply("book"))
+	benchmarkServe(b, router, http.MethodGet, "/book/abc")
+}
+
+// BenchmarkGitHubAPI serves a request for every route of githubAPI, with
+// the params replaced by values.
+func BenchmarkGitHubAPI(b *testing.B) {
+	router := NewRouter()
+	var paths []string
+	for _, route := range githubAPI {
+		if err := router.Handle(route.path, route.method, reply("github")); err != nil {
+			b.Fatal(err)
+		}
+		var parts []string
+		for _, part := range split(route.path) {
+			switch {
+			case strings.HasPrefix(part, ":"):
+				part = part[1:] + "-value"
+			case strings.HasPrefix(part, "*"):
+				part = "a/b"
+			}
+			parts = append(parts, part)
+		}
+		paths = append(paths, "/"+strings.Join(parts, "/"))
+	}
+	w := httptest.NewRecorder()
+	requests := make([]*http.Request, len(paths))
+	for i, path := range paths {
+		requests[i] = httptest.NewRequest(githubAPI[i].method, path, nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(w, requests[i%len(requests)])
+	}
+}
+
+func FuzzSplit(f *testing.F) {
+	for _, seed := range []string{"", "/", "//", "/a/b/", " /a ", "/a//b", "a/b", "/./..", "/time/:ts:[0-9]{2}:[0-9]{2}"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		components := split(p)
+		for _, c := range components {
+			if strings.Contains(c, "/") {
+				t.Fatalf("split(%q) = %q, component with a slash", p, components)
+			}
+		}
+		cleaned := cleanPath(p)
+		if !isClean(cleaned) {
+			t.Fatalf("cleanPath(%q) = %q is not clean", p, cleaned)
+		}
+		if strings.TrimSpace(cleaned) != cleaned {
+			return // split trims the spaces pathSegments keeps
+		}
+		var segs []string
+		for s := pathSegments(cleaned); s != ""; {
+			var seg string
+			seg, s = s.next()
+			segs = append(segs, seg)
+		}
+		if want := split(cleaned); fmt.Sprint(segs) != fmt.Sprint(want) {
+			t.Fatalf("segments of %q = %q, split = %q", cleaned, segs, want)
+		}
+	})
+}
+
+// answerWriter records whether a response was started.
+type answerWriter struct {
+	*httptest.ResponseRecorder
+	answered bool
+}
+
+func (w *answerWriter) WriteHeader(code int) {
+	w.answered = true
+	w.ResponseRecorder.WriteHeader(code)
+}
+
+func (w *answerWriter) Write(b []byte) (int, error) {
+	w.answered = true
+	return w.ResponseRecorder.Write(b)
+}
+
+func FuzzServeHTTP(f *testing.F) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	admin := NewRouter()
+	admin.Get("/stats", ok)
+	router := NewRouter(WithRedirectTrailingSlash(), WithRedirectFixedPath())
+	router.Get("/", ok)
+	router.Get("/home", ok)
+	router.Get("/users/:id:[0-9]+", ok)
+	router.HandleMethods("/users/:id:[0-9]+/posts/{post}", []string{"GET", "PUT"}, http.HandlerFunc(ok))
+	router.Get("/time/:ts:[0-9]{2}:[0-9]{2}", ok)
+	router.Get("/static/*filepath", ok)
+	router.Group("/api/:version").Get("/items", ok)
+	router.Mount("/admin/:tenant", admin)
+
+	for _, seed := range []struct{ method, path string }{
+		{"GET", "/"}, {"GET", "/users/42/posts/7"}, {"HEAD", "/home"}, {"OPTIONS", "/users/1"},
+		{"GET", "/time/12:30"}, {"GET", "/static/"}, {"DELETE", "/admin/t/stats/"},
+		{"GET", "//a/../home/"}, {"", ""}, {"GET", "/:"}, {"X", "/users/:id"},
+	} {
+		f.Add(seed.method, seed.path)
+	}
+	f.Fuzz(func(t *testing.T, method, path string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Method, r.URL.Path = method, path
+		w := &answerWriter{ResponseRecorder: httptest.NewRecorder()}
+		router.ServeHTTP(w, r)
+		if !w.answered || w.Code < 100 || w.Code > 599 {
+			t.Fatalf("%s %q answered %t with %d", method, path, w.answered, w.Code)
+		}
+	})
+}
+
+type poolKey struct{}
+
+type dbPool struct{ name string }
+
+func TestBaseContext(t *testing.T) {
+	type serverKey struct{}
+	base, cancel := context.WithCancelCause(context.WithValue(context.Background(), serverKey{}, "base"))
+	router := NewRouter(WithBaseContext(base))
+	router.SetValue(poolKey{}, &dbPool{"main"})
+	router.Use(RequestIDs(false))
+	router.Get("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		pool := r.Context().Value(poolKey{}).(*dbPool)
+		fmt.Fprint(w, pool.name, " ", r.Context().Value(serverKey{}), " ", Param(r, "id"), " ", RequestID(r) != "")
+	})
+	started := make(chan struct{})
+	router.Get("/long", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		fmt.Fprint(w, context.Cause(r.Context()))
+	})
+
+	if w := serve(router, http.MethodGet, "/users/7"); w.Body.String() != "main base 7 true" {
+		t.Errorf("GET /users/7 = %q, want the values through the middleware", w.Body.String())
+	}
+
+	shutdown := errors.New("shutting down")
+	done := make(chan string)
+	go func() { done <- serve(router, http.MethodGet, "/long").Body.String() }()
+	<-started
+	cancel(shutdown)
+	if got := <-done; got != "shutting down" {
+		t.Errorf("long request ended with %q, want the cause of the base context", got)
+	}
+
+	// the server context keeps its own values and cancellation
+	router = NewRouter()
+	router.SetValue(poolKey{}, &dbPool{"replica"})
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value(poolKey{}).(*dbPool).name, " ", r.Context().Value(http.ServerContextKey) != nil)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "replica true" {
+		t.Errorf("GET / = %q, want the value and the server context", body)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type RouteInfo struct {
+	Pattern string
+	Method  string
+	Handler http.Handler
+}
+
+// Walk calls fn for every registered route, stopping at the first error.
+// Static children are visited in lexical order, then params ordered by their
+// text, then the wildcard, so the order does not depend on how the routes were
+// registered. Routes of mounted routers are visited with the mount prefix.
+// The routes registered or removed by fn are not seen by the walk.
+func (router *Router) Walk(fn func(RouteInfo) error) error {
+	return router.routes.Load().trie.walk(nil, fn)
+}
+
+// Routes lists every registered route sorted by pattern then method.
+func (router *Router) Routes() []RouteInfo {
+	routes := []RouteInfo{}
+	router.Walk(func(route RouteInfo) error {
+		routes = append(routes, route)
+		return nil
+	})
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// Stats describes the trie holding the routes of a router, the routers it
+// mounts excluded, and the requests it is serving.
+type Stats struct {
+	Nodes    int
+	Bytes    int   // approximate, the strings shared with the patterns excluded
+	InFlight int64 // requests whose handler is running
+}
+
+// Stats returns the size of the trie currently served and the number of
+// requests in flight.
+func (router *Router) Stats() Stats {
+	trie := router.routes.Load().trie
+	return Stats{Nodes: trie.count(), Bytes: trie.size(), InFlight: router.inFlight.Load()}
+}
+
+func pattern(path []string) string {
+	return "/" + strings.Join(path, "/")
+}
+
+func (node *node) walk(path []string, fn func(RouteInfo) error) error {
+	if sub, ok := node.mount.(*Router); ok {
+		return sub.Walk(func(route RouteInfo) error {
+			route.Pattern = strings.TrimSuffix(pattern(path), "/") + route.Pattern
+			if route.Pattern != "/" {
+				route.Pattern = strings.TrimSuffix(route.Pattern, "/")
+			}
+			return fn(route)
+		})
+	}
+	for _, method := range node.methods() {
+		if err := fn(RouteInfo{Pattern: pattern(path), Method: method, Handler: node.handlers[method]}); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range children(node) {
+		if err := child.walk(append(path[:len(path):len(path)], child.segment), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// children lists the children of n in walking order.
+func children(n *node) []*node {
+	leaves := make([]*node, 0, n.leaves.len()+len(n.params)+1)
+	for _, leaf := range n.leaves.all() {
+		leaves = append(leaves, leaf)
+	}
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i].label[0] < leaves[j].label[0] })
+	params := make([]*node, 0, len(n.params))
+	for _, p := range n.params {
+		params = append(params, p.leaf)
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].segment < params[j].segment })
+	leaves = append(leaves, params...)
+	if n.wildcard != nil {
+		leaves = append(leaves, n.wildcard)
+	}
+	return leaves
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func routeTable(router *Router) []string {
+	table := []string{}
+	for _, route := range router.Routes() {
+		table = append(table, route.Method+" "+route.Pattern)
+	}
+	return table
+}
+
+func TestRoutes(t *testing.T) {
+	routes := [][2]string{
+		{"GET", "/"},
+		{"GET", "/book"},
+		{"POST", "/book"},
+		{"GET", "/book/:id:^[0-9]+$"},
+		{"DELETE", "/book/:id:^[0-9]+$"},
+		{"GET", "/book/:slug:^[a-z]+$/reviews"},
+		{"GET", "/book/author/:id:^[0-9]+$"},
+		{"GET", "/static/*filepath"},
+	}
+	want := []string{
+		"GET /",
+		"GET /admin/stats",
+		"GET /book",
+		"POST /book",
+		"DELETE /book/:id:^[0-9]+$",
+		"GET /book/:id:^[0-9]+$",
+		"GET /book/:slug:^[a-z]+$/reviews",
+		"GET /book/author/:id:^[0-9]+$",
+		"GET /static/*filepath",
+	}
+
+	register := func(order []int) *Router {
+		router := NewRouter()
+		for _, i := range order {
+			router.Handle(routes[i][1], routes[i][0], reply(routes[i][1]))
+		}
+		admin := NewRouter()
+		admin.Get("/stats", reply("stats"))
+		router.Mount("/admin", admin)
+		return router
+	}
+
+	forward := register([]int{0, 1, 2, 3, 4, 5, 6, 7})
+	backward := register([]int{7, 6, 5, 4, 3, 2, 1, 0})
+	if got := routeTable(forward); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes() =\n%q\nwant\n%q", got, want)
+	}
+	if got := routeTable(backward); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes() in reverse registration order =\n%q\nwant\n%q", got, want)
+	}
+
+	var walkForward, walkBackward []string
+	forward.Walk(func(route RouteInfo) error {
+		walkForward = append(walkForward, route.Method+" "+route.Pattern)
+		return nil
+	})
+	backward.Walk(func(route RouteInfo) error {
+		walkBackward = append(walkBackward, route.Method+" "+route.Pattern)
+		return nil
+	})
+	if !reflect.DeepEqual(walkForward, walkBackward) {
+		t.Errorf("Walk order depends on registration order:\n%q\n%q", walkForward, walkBackward)
+	}
+
+	// every listed route is reachable with its handler
+	for _, route := range forward.Routes() {
+		if route.Handler == nil {
+			t.Errorf("%s %s has no handler", route.Method, route.Pattern)
+		}
+	}
+}
+
+func TestWalkError(t *testing.T) {
+	router := NewRouter()
+	router.Get("/a", reply("a"))
+	router.Get("/b", reply("b"))
+
+	stop := errors.New("stop")
+	visited := 0
+	err := router.Walk(func(route RouteInfo) error {
+		visited++
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Errorf("Walk = %v after %d routes, want %v after 1", err, visited, stop)
+	}
+}
+
+func TestStats(t *testing.T) {
+	router := NewRouter()
+	if stats := router.Stats(); stats.Nodes != 1 || stats.Bytes <= 0 {
+		t.Errorf("Stats of an empty router = %+v, want the root", stats)
+	}
+	router.Get("/book/:id", reply("book"))
+	router.Get("/book/:id/reviews", reply("reviews"))
+	before := router.Stats()
+	if before.Nodes != 4 {
+		t.Errorf("Stats.Nodes = %d, want 4", before.Nodes)
+	}
+	for i := 0; i < 20; i++ {
+		router.Get(fmt.Sprintf("/file%02d", i), reply("file"))
+	}
+	if after := router.Stats(); after.Nodes != before.Nodes+20 || after.Bytes <= before.Bytes {
+		t.Errorf("Stats after 20 routes = %+v, before %+v", after, before)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecureHeadersOptions configures the SecureHeaders middleware. The empty
+// fields take the default values.
+type SecureHeadersOptions struct {
+	// FrameOptions is the X-Frame-Options value, "DENY" by default.
+	FrameOptions string
+	// ReferrerPolicy is the Referrer-Policy value,
+	// "strict-origin-when-cross-origin" by default.
+	ReferrerPolicy string
+	// HSTSMaxAge is the Strict-Transport-Security max-age, one year by
+	// default. HSTSSubdomains adds includeSubDomains.
+	HSTSMaxAge     time.Duration
+	HSTSSubdomains bool
+	// TrustForwardedProto sends Strict-Transport-Security when a proxy says
+	// with X-Forwarded-Proto that the request arrived over https.
+	TrustForwardedProto bool
+	// ContentSecurityPolicy is the Content-Security-Policy value, none by
+	// default. Every "{nonce}" is replaced by a random nonce, new for each
+	// request and returned by CSPNonce.
+	ContentSecurityPolicy string
+	// Omit lists the headers not to send, such as "X-Frame-Options".
+	Omit []string
+}
+
+// SecureHeaders returns a middleware sending the X-Content-Type-Options,
+// X-Frame-Options, Referrer-Policy, and for requests over TLS the
+// Strict-Transport-Security headers, plus Content-Security-Policy when set.
+// A header already set is kept, and the handler can set its own.
+func SecureHeaders(opts SecureHeadersOptions) middleware {
+	if opts.FrameOptions == "" {
+		opts.FrameOptions = "DENY"
+	}
+	if opts.ReferrerPolicy == "" {
+		opts.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if opts.HSTSMaxAge == 0 {
+		opts.HSTSMaxAge = 365 * 24 * time.Hour
+	}
+	hsts := "max-age=" + strconv.FormatInt(int64(opts.HSTSMaxAge/time.Second), 10)
+	if opts.HSTSSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	omit := map[string]bool{}
+	for _, name := range opts.Omit {
+		omit[http.CanonicalHeaderKey(name)] = true
+	}
+	nonce := strings.Contains(opts.ContentSecurityPolicy, "{nonce}")
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			set := func(name, value string) {
+				if value != "" && !omit[name] && header.Get(name) == "" {
+					header.Set(name, value)
+				}
+			}
+			set("X-Content-Type-Options", "nosniff")
+			set("X-Frame-Options", opts.FrameOptions)
+			set("Referrer-Policy", opts.ReferrerPolicy)
+			if r.TLS != nil || (opts.TrustForwardedProto && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+				set("Strict-Transport-Security", hsts)
+			}
+			if nonce {
+				n := newNonce()
+				set("Content-Security-Policy", strings.ReplaceAll(opts.ContentSecurityPolicy, "{nonce}", n))
+				r = r.WithContext(context.WithValue(r.Context(), nonceKey, n))
+			} else {
+				set("Content-Security-Policy", opts.ContentSecurityPolicy)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSPNonce returns the nonce of the Content-Security-Policy sent by
+// SecureHeaders, for the templates to write in their script and style tags,
+// or "".
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey).(string)
+	return nonce
+}
+
+func newNonce() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
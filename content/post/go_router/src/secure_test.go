@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	router := NewRouter()
+	router.Use(SecureHeaders(SecureHeadersOptions{
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+		TrustForwardedProto:   true,
+		Omit:                  []string{"referrer-policy"},
+	}))
+	router.Get("/home", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSPNonce(r)))
+	})
+	router.Get("/own", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+	})
+
+	w := serve(router, http.MethodGet, "/home")
+	want := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "",
+		"Strict-Transport-Security": "", // plaintext request
+		"Content-Security-Policy":   "script-src 'nonce-" + w.Body.String() + "'",
+	}
+	for name, value := range want {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if w.Body.Len() == 0 || strings.Contains(w.Body.String(), "{") {
+		t.Errorf("CSPNonce = %q, want a nonce", w.Body.String())
+	}
+	if other := serve(router, http.MethodGet, "/home"); other.Body.String() == w.Body.String() {
+		t.Errorf("the nonce %q did not change between requests", w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/home", nil)
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("Strict-Transport-Security over TLS = %q, want max-age=31536000", got)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/home", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if got := w.Header().Get("Strict-Transport-Security"); got == "" {
+		t.Error("Strict-Transport-Security missing behind a trusted https proxy")
+	}
+
+	if got := serve(router, http.MethodGet, "/own").Header().Get("Content-Security-Policy"); got != "default-src 'self'" {
+		t.Errorf("Content-Security-Policy = %q, want the handler one", got)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ServeOptions configures ListenAndServe.
+type ServeOptions struct {
+	// Signals start the shutdown, os.Interrupt and SIGTERM by default.
+	Signals []os.Signal
+	// Timeout bounds the shutdown from the signal on, DrainDelay included:
+	// the connections still open then are closed. Zero waits for every
+	// request.
+	Timeout time.Duration
+	// DrainDelay is how long the router keeps serving once Draining, before
+	// the shutdown begins, for the load balancers to notice that it is not
+	// ready anymore. Another signal cuts it short.
+	DrainDelay time.Duration
+	// OnShutdown is called when the shutdown begins, for the hijacked
+	// connections like websockets to be closed, or the entries queued by an
+	// AccessLogger to be written by its Close, see
+	// http.Server.RegisterOnShutdown.
+	OnShutdown func()
+	// Server is the server started, with its timeouts, a new one by default.
+	// Its Handler is the router unless set.
+	Server *http.Server
+	// EnableH2C serves HTTP/2 without TLS too, on the same port as HTTP/1,
+	// to the clients starting with the HTTP/2 preface, with the
+	// UnencryptedHTTP2 of http.Protocols. The HTTP/1 requests asking for an
+	// Upgrade: h2c, deprecated by RFC 9113, are served over HTTP/1.
+	EnableH2C bool
+}
+
+// ShutdownOptions is the name ServeOptions had before it configured h2c.
+type ShutdownOptions = ServeOptions
+
+// ListenAndServe serves the router on the TCP address addr until one of the
+// signals of opts is received, then marks it Draining and shuts the server
+// down gracefully. It returns the error of the server, or of the shutdown
+// when it timed out, and nil once every request was served.
+func (router *Router) ListenAndServe(addr string, opts ServeOptions) error {
+	return router.Serve(addr, opts)
+}
+
+// Serve is ListenAndServe.
+func (router *Router) Serve(addr string, opts ServeOptions) error {
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return router.ServeListener(l, opts)
+}
+
+// ServeListener is ListenAndServe on the connections accepted by l.
+func (router *Router) ServeListener(l net.Listener, opts ServeOptions) error {
+	server := opts.Server
+	if server == nil {
+		server = &http.Server{}
+	}
+	if server.Handler == nil {
+		server.Handler = router
+	}
+	if opts.OnShutdown != nil {
+		server.RegisterOnShutdown(opts.OnShutdown)
+	}
+	if opts.EnableH2C {
+		enableH2C(server)
+	}
+	signals := opts.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, signals...)
+	defer signal.Stop(stop)
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+	select {
+	case err := <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil // shut down by the caller, through opts.Server
+		}
+		return err
+	case <-stop:
+	}
+
+	router.draining.Store(true)
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if opts.DrainDelay > 0 {
+		delay := time.NewTimer(opts.DrainDelay)
+		select {
+		case <-delay.C:
+		case <-stop:
+		case <-ctx.Done():
+		}
+		delay.Stop()
+	}
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+	}
+	if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
+
+// Draining reports whether ListenAndServe is shutting the router down, the
+// readiness checks fail then.
+func (router *Router) Draining() bool {
+	return router.draining.Load()
+}
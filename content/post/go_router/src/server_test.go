@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// serveSlow serves router with opts, and a GET /slow waiting for release
+// before answering, until "started" is interrupted. It returns the answer of
+// the request and the error of Serve.
+func serveSlow(t *testing.T, opts ServeOptions, release <-chan struct{}) (string, error) {
+	started := make(chan struct{})
+	router := NewRouter()
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+			io.WriteString(w, "done")
+		case <-r.Context().Done():
+		}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- router.ServeListener(l, opts) }()
+	answered := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			answered <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		answered <- string(body)
+	}()
+
+	<-started
+	if stats := router.Stats(); stats.InFlight != 1 || router.Draining() {
+		t.Errorf("serving /slow: %d requests in flight, draining %v", stats.InFlight, router.Draining())
+	}
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skip("cannot interrupt the test:", err)
+	}
+	for !router.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	return <-answered, <-served
+}
+
+func TestServeShutdown(t *testing.T) {
+	release := make(chan struct{})
+	shutdown := make(chan struct{})
+	go func() {
+		<-shutdown
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	body, err := serveSlow(t, ServeOptions{Timeout: 5 * time.Second, OnShutdown: func() { close(shutdown) }}, release)
+	if err != nil || body != "done" {
+		t.Errorf("Serve = %v with /slow answered %q, want the request drained", err, body)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	body, err := serveSlow(t, ShutdownOptions{Timeout: 50 * time.Millisecond}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || body == "done" {
+		t.Errorf("Serve = %v with /slow answered %q, want the deadline error and the connection closed", err, body)
+	}
+}
+
+func TestServeDrainDelayTimeout(t *testing.T) {
+	start := time.Now()
+	body, err := serveSlow(t, ServeOptions{Timeout: 50 * time.Millisecond, DrainDelay: time.Hour}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || body == "done" || time.Since(start) > 5*time.Second {
+		t.Errorf("Serve = %v with /slow answered %q after %v, want the timeout to cut the drain delay short", err, body, time.Since(start))
+	}
+}
+
+func TestServeAddr(t *testing.T) {
+	if err := NewRouter().Serve("127.0.0.1:-1", ServeOptions{}); err == nil {
+		t.Error("Serve on an invalid address = nil, want the listen error")
+	}
+}
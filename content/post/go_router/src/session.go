@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CookieOptions configures the cookie of the Sessions middleware.
+type CookieOptions struct {
+	// Name names the cookie, "session" by default.
+	Name string
+	// Path and Domain scope the cookie, by default it is sent for every
+	// path of the host.
+	Path   string
+	Domain string
+	// MaxAge is the lifetime of the cookie and of the session in the store.
+	// Without it the cookie lasts as long as the browser session.
+	MaxAge time.Duration
+	// Secure and SameSite set the cookie attributes, SameSite=Lax by
+	// default.
+	Secure   bool
+	SameSite http.SameSite
+	// ScriptAccess leaves out the HttpOnly attribute, set by default.
+	ScriptAccess bool
+}
+
+// Store saves the sessions of the Sessions middleware.
+type Store interface {
+	// Load returns the ID and the values of the session of the cookie
+	// value, nil values when there is none or the cookie is invalid.
+	Load(cookie string) (id string, values map[string]any, err error)
+	// Save saves the values of the session id for maxAge, or the lifetime
+	// of the store when 0, and returns the cookie value.
+	Save(id string, values map[string]any, maxAge time.Duration) (cookie string, err error)
+	// Delete deletes the session id, after it was renewed.
+	Delete(id string) error
+}
+
+// Sessions returns a middleware giving every request a SessionData, returned
+// by Session. It is loaded from store on first use and saved, with its cookie
+// set, only when the handler changed it: the changes made once the handler
+// started the response are lost.
+func Sessions(store Store, opts CookieOptions) middleware {
+	if opts.Name == "" {
+		opts.Name = "session"
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw, rec := newResponseRecorder(w)
+			s := &SessionData{store: store, w: rw, r: r}
+			if cookie, err := r.Cookie(opts.Name); err == nil {
+				s.cookie = cookie.Value
+			}
+			rec.onHeader = func() { s.save(opts) }
+			h.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), sessionKey, s)))
+			if rec.status == 0 {
+				s.save(opts) // nothing was written, the server sends the header now
+			}
+		})
+	}
+}
+
+// Session returns the session of r, nil without the Sessions middleware.
+// Like the request, it is not safe for concurrent use.
+func Session(r *http.Request) *SessionData {
+	s, _ := r.Context().Value(sessionKey).(*SessionData)
+	return s
+}
+
+// SessionData holds the values kept across the requests of a client. The values
+// go through encoding/gob with CookieStore, their types other than the
+// basic ones must be registered with gob.Register.
+type SessionData struct {
+	store   Store
+	w       http.ResponseWriter
+	r       *http.Request
+	cookie  string
+	loaded  bool
+	id      string
+	values  map[string]any
+	changed bool
+	renewed string // the ID the session had before Renew
+}
+
+func (s *SessionData) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	if s.cookie != "" {
+		id, values, err := s.store.Load(s.cookie)
+		if err != nil {
+			logError(s.w, s.r, err, "loading the session")
+		} else if values != nil {
+			s.id, s.values = id, values
+		}
+	}
+	if s.values == nil {
+		s.values = map[string]any{}
+	}
+}
+
+// change marks the session to be saved, giving it an ID if it is new.
+func (s *SessionData) change() {
+	s.changed = true
+	if s.id == "" {
+		s.id = newSessionID()
+	}
+}
+
+// ID returns the ID of the session, "" for a new session not changed yet.
+func (s *SessionData) ID() string {
+	s.load()
+	return s.id
+}
+
+// Get returns the value of key, or nil.
+func (s *SessionData) Get(key string) any {
+	s.load()
+	return s.values[key]
+}
+
+// Set sets the value of key.
+func (s *SessionData) Set(key string, value any) {
+	s.load()
+	s.values[key] = value
+	s.change()
+}
+
+// Delete deletes key.
+func (s *SessionData) Delete(key string) {
+	s.load()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.change()
+	}
+}
+
+// Flash returns the value of key and deletes it, for the messages shown
+// once by the next page, like "saved".
+func (s *SessionData) Flash(key string) any {
+	value := s.Get(key)
+	s.Delete(key)
+	return value
+}
+
+// Renew gives the session a new ID, keeping its values, and deletes the
+// former one from the store. A login must renew the session, so an ID set
+// by an attacker before it is worthless.
+func (s *SessionData) Renew() {
+	s.load()
+	if s.id != "" && s.renewed == "" {
+		s.renewed = s.id
+	}
+	s.id = ""
+	s.change()
+}
+
+func (s *SessionData) save(opts CookieOptions) {
+	if !s.changed {
+		return
+	}
+	s.changed = false
+	if s.renewed != "" {
+		if err := s.store.Delete(s.renewed); err != nil {
+			logError(s.w, s.r, err, "deleting the renewed session")
+		}
+		s.renewed = ""
+	}
+	value, err := s.store.Save(s.id, s.values, opts.MaxAge)
+	if err != nil {
+		logError(s.w, s.r, err, "saving the session")
+		return
+	}
+	http.SetCookie(s.w, &http.Cookie{
+		Name: opts.Name, Value: value, Path: opts.Path, Domain: opts.Domain,
+		MaxAge: int(opts.MaxAge / time.Second), Secure: opts.Secure,
+		HttpOnly: !opts.ScriptAccess, SameSite: opts.SameSite,
+	})
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// MemoryStore keeps the sessions in memory, the cookie holding their ID. It
+// suits a single server process, the sessions are lost when it restarts.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	ttl      time.Duration
+	saves    int
+}
+
+type memorySession struct {
+	values  map[string]any
+	expires time.Time
+}
+
+// NewMemoryStore returns a MemoryStore keeping the sessions saved without a
+// MaxAge for ttl.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{sessions: map[string]memorySession{}, ttl: ttl}
+}
+
+func (store *MemoryStore) Load(cookie string) (string, map[string]any, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	s, ok := store.sessions[cookie]
+	if !ok || time.Now().After(s.expires) {
+		return "", nil, nil
+	}
+	return cookie, maps.Clone(s.values), nil
+}
+
+func (store *MemoryStore) Save(id string, values map[string]any, maxAge time.Duration) (string, error) {
+	if maxAge == 0 {
+		maxAge = store.ttl
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	if store.saves++; store.saves%100 == 0 {
+		for id, s := range store.sessions {
+			if now.After(s.expires) {
+				delete(store.sessions, id)
+			}
+		}
+	}
+	store.sessions[id] = memorySession{values: maps.Clone(values), expires: now.Add(maxAge)}
+	return id, nil
+}
+
+func (store *MemoryStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.sessions, id)
+	return nil
+}
+
+// CookieStore keeps the sessions in the cookie itself, signed with
+// HMAC-SHA256 and, with encryption keys, encrypted with AES-GCM so the
+// client cannot read them. Browsers keep cookies of up to 4096 bytes, Save
+// fails past that.
+type CookieStore struct {
+	keys  [][]byte
+	aeads []cipher.AEAD
+}
+
+// NewCookieStore returns a CookieStore signing with keys and encrypting
+// with encryptionKeys, of 16, 24 or 32 bytes, unless nil. In both
+// lists the first key signs or encrypts the new cookies, the others are
+// former keys still accepted, so a key can be rotated. NewCookieStore
+// panics without a signing key or with an encryption key of a wrong size.
+func NewCookieStore(keys, encryptionKeys [][]byte) *CookieStore {
+	if len(keys) == 0 {
+		panic("router: NewCookieStore needs a key")
+	}
+	store := &CookieStore{keys: keys}
+	for _, key := range encryptionKeys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			panic("router: NewCookieStore: " + err.Error())
+		}
+		aead, _ := cipher.NewGCM(block)
+		store.aeads = append(store.aeads, aead)
+	}
+	return store
+}
+
+// cookieSession is what a CookieStore cookie holds.
+type cookieSession struct {
+	ID      string
+	Values  map[string]any
+	Expires int64 // Unix time, 0 for none
+}
+
+// A cookie is "data.signature", both base64url encoded, where data is the
+// cookieSession gob encoded and then encrypted, as nonce and ciphertext.
+func (store *CookieStore) Save(id string, values map[string]any, maxAge time.Duration) (string, error) {
+	s := cookieSession{ID: id, Values: values}
+	if maxAge != 0 {
+		s.Expires = time.Now().Add(maxAge).Unix()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return "", fmt.Errorf("router: encoding the session: %w", err)
+	}
+	data := buf.Bytes()
+	if len(store.aeads) > 0 {
+		aead := store.aeads[0]
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+		rand.Read(nonce)
+		data = aead.Seal(nonce, nonce, data, nil)
+	}
+	cookie := base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(signSession(store.keys[0], data))
+	if len(cookie) > 4096 {
+		return "", fmt.Errorf("router: session cookie of %d bytes, over 4096", len(cookie))
+	}
+	return cookie, nil
+}
+
+func (store *CookieStore) Load(cookie string) (string, map[string]any, error) {
+	encoded, signature, _ := strings.Cut(cookie, ".")
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, nil
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !store.verify(data, mac) {
+		return "", nil, nil
+	}
+	if len(store.aeads) > 0 {
+		if data = store.open(data); data == nil {
+			return "", nil, nil
+		}
+	}
+	var s cookieSession
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return "", nil, nil
+	}
+	if s.Expires != 0 && time.Now().Unix() > s.Expires {
+		return "", nil, nil
+	}
+	if s.Values == nil {
+		s.Values = map[string]any{} // gob drops an empty map
+	}
+	return s.ID, s.Values, nil
+}
+
+// Delete does nothing, the cookie is the session.
+func (store *CookieStore) Delete(id string) error {
+	return nil
+}
+
+func signSession(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (store *CookieStore) verify(data, mac []byte) bool {
+	for _, key := range store.keys {
+		if hmac.Equal(signSession(key, data), mac) {
+			return true
+		}
+	}
+	return false
+}
+
+// open decrypts data with the first key that can, it returns nil when none
+// can.
+func (store *CookieStore) open(data []byte) []byte {
+	for _, aead := range store.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+			return plain
+		}
+	}
+	return nil
+}
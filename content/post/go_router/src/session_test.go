@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	sessionKey1 = []byte("0123456789abcdef0123456789abcdef")
+	sessionKey2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// newSessionRouter counts the visits of a client and renews its session on
+// POST /login.
+func newSessionRouter(store Store, opts CookieOptions) *Router {
+	router := NewRouter()
+	router.Use(Sessions(store, opts))
+	router.Get("/visit", func(w http.ResponseWriter, r *http.Request) {
+		s := Session(r)
+		visits, _ := s.Get("visits").(int)
+		s.Set("visits", visits+1)
+		fmt.Fprintf(w, "%d %v", visits+1, s.Flash("notice"))
+	})
+	router.Get("/read", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, Session(r).Get("visits"))
+	})
+	router.Post("/login", func(w http.ResponseWriter, r *http.Request) {
+		s := Session(r)
+		s.Renew()
+		s.Set("user", "ada")
+		s.Set("notice", "welcome")
+	})
+	return router
+}
+
+// sessionRequest sends a request with the cookie, and returns the body and
+// the new session cookie, or nil.
+func sessionRequest(router http.Handler, method, path string, cookie *http.Cookie) (string, *http.Cookie) {
+	r := httptest.NewRequest(method, path, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		return w.Body.String(), c
+	}
+	return w.Body.String(), nil
+}
+
+func TestSessions(t *testing.T) {
+	stores := map[string]Store{
+		"memory":    NewMemoryStore(time.Hour),
+		"signed":    NewCookieStore([][]byte{sessionKey1}, nil),
+		"encrypted": NewCookieStore([][]byte{sessionKey1}, [][]byte{sessionKey2}),
+	}
+	for name, store := range stores {
+		router := newSessionRouter(store, CookieOptions{MaxAge: time.Hour, Secure: true})
+		body, cookie := sessionRequest(router, http.MethodGet, "/visit", nil)
+		if body != "1 <nil>" || cookie == nil {
+			t.Fatalf("%s: first visit = %q with the cookie %v", name, body, cookie)
+		}
+		if cookie.Name != "session" || cookie.Path != "/" || !cookie.HttpOnly || !cookie.Secure ||
+			cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge != 3600 {
+			t.Errorf("%s: cookie %s", name, cookie.String())
+		}
+		if name == "encrypted" && strings.Contains(cookie.Value, "visits") {
+			t.Errorf("%s: the cookie %q is readable", name, cookie.Value)
+		}
+
+		body, next := sessionRequest(router, http.MethodGet, "/visit", cookie)
+		if body != "2 <nil>" || next == nil {
+			t.Fatalf("%s: second visit = %q with the cookie %v", name, body, next)
+		}
+		cookie = next
+		if body, unchanged := sessionRequest(router, http.MethodGet, "/read", cookie); body != "2" || unchanged != nil {
+			t.Errorf("%s: reading the session = %q, set the cookie %v", name, body, unchanged)
+		}
+
+		tampered := *cookie
+		tampered.Value = cookie.Value[:len(cookie.Value)-2] + "xx"
+		if body, _ := sessionRequest(router, http.MethodGet, "/read", &tampered); body != "<nil>" {
+			t.Errorf("%s: tampered cookie read %q", name, body)
+		}
+	}
+}
+
+func TestSessionRenew(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	var ids []string
+	router := newSessionRouter(store, CookieOptions{})
+	router.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r)
+			ids = append(ids, Session(r).ID())
+		})
+	})
+
+	_, before := sessionRequest(router, http.MethodGet, "/visit", nil)
+	_, after := sessionRequest(router, http.MethodPost, "/login", before)
+	if after == nil || after.Value == before.Value || ids[0] == ids[1] {
+		t.Fatalf("login kept the session %v, IDs %q", after, ids)
+	}
+	if _, values, _ := store.Load(before.Value); values != nil {
+		t.Error("the renewed session is still in the store")
+	}
+	if body, _ := sessionRequest(router, http.MethodGet, "/visit", after); body != "2 welcome" {
+		t.Errorf("visit after login = %q, want the values kept and the flash", body)
+	}
+	if body, _ := sessionRequest(router, http.MethodGet, "/visit", after); body != "3 <nil>" {
+		t.Errorf("second visit after login = %q, want the flash gone", body)
+	}
+}
+
+func TestCookieStoreKeys(t *testing.T) {
+	old := NewCookieStore([][]byte{sessionKey1}, [][]byte{sessionKey1})
+	rotated := NewCookieStore([][]byte{sessionKey2, sessionKey1}, [][]byte{sessionKey2, sessionKey1})
+	cookie, err := old.Save("id", map[string]any{"user": "ada"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, values, _ := rotated.Load(cookie); id != "id" || values["user"] != "ada" {
+		t.Errorf("rotated store loaded %q %v, want the cookie of the former key", id, values)
+	}
+	cookie, _ = rotated.Save("id", map[string]any{"user": "ada"}, 0)
+	if _, values, _ := old.Load(cookie); values != nil {
+		t.Error("the new key signed and encrypted like the former one")
+	}
+
+	expired, _ := old.Save("id", map[string]any{}, -time.Minute)
+	if _, values, _ := old.Load(expired); values != nil {
+		t.Error("loaded an expired session")
+	}
+	expired, _ = old.Save("id", map[string]any{}, time.Second)
+	if _, values, _ := old.Load(expired); values == nil {
+		t.Error("lost an empty session")
+	}
+	if _, err := old.Save("id", map[string]any{"big": strings.Repeat("x", 5000)}, 0); err == nil {
+		t.Error("saved a cookie over 4096 bytes")
+	}
+}
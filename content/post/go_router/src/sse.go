@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeat is the interval of the heartbeat comments of the event
+// streams, proxies commonly drop the connections idle for a minute.
+const DefaultHeartbeat = 15 * time.Second
+
+// EventStream is a response of server-sent events, returned by SSE.
+type EventStream struct {
+	// LastEventID is the ID of the last event the client received, sent
+	// back when it reconnects: the events after it are to be sent again.
+	LastEventID string
+
+	w    http.ResponseWriter
+	rc   *http.ResponseController
+	r    *http.Request
+	mu   sync.Mutex // serializes the writes with the heartbeat
+	err  error      // of the first write failing or net.ErrClosed, guarded by mu
+	beat chan time.Duration
+	stop chan struct{}
+	once sync.Once // closes stop
+	done chan struct{}
+}
+
+// SSE starts a text/event-stream response to r, sending a heartbeat comment
+// every DefaultHeartbeat. It fails, before writing anything, when w cannot
+// be flushed. The stream must be closed before the handler returns.
+func SSE(w http.ResponseWriter, r *http.Request) (*EventStream, error) {
+	rc := http.NewResponseController(w)
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // for nginx
+	if r.ProtoMajor == 1 {
+		header.Set("Connection", "keep-alive")
+	}
+	header.Del("Content-Length")
+	if err := rc.Flush(); err != nil {
+		for _, name := range []string{"Content-Type", "Cache-Control", "X-Accel-Buffering", "Connection"} {
+			header.Del(name)
+		}
+		return nil, fmt.Errorf("router: SSE: %w", err)
+	}
+
+	s := &EventStream{
+		LastEventID: r.Header.Get("Last-Event-ID"),
+		w:           w,
+		rc:          rc,
+		r:           r,
+		beat:        make(chan time.Duration),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go s.heartbeat(DefaultHeartbeat)
+	return s, nil
+}
+
+// Send sends an event of type event, "message" when empty, with id unless
+// empty. A string or []byte data is sent as is, on as many data lines as it
+// has lines, and any other value encoded as JSON. Send returns the error of
+// the write, or the one of the request context once the client is gone.
+func (s *EventStream) Send(event, id string, data any) error {
+	if strings.ContainsAny(event, "\r\n") || strings.ContainsAny(id, "\r\n\x00") {
+		return errors.New("router: SSE: line break in an event type or ID")
+	}
+	var text string
+	switch data := data.(type) {
+	case string:
+		text = data
+	case []byte:
+		text = string(data)
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("router: SSE: %w", err)
+		}
+		text = string(b)
+	}
+
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	if id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(text, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+// SetRetry tells the client to wait d before reconnecting once the stream
+// is lost.
+func (s *EventStream) SetRetry(d time.Duration) error {
+	return s.write([]byte(fmt.Sprintf("retry: %d\n\n", d.Milliseconds())))
+}
+
+// SetHeartbeat changes the interval of the heartbeat comments, 0 stops them.
+func (s *EventStream) SetHeartbeat(interval time.Duration) {
+	select {
+	case s.beat <- interval:
+	case <-s.done:
+	}
+}
+
+// Done is closed once the client is gone.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.r.Context().Done()
+}
+
+// Close ends the stream: nothing is written once it returns.
+func (s *EventStream) Close() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = net.ErrClosed
+	}
+	s.mu.Unlock()
+}
+
+func (s *EventStream) write(b []byte) error {
+	if err := s.r.Context().Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.w.Write(b); err != nil {
+		s.err = err
+		return err
+	}
+	s.err = s.rc.Flush()
+	return s.err
+}
+
+func (s *EventStream) heartbeat(interval time.Duration) {
+	defer close(s.done)
+	var tick <-chan time.Time
+	var ticker *time.Ticker
+	reset := func(interval time.Duration) {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if interval > 0 {
+			ticker = time.NewTicker(interval)
+			tick = ticker.C
+		}
+	}
+	reset(interval)
+	defer reset(0)
+	for {
+		select {
+		case <-tick:
+			if s.write([]byte(":\n\n")) != nil {
+				return
+			}
+		case interval := <-s.beat:
+			reset(interval)
+		case <-s.stop:
+			return
+		case <-s.r.Context().Done():
+			return
+		}
+	}
+}
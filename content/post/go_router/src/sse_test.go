@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSSE(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Last-Event-ID", "6")
+	w := httptest.NewRecorder()
+	s, err := SSE(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LastEventID != "6" {
+		t.Errorf("LastEventID = %q, want 6", s.LastEventID)
+	}
+	s.SetRetry(3 * time.Second)
+	s.Send("update", "7", map[string]int{"stock": 3})
+	s.Send("", "", "line 1\nline 2\r\nline 3\r")
+	if err := s.Send("bad\nevent", "", "x"); err == nil {
+		t.Error("sent an event type with a line break")
+	}
+	s.Close()
+	if err := s.Send("", "", "late"); err == nil {
+		t.Error("sent an event after Close")
+	}
+
+	want := "retry: 3000\n\n" +
+		"event: update\nid: 7\ndata: {\"stock\":3}\n\n" +
+		"data: line 1\ndata: line 2\ndata: line 3\ndata: \n\n"
+	if w.Body.String() != want {
+		t.Errorf("stream = %q, want %q", w.Body.String(), want)
+	}
+	if w.Header().Get("Content-Type") != "text/event-stream" || w.Header().Get("Cache-Control") != "no-cache" || !w.Flushed {
+		t.Errorf("stream headers %v, flushed %v", w.Header(), w.Flushed)
+	}
+
+	w = httptest.NewRecorder()
+	if _, err := SSE(struct{ http.ResponseWriter }{w}, r); err == nil || w.Header().Get("Content-Type") != "" {
+		t.Errorf("SSE without a Flusher = %v with the headers %v", err, w.Header())
+	}
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	ended := make(chan error, 1)
+	router := NewRouter()
+	router.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+		s, err := SSE(w, r)
+		if err != nil {
+			ended <- err
+			return
+		}
+		defer s.Close()
+		s.SetHeartbeat(10 * time.Millisecond)
+		<-s.Done()
+		ended <- s.Send("", "", "gone")
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.Get("Connection") != "keep-alive" {
+		t.Errorf("Connection = %q", res.Header.Get("Connection"))
+	}
+	lines := bufio.NewScanner(res.Body)
+	if !lines.Scan() || lines.Text() != ":" {
+		t.Errorf("first line %q, want a heartbeat comment", lines.Text())
+	}
+	cancel()
+	select {
+	case err := <-ended:
+		if err == nil {
+			t.Error("sent an event once the client was gone")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the stream did not end with the request context")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Static serves the files of root under prefix, "/assets/css/site.css" is read
+// from "/css/site.css" in root. Missing files and paths escaping root with
+// ".." get a 404. Explicit routes under prefix take precedence over files.
+func (g *Group) Static(prefix string, root http.FileSystem) error {
+	files := http.FileServer(root)
+	return g.Get(strings.TrimSuffix(prefix, "/")+"/*filepath", func(w http.ResponseWriter, r *http.Request) {
+		name := Param(r, "filepath")
+		for _, c := range strings.Split(name, "/") {
+			if c == ".." {
+				g.router.notFoundHandler().ServeHTTP(w, r)
+				return
+			}
+		}
+		f, err := root.Open("/" + name)
+		if err != nil {
+			g.router.notFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		f.Close()
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + name
+		r2.URL.RawPath = ""
+		files.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// naiveDir is a FileSystem that lets ".." escape its root.
+type naiveDir string
+
+func (d naiveDir) Open(name string) (http.File, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+func TestStatic(t *testing.T) {
+	dir := t.TempDir()
+	public := filepath.Join(dir, "public")
+	for name, data := range map[string]string{
+		"secret":                  "secret",
+		"public/site.css":         "body {}",
+		"public/js/vendor/app.js": "app()",
+		"public/manifest.json":    "{}",
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := NewRouter()
+	if err := router.Static("/assets", naiveDir(public)); err != nil {
+		t.Fatalf("Static = %v", err)
+	}
+	router.Get("/assets/manifest.json", reply("dynamic manifest"))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/assets/site.css", http.StatusOK, "body {}"},
+		{"/assets/js/vendor/app.js", http.StatusOK, "app()"},
+		{"/assets/manifest.json", http.StatusOK, "dynamic manifest"},
+		{"/assets/missing.css", http.StatusNotFound, "404 page not found\n"},
+		{"/assets/../secret", http.StatusNotFound, "404 page not found\n"},
+		{"/assets/js/../../secret", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
+
+func TestStaticNotFound(t *testing.T) {
+	router := NewRouter()
+	router.Static("/assets", http.Dir(t.TempDir()))
+	router.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "custom", http.StatusNotFound)
+	}))
+	if w := serve(router, http.MethodGet, "/assets/missing.css"); w.Body.String() != "custom\n" {
+		t.Errorf("GET /assets/missing.css = %q, want the router's NotFound", w.Body.String())
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type StaticOption func(assets *assets)
+
+// WithImmutable marks the files whose name satisfies match, typically
+// Fingerprinted, as immutable so clients cache them for a year.
+func WithImmutable(match func(name string) bool) StaticOption {
+	return func(assets *assets) {
+		assets.immutable = match
+	}
+}
+
+var fingerprint = regexp.MustCompile(`\.[0-9a-f]{8,}\.`)
+
+// Fingerprinted reports whether name embeds a content hash, as in
+// "app.3f9a2c1d.js".
+func Fingerprinted(name string) bool {
+	return fingerprint.MatchString(path.Base(name))
+}
+
+type asset struct {
+	etag        string
+	contentType string
+}
+
+type assets struct {
+	fsys      fs.FS
+	files     map[string]asset
+	immutable func(name string) bool
+}
+
+// StaticFS serves the files of fsys under prefix. It is meant for an
+// embed.FS: files never change, so their ETag and Content-Type are computed
+// once here and conditional requests are answered with a 304.
+func (g *Group) StaticFS(prefix string, fsys fs.FS, opts ...StaticOption) error {
+	assets, err := newAssets(fsys, opts)
+	if err != nil {
+		return err
+	}
+	return g.Get(strings.TrimSuffix(prefix, "/")+"/*filepath", func(w http.ResponseWriter, r *http.Request) {
+		if !assets.serve(w, r, Param(r, "filepath")) {
+			g.router.notFoundHandler().ServeHTTP(w, r)
+		}
+	})
+}
+
+func newAssets(fsys fs.FS, opts []StaticOption) (*assets, error) {
+	assets := &assets{fsys: fsys, files: map[string]asset{}}
+	for _, opt := range opts {
+		opt(assets)
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hash := sha256.New()
+		sniff := make([]byte, 512)
+		n, _ := io.ReadFull(f, sniff)
+		hash.Write(sniff[:n])
+		if _, err := io.Copy(hash, f); err != nil {
+			return err
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = http.DetectContentType(sniff[:n])
+		}
+		assets.files[name] = asset{
+			etag:        `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`,
+			contentType: contentType,
+		}
+		return nil
+	})
+	return assets, err
+}
+
+// serve writes the file name, it returns false when there is no such file.
+func (assets *assets) serve(w http.ResponseWriter, r *http.Request, name string) bool {
+	a, ok := assets.files[name]
+	if !ok {
+		return false
+	}
+	f, err := assets.fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	w.Header().Set("ETag", a.etag)
+	w.Header().Set("Content-Type", a.contentType)
+	if assets.immutable != nil && assets.immutable(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	if content, ok := f.(io.ReadSeeker); ok {
+		// embedded files have no modification time, the ETag drives caching
+		http.ServeContent(w, r, name, time.Time{}, content)
+		return true
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return true
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	return true
+}
+
+// SPA serves a single-page app from fsys under prefix: files are served like
+// StaticFS, and GET requests for any other path under prefix that accept
+// text/html get the index document so the client can route them.
+func (g *Group) SPA(prefix string, fsys fs.FS, index string, opts ...StaticOption) error {
+	assets, err := newAssets(fsys, opts)
+	if err != nil {
+		return err
+	}
+	if _, ok := assets.files[index]; !ok {
+		return fmt.Errorf("router: SPA index %q not found", index)
+	}
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if assets.serve(w, r, Param(r, "filepath")) {
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			g.router.notFoundHandler().ServeHTTP(w, r)
+			return
+		}
+		assets.serve(w, r, index)
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	if err := g.Get(prefix+"/", h); err != nil {
+		return err
+	}
+	return g.Get(prefix+"/*filepath", h)
+}
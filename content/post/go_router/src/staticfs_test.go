@@ -0,0 +1,94 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//go:embed testdata/assets
+var testdata embed.FS
+
+func TestStaticFS(t *testing.T) {
+	fsys, _ := fs.Sub(testdata, "testdata/assets")
+	router := NewRouter()
+	if err := router.StaticFS("/assets", fsys, WithImmutable(Fingerprinted)); err != nil {
+		t.Fatalf("StaticFS = %v", err)
+	}
+
+	tests := []struct {
+		path, contentType, cacheControl string
+	}{
+		{"/assets/site.css", "text/css; charset=utf-8", ""},
+		{"/assets/img/logo.svg", "image/svg+xml", ""},
+		{"/assets/app.3f9a2c1d.js", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want %d", tt.path, w.Code, http.StatusOK)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("GET %s Content-Type = %q, want %q", tt.path, ct, tt.contentType)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != tt.cacheControl {
+			t.Errorf("GET %s Cache-Control = %q, want %q", tt.path, cc, tt.cacheControl)
+		}
+		etag := w.Header().Get("ETag")
+		if !strings.HasPrefix(etag, `"`) {
+			t.Fatalf("GET %s ETag = %q, want a strong ETag", tt.path, etag)
+		}
+
+		// revalidation round trip
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("If-None-Match", etag)
+		w2 := httptest.NewRecorder()
+		router.ServeHTTP(w2, r)
+		if w2.Code != http.StatusNotModified || w2.Body.Len() != 0 {
+			t.Errorf("GET %s If-None-Match = %d %q, want an empty 304", tt.path, w2.Code, w2.Body.String())
+		}
+	}
+
+	if w := serve(router, http.MethodGet, "/assets/missing.js"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /assets/missing.js = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSPA(t *testing.T) {
+	fsys, _ := fs.Sub(testdata, "testdata/assets")
+	router := NewRouter()
+	if err := router.SPA("/app", fsys, "index.html"); err != nil {
+		t.Fatalf("SPA = %v", err)
+	}
+	router.Get("/api/users", reply("users"))
+
+	index, _ := fs.ReadFile(fsys, "index.html")
+	tests := []struct {
+		method, path, accept string
+		code                 int
+		body                 string
+	}{
+		{http.MethodGet, "/app/settings/profile", "text/html,application/xhtml+xml", http.StatusOK, string(index)},
+		{http.MethodGet, "/app", "text/html", http.StatusOK, string(index)},
+		{http.MethodGet, "/app/site.css", "text/css", http.StatusOK, "body { margin: 0 }\n"},
+		{http.MethodGet, "/app/settings/profile", "application/json", http.StatusNotFound, "404 page not found\n"},
+		{http.MethodPost, "/app/settings/profile", "text/html", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{http.MethodGet, "/api/orders", "application/json", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	if err := NewRouter().SPA("/app", fsys, "missing.html"); err == nil {
+		t.Error("SPA with a missing index succeeded")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "errors"
+
+// Swap replaces every route of the router with the ones build registers on
+// a fresh router, for routes coming from a control plane. The new routes are
+// served all at once: the requests in flight finish with the old ones, and
+// when build registers a route the fresh router rejects, Swap returns the
+// rejections and the old routes keep serving. The fresh router has the
+// options, handlers and settings of the router but no routes. Only its
+// routes, with their groups, names and hosts, are taken: the middlewares of
+// the router keep wrapping them, and those used on the fresh router are
+// ignored. The router owns the routes taken: RouteMethods and RoutePattern
+// answer from its table, the fresh router can be dropped.
+func (router *Router) Swap(build func(fresh *Router)) error {
+	fresh := NewRouter(router.opts...)
+	seed := router.routes.Load().clone()
+	seed.trie, seed.hosts, seed.static, seed.cache, seed.loaded = newNode(), map[string]*Router{}, nil, nil, nil
+	fresh.routes.Store(seed)
+	build(fresh)
+	fresh.mu.Lock()
+	defer fresh.mu.Unlock()
+	if err := errors.Join(fresh.rejected...); err != nil {
+		return err
+	}
+	next := fresh.routes.Load()
+
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	err := router.update(func(t *table) error {
+		t.trie = rechain(next.trie, t.wrapRoute)
+		t.hosts = next.hosts
+		t.loaded = next.loaded
+		t.generation++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	router.names, router.rejected = fresh.names, nil
+	return nil
+}
+
+// Generation returns the number of times Swap replaced the routes, the
+// requests served with the same generation see the same routes.
+func (router *Router) Generation() uint64 {
+	return router.routes.Load().generation
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSwap(t *testing.T) {
+	router := NewRouter()
+	router.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Outer", "yes")
+			h.ServeHTTP(w, r)
+		})
+	})
+	router.Get("/old", reply("old"))
+	if got := router.Generation(); got != 0 {
+		t.Errorf("Generation() = %d, want 0", got)
+	}
+
+	err := router.Swap(func(fresh *Router) {
+		fresh.Use(func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Fresh", "yes")
+				h.ServeHTTP(w, r)
+			})
+		})
+		fresh.HandleNamed("new", "/new/:id", "GET", reply("new"))
+		api := fresh.Group("/api")
+		api.Get("/ping", reply("pong"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := router.Generation(); got != 1 {
+		t.Errorf("Generation() = %d, want 1", got)
+	}
+	if got := routeTable(router); strings.Join(got, ",") != "GET /api/ping,GET /new/:id" {
+		t.Errorf("Routes() = %q, want the routes of the build only", got)
+	}
+	if w := serve(router, "GET", "/old"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /old = %d, want 404", w.Code)
+	}
+	w := serve(router, "GET", "/new/1")
+	if w.Body.String() != "new" || w.Header().Get("X-Outer") != "yes" || w.Header().Get("X-Fresh") != "" {
+		t.Errorf("GET /new/1 = %q %v, want the new route wrapped in the middlewares of the router only", w.Body.String(), w.Header())
+	}
+	if w := serve(router, "GET", "/api/ping"); w.Body.String() != "pong" {
+		t.Errorf("GET /api/ping = %q, want pong", w.Body.String())
+	}
+	if u, err := router.URL("new", "id", "7"); err != nil || u != "/new/7" {
+		t.Errorf("URL(new) = %q, %v, want /new/7", u, err)
+	}
+}
+
+func TestSwapRouteMethods(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %v", RoutePattern(r), RouteMethods(r))
+	}
+	router := NewRouter()
+	if err := router.Swap(func(fresh *Router) {
+		fresh.Get("/about", handler)
+		fresh.Get("/book/:id", handler)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	router.Post("/about", handler)
+	router.Delete("/book/:id", handler)
+	runtime.GC()
+
+	for path, want := range map[string]string{
+		"/about":   "/about [GET HEAD OPTIONS POST]",
+		"/book/42": "/book/:id [DELETE GET HEAD OPTIONS]",
+	} {
+		if w := serve(router, http.MethodGet, path); w.Body.String() != want {
+			t.Errorf("GET %s after Swap = %q, want %q", path, w.Body.String(), want)
+		}
+	}
+}
+
+func TestSwapRejected(t *testing.T) {
+	router := NewRouter()
+	router.Get("/old", reply("old"))
+	err := router.Swap(func(fresh *Router) {
+		fresh.Get("/ok", reply("ok"))
+		fresh.Get("/bad/:id:[0-9", reply("bad"))
+	})
+	if err == nil {
+		t.Fatal("Swap() = nil, want the rejected registration")
+	}
+	if got := router.Generation(); got != 0 {
+		t.Errorf("Generation() after a failed build = %d, want 0", got)
+	}
+	if w := serve(router, "GET", "/old"); w.Body.String() != "old" {
+		t.Errorf("GET /old after a failed build = %d %q, want the old routes", w.Code, w.Body.String())
+	}
+	if w := serve(router, "GET", "/ok"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /ok after a failed build = %d, want 404", w.Code)
+	}
+}
+
+func TestSwapInFlight(t *testing.T) {
+	router := NewRouter()
+	entered, release := make(chan struct{}), make(chan struct{})
+	router.Get("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("old"))
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(router, "GET", "/slow") }()
+	<-entered
+	if err := router.Swap(func(fresh *Router) { fresh.Get("/slow", reply("new")) }); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(router, "GET", "/slow"); w.Body.String() != "new" {
+		t.Errorf("GET /slow after the swap = %q, want new", w.Body.String())
+	}
+	close(release)
+	if w := <-done; w.Body.String() != "old" {
+		t.Errorf("GET /slow in flight = %q, want old", w.Body.String())
+	}
+}
+
+func TestSwapConcurrent(t *testing.T) {
+	router := NewRouter()
+	router.Get("/gen", reply("0"))
+	router.Get("/other", reply("0"))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, path := range []string{"/gen", "/other"} {
+					if w := serve(router, "GET", path); w.Code != http.StatusOK {
+						t.Errorf("GET %s during the swaps = %d, want 200", path, w.Code)
+						return
+					}
+				}
+			}
+		}()
+	}
+	const swaps = 50
+	for i := 1; i <= swaps; i++ {
+		err := router.Swap(func(fresh *Router) {
+			fresh.Get("/gen", reply(fmt.Sprint(i)))
+			fresh.Get("/other", reply(fmt.Sprint(i)))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := router.Generation(); got != swaps {
+		t.Errorf("Generation() = %d, want %d", got, swaps)
+	}
+	if w := serve(router, "GET", "/gen"); w.Body.String() != fmt.Sprint(swaps) {
+		t.Errorf("GET /gen = %q, want %d", w.Body.String(), swaps)
+	}
+}
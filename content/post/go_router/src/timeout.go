@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timeout returns a middleware giving each request d to be answered. The
+// handler runs with a context cancelled after d, so handlers watching
+// r.Context().Done() can bail out early. When d elapses before the handler
+// sent its header, the client gets status, 503 Service Unavailable when 0, or
+// e.g. 408 Request Timeout; when the header was sent the response is cut
+// short. Either way the later writes of the handler are discarded and return
+// http.ErrHandlerTimeout. A panic of the handler reaches the router panic
+// handler as if it was not wrapped. Until d elapses the handler can send
+// EarlyHints, use http.ResponseController and hijack the connection, for a
+// websocket say, which the timeout then leaves alone: only its context is
+// still cancelled after d.
+func Timeout(d time.Duration, status int) middleware {
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+						return
+					}
+					close(done)
+				}()
+				h.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			select {
+			case <-done:
+			case p := <-panicked:
+				tw.stop(0)
+				panic(p)
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded {
+					tw.stop(status)
+				} else {
+					tw.stop(0) // the client is gone, nobody reads the answer
+				}
+			}
+		})
+	}
+}
+
+// timeoutWriter passes the writes of a handler through to w until stop is
+// called. The handler has its own header map, copied to w when the header is
+// sent, so that stop can answer without racing the handler.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	stopped     bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.stopped {
+		tw.writeHeader(code)
+	}
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	header := tw.w.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range tw.header {
+		header[key] = append([]string(nil), values...)
+	}
+	tw.w.WriteHeader(code)
+	tw.wroteHeader = code >= 200 // 1xx answers are followed by the final one
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.stopped {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.stopped {
+		return
+	}
+	tw.writeHeader(http.StatusOK)
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter, until
+// stop is called: it returns nil then, so nothing reaches w past the timeout.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.stopped {
+		return nil
+	}
+	return tw.w
+}
+
+// Hijack hands the connection to the handler unless stop was called, stop
+// does not answer on it afterwards.
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.stopped {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, brw, err := http.NewResponseController(tw.w).Hijack()
+	if err == nil {
+		tw.wroteHeader = true
+	}
+	return conn, brw, err
+}
+
+// stop discards the next writes of the handler and, when status is not 0
+// and the header was not sent yet, answers with status.
+func (tw *timeoutWriter) stop(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.stopped = true
+	if status != 0 && !tw.wroteHeader {
+		http.Error(tw.w, http.StatusText(status), status)
+	}
+}
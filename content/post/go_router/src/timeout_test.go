@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	late := make(chan error, 1)
+	router := NewRouter()
+	router.Get("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Fast", "yes")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "fast")
+	}, Timeout(time.Second, 0))
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			fmt.Fprint(w, "slow")
+		}
+	}, Timeout(10*time.Millisecond, 0))
+	router.Get("/request", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, Timeout(10*time.Millisecond, http.StatusRequestTimeout))
+	router.Get("/late", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond) // ignore the cancellation for a while
+		w.Header().Set("X-Late", "yes")
+		_, err := fmt.Fprint(w, "late")
+		late <- err
+	}, Timeout(10*time.Millisecond, 0))
+	router.Get("/partial", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "partial")
+		<-r.Context().Done()
+	}, Timeout(10*time.Millisecond, 0))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/fast", http.StatusCreated, "fast"},
+		{"/slow", http.StatusServiceUnavailable, "Service Unavailable\n"},
+		{"/request", http.StatusRequestTimeout, "Request Timeout\n"},
+		{"/partial", http.StatusOK, "partial"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/fast"); w.Header().Get("X-Fast") != "yes" {
+		t.Error("GET /fast lost the header set by the handler")
+	}
+
+	w := serve(router, http.MethodGet, "/late")
+	if err := <-late; err != http.ErrHandlerTimeout {
+		t.Errorf("write after the timeout = %v, want %v", err, http.ErrHandlerTimeout)
+	}
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "Service Unavailable\n" || w.Header().Get("X-Late") != "" {
+		t.Errorf("GET /late = %d %q %v, want the 503 alone", w.Code, w.Body.String(), w.Header())
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	router := NewRouter()
+	router.Get("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}, Timeout(time.Second, 0))
+	router.PanicHandler(func(w http.ResponseWriter, r *http.Request, err any) {
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+	})
+
+	if w := serve(router, http.MethodGet, "/boom"); w.Code != http.StatusInternalServerError || w.Body.String() != "boom\n" {
+		t.Errorf("GET /boom = %d %q, want the panic handler 500", w.Code, w.Body.String())
+	}
+}
+
+func TestTimeoutHijack(t *testing.T) {
+	router := NewRouter()
+	router.Get("/raw", func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Error(err)
+		}
+		conn, brw, err := rc.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		time.Sleep(100 * time.Millisecond) // past the timeout, which must not answer
+		brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 3\r\nConnection: close\r\n\r\nraw")
+		brw.Flush()
+	}, Timeout(50*time.Millisecond, 0))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "raw" {
+		t.Errorf("GET /raw = %d %q, want the raw answer of the hijacked connection", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Tracer starts the spans of the requests served by a router, see
+// WithTracer. It is implemented by an adapter of the tracing library, such
+// as OpenTelemetry, so the router does not depend on one.
+type Tracer interface {
+	// Start returns a context holding a new span named name, a child of
+	// parent when parent.Valid() is true.
+	Start(ctx context.Context, name string, parent TraceParent) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. SetName renames it, the span of a
+// request handed to a mounted router is named once the route matched there.
+type Span interface {
+	SetName(name string)
+	SetAttribute(key string, value any)
+	End()
+}
+
+// TraceParent is the remote parent span a request announces in its W3C
+// traceparent and tracestate headers.
+type TraceParent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string // tracestate, as is
+}
+
+// Valid reports whether p was sent, with non-zero IDs.
+func (p TraceParent) Valid() bool {
+	return p.TraceID != [16]byte{} && p.SpanID != [8]byte{}
+}
+
+// Sampled reports whether the parent was sampled.
+func (p TraceParent) Sampled() bool {
+	return p.Flags&1 == 1
+}
+
+// parseTraceParent reads the traceparent header "version-traceid-spanid-flags"
+// with hex fields, a malformed header is ignored.
+func parseTraceParent(header http.Header) TraceParent {
+	var p TraceParent
+	fields := strings.Split(strings.TrimSpace(header.Get("Traceparent")), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || (fields[0] == "00" && len(fields) != 4) {
+		return TraceParent{}
+	}
+	version, traceID, spanID, flags := fields[0], fields[1], fields[2], fields[3]
+	var b [1]byte
+	if _, err := hex.Decode(b[:], []byte(version)); err != nil || len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 {
+		return TraceParent{}
+	}
+	if _, err := hex.Decode(p.TraceID[:], []byte(traceID)); err != nil {
+		return TraceParent{}
+	}
+	if _, err := hex.Decode(p.SpanID[:], []byte(spanID)); err != nil {
+		return TraceParent{}
+	}
+	if _, err := hex.Decode(b[:], []byte(flags)); err != nil {
+		return TraceParent{}
+	}
+	p.Flags = b[0]
+	p.State = header.Get("Tracestate")
+	if !p.Valid() {
+		return TraceParent{}
+	}
+	return p
+}
+
+// startSpan starts the span of r, named after the method and the pattern of
+// the matched route as RoutePattern returns it: "GET /book/:id", or the
+// method alone when no route matched, so that the span names stay few.
+func startSpan(ctx context.Context, tracer Tracer, r *http.Request, pattern string) (context.Context, Span) {
+	name := r.Method
+	if pattern != "" {
+		name += " " + pattern
+	}
+	ctx, span := tracer.Start(ctx, name, parseTraceParent(r.Header))
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	if pattern != "" {
+		span.SetAttribute("http.route", pattern)
+	}
+	return ctx, span
+}
+
+// nameSpan names span after the route pattern matched by a mounted router.
+func nameSpan(span Span, method, pattern string) {
+	span.SetName(method + " " + pattern)
+	span.SetAttribute("http.route", pattern)
+}
+
+func endSpan(span Span, status int, panicked any) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttribute("http.response.status_code", status)
+	if panicked != nil {
+		span.SetAttribute("panic", fmt.Sprint(panicked))
+	}
+	span.End()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// spanRecorder is a Tracer keeping the spans it started in memory.
+type spanRecorder struct {
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent TraceParent
+	attrs  map[string]any
+	ended  bool
+}
+
+type spanKey struct{}
+
+func (t *spanRecorder) Start(ctx context.Context, name string, parent TraceParent) (context.Context, Span) {
+	span := &recordedSpan{name: name, parent: parent, attrs: map[string]any{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordedSpan) SetName(name string) {
+	s.name = name
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) {
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &spanRecorder{}
+	router := NewRouter(WithTracer(tracer), WithPanicLog(func(*http.Request, any, []byte) {}))
+	var inMiddleware any
+	router.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inMiddleware = r.Context().Value(spanKey{})
+			h.ServeHTTP(w, r)
+		})
+	})
+	router.Get("/book/:id", reply("book"))
+	router.Get("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/book/42", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("Tracestate", "congo=t61rcWkgMzE")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if len(tracer.spans) == 0 || inMiddleware != any(tracer.spans[0]) {
+		t.Error("the middleware did not find the span in the request context")
+	}
+	serve(router, http.MethodGet, "/nope")
+	serve(router, http.MethodGet, "/boom")
+
+	if len(tracer.spans) != 3 {
+		t.Fatalf("%d spans started, want 3", len(tracer.spans))
+	}
+	book, nope, boom := tracer.spans[0], tracer.spans[1], tracer.spans[2]
+	if book.name != "GET /book/:id" || !book.ended || book.attrs["http.route"] != "/book/:id" || book.attrs["http.response.status_code"] != http.StatusOK {
+		t.Errorf("GET /book/42 span = %+v, want GET /book/:id ended with 200", book)
+	}
+	if p := book.parent; !p.Valid() || !p.Sampled() || p.TraceID[0] != 0x4b || p.SpanID[7] != 0xb7 || p.State != "congo=t61rcWkgMzE" {
+		t.Errorf("parent = %+v, want the traceparent header", p)
+	}
+	if nope.name != "GET" || nope.parent.Valid() || nope.attrs["http.response.status_code"] != http.StatusNotFound {
+		t.Errorf("GET /nope span = %+v, want GET with a 404", nope)
+	}
+	if !boom.ended || boom.attrs["panic"] != "boom" || boom.attrs["http.response.status_code"] != http.StatusInternalServerError {
+		t.Errorf("GET /boom span = %+v, want ended with the panic and a 500", boom)
+	}
+}
+
+func TestTracerMounted(t *testing.T) {
+	tracer := &spanRecorder{}
+	users := NewRouter()
+	users.Get("/:id", reply("user"))
+	router := NewRouter(WithTracer(tracer))
+	router.Mount("/tenants/:tid/users", users)
+
+	serve(router, http.MethodGet, "/tenants/7/users/42")
+	serve(router, http.MethodGet, "/tenants/7/users/42/nope")
+	if len(tracer.spans) != 2 {
+		t.Fatalf("%d spans started, want 2", len(tracer.spans))
+	}
+	user, nope := tracer.spans[0], tracer.spans[1]
+	if want := "/tenants/:tid/users/:id"; user.name != "GET "+want || user.attrs["http.route"] != want {
+		t.Errorf("mounted GET span = %+v, want GET %s", user, want)
+	}
+	if nope.name != "GET" || nope.attrs["http.route"] != nil {
+		t.Errorf("unmatched mounted GET span = %+v, want GET without a route", nope)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("Traceparent", tt.header)
+		if got := parseTraceParent(header).Valid(); got != tt.valid {
+			t.Errorf("parseTraceParent(%q).Valid() = %v, want %v", tt.header, got, tt.valid)
+		}
+	}
+}
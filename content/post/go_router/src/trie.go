@@ -1,80 +1,661 @@
 package main
 
 import (
+	"fmt"
+	"iter"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
+	"unsafe"
 )
 
 type node struct {
-	handlers map[string]http.Handler
-	leaves   map[string]*node
-	regex    map[string]*regexp.Regexp
+	segment  string                  // path components the node was created from, "/" joined
+	label    []string                // components of a static leaf, label[0] is its key
+	pattern  string                  // path the first handler was registered with
+	handlers map[string]http.Handler // nil on a node without handlers
+	chains   map[string]http.Handler // handlers wrapped in the router middlewares
+	leaves   leaves
+	params   []*param // in registration order, the first matching wins
+
+	// wildcard is the catch-all leaf registered as "*name", it captures
+	// every remaining segment (at least one) under wildcardName.
+	wildcard     *node
+	wildcardName string
+
+	// mount serves every path under this node, see Router.Mount. depth is
+	// the number of segments of the mount prefix.
+	mount http.Handler
+	depth int
 }
 
 func newNode() *node {
-	return &node{
-		handlers: map[string]http.Handler{},
-		leaves:   map[string]*node{},
-		regex:    map[string]*regexp.Regexp{},
+	return &node{}
+}
+
+// handle sets the handler of method and its chain, allocating the maps of a
+// node serving its first method.
+func (node *node) handle(method string, h, chain http.Handler) {
+	if node.handlers == nil {
+		node.handlers = map[string]http.Handler{}
+		node.chains = map[string]http.Handler{}
 	}
+	node.handlers[method] = h
+	node.chains[method] = chain
 }
 
-func (node *node) getLeaf(v string) (*node, []string) {
-	if node, ok := node.leaves[v]; ok {
-		return node, nil
+// maxSmallLeaves is the number of static leaves a node keeps in a slice
+// scanned linearly, a larger fan-out promotes them to a map. Most nodes have
+// a few children, and comparing a few strings beats hashing the segment.
+const maxSmallLeaves = 8
+
+// leaves are the static leaves of a node keyed by the first component of
+// their label. The zero value is empty.
+type leaves struct {
+	small []leafEntry // sorted by key, nil once promoted to m
+	m     map[string]*node
+}
+
+type leafEntry struct {
+	key  string
+	leaf *node
+}
+
+func (ls *leaves) get(key string) (*node, bool) {
+	if ls.m != nil {
+		leaf, ok := ls.m[key]
+		return leaf, ok
 	}
-	for key, regex := range node.regex {
-		if regex.MatchString(v) {
-			return node.leaves[key], []string{key, v}
+	for _, e := range ls.small {
+		if e.key == key {
+			return e.leaf, true
 		}
 	}
-	return nil, nil
+	return nil, false
 }
 
-func parse(c string) (string, *regexp.Regexp) {
-	if string(c[0]) == ":" {
-		// Given c=":id:^[0-9]$", then pattern=[":id" "^[0-9]$"]
-		pattern := strings.Split(c[1:], ":")
-		if re, err := regexp.Compile(pattern[1]); err == nil {
-			return pattern[0], re
+func (ls *leaves) set(key string, leaf *node) {
+	if ls.m != nil {
+		ls.m[key] = leaf
+		return
+	}
+	i := ls.index(key)
+	if i < len(ls.small) && ls.small[i].key == key {
+		ls.small[i].leaf = leaf
+		return
+	}
+	if len(ls.small) == maxSmallLeaves {
+		ls.m = make(map[string]*node, maxSmallLeaves+1)
+		for _, e := range ls.small {
+			ls.m[e.key] = e.leaf
 		}
+		ls.m[key] = leaf
+		ls.small = nil
+		return
 	}
-	return c, nil
+	ls.small = append(ls.small, leafEntry{})
+	copy(ls.small[i+1:], ls.small[i:])
+	ls.small[i] = leafEntry{key, leaf}
 }
 
-func (node *node) append(path []string) *node {
+// del removes the leaf of key, demoting the map back to a slice once the
+// leaves fit in one.
+func (ls *leaves) del(key string) {
+	if ls.m == nil {
+		if i := ls.index(key); i < len(ls.small) && ls.small[i].key == key {
+			ls.small = append(ls.small[:i:i], ls.small[i+1:]...)
+		}
+		return
+	}
+	delete(ls.m, key)
+	if len(ls.m) <= maxSmallLeaves {
+		small := make([]leafEntry, 0, len(ls.m))
+		for key, leaf := range ls.m {
+			small = append(small, leafEntry{key, leaf})
+		}
+		sort.Slice(small, func(i, j int) bool { return small[i].key < small[j].key })
+		ls.small, ls.m = small, nil
+	}
+}
+
+// index returns the position of key in the small leaves, or where it would
+// be inserted.
+func (ls *leaves) index(key string) int {
+	return sort.Search(len(ls.small), func(i int) bool { return ls.small[i].key >= key })
+}
+
+func (ls *leaves) len() int {
+	if ls.m != nil {
+		return len(ls.m)
+	}
+	return len(ls.small)
+}
+
+// all yields the leaves and their keys, in key order while they are small
+// and in no particular order once promoted.
+func (ls *leaves) all() iter.Seq2[string, *node] {
+	return func(yield func(string, *node) bool) {
+		if ls.m != nil {
+			for key, leaf := range ls.m {
+				if !yield(key, leaf) {
+					return
+				}
+			}
+			return
+		}
+		for _, e := range ls.small {
+			if !yield(e.key, e.leaf) {
+				return
+			}
+		}
+	}
+}
+
+// clone returns a copy that set and del can modify without changing ls.
+func (ls *leaves) clone() leaves {
+	if ls.m != nil {
+		m := make(map[string]*node, len(ls.m))
+		for key, leaf := range ls.m {
+			m[key] = leaf
+		}
+		return leaves{m: m}
+	}
+	return leaves{small: append([]leafEntry(nil), ls.small...)}
+}
+
+// clonePath returns a copy of the trie rooted at n where the nodes along path
+// are copied, so that append or remove can modify them while n is served.
+// The other nodes are shared with n.
+func clonePath(n *node, path []segment) *node {
+	c := *n
+	c.handlers, c.chains = nil, nil
+	for method, h := range n.handlers {
+		c.handle(method, h, n.chains[method])
+	}
+	c.leaves = n.leaves.clone()
+	c.params = append([]*param(nil), n.params...)
 	if len(path) == 0 {
-		return node
+		return &c
 	}
-	component, regex := parse(path[0])
 
-	leaf, _ := node.getLeaf(component)
-	if leaf == nil {
-		node.leaves[component] = newNode()
-		leaf = node.leaves[component]
+	seg := path[0]
+	switch {
+	case seg.wildcard:
+		if c.wildcard != nil {
+			c.wildcard = clonePath(c.wildcard, path[1:])
+		}
+	case seg.regex != nil:
+		for i, p := range c.params {
+			if p.name == seg.name && p.regex.String() == seg.regex.String() {
+				cp := *p
+				cp.leaf = clonePath(p.leaf, path[1:])
+				c.params[i] = &cp
+				break
+			}
+		}
+	default:
+		// A leaf path diverges from is split by append, not modified.
+		if leaf, ok := c.leaves.get(seg.text); ok && matchLabel(leaf.label, path) == len(leaf.label) {
+			c.leaves.set(seg.text, clonePath(leaf, path[len(leaf.label):]))
+		}
+	}
+	return &c
+}
+
+// rechain returns a copy of the trie rooted at n with every handler wrapped
+// again by wrap, after the router middlewares changed.
+func rechain(n *node, wrap func(h http.Handler, method, pattern string) http.Handler) *node {
+	c := *n
+	c.handlers, c.chains = nil, nil
+	for method, h := range n.handlers {
+		c.handle(method, h, wrap(h, method, n.pattern))
+	}
+	c.leaves = n.leaves.clone()
+	for text, leaf := range c.leaves.all() {
+		c.leaves.set(text, rechain(leaf, wrap))
+	}
+	c.params = make([]*param, len(n.params))
+	for i, p := range n.params {
+		cp := *p
+		cp.leaf = rechain(p.leaf, wrap)
+		c.params[i] = &cp
+	}
+	if n.wildcard != nil {
+		c.wildcard = rechain(n.wildcard, wrap)
+	}
+	return &c
+}
 
-		if regex != nil {
-			node.regex[component] = regex
+// param is a leaf matched by a regex rather than by its exact text.
+type param struct {
+	text  string
+	name  string
+	regex *regexp.Regexp
+	leaf  *node
+}
+
+// segment is a parsed path component.
+type segment struct {
+	text     string         // component as registered, e.g. ":id:^[0-9]$"
+	name     string         // param or wildcard name
+	regex    *regexp.Regexp // nil for static and wildcard segments
+	wildcard bool
+}
+
+// parse recognizes the param syntaxes ":name", ":name:regex", "{name}" and
+// "{name:regex}", and the wildcards "*name" and "{name...}". A param without
+// a regex matches any non-empty segment.
+func parse(c string) (segment, error) {
+	switch {
+	case strings.HasPrefix(c, "*"):
+		return segment{text: c, name: c[1:], wildcard: true}, nil
+	case strings.HasPrefix(c, ":"):
+		// Given c=":ts:[0-9]{2}:[0-9]{2}", then name="ts" and
+		// pattern="[0-9]{2}:[0-9]{2}", the regex may contain colons.
+		name, pattern := c[1:], "[^/]+"
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name, pattern = name[:i], name[i+1:]
+		}
+		return parseParam(c, name, pattern)
+	case strings.HasPrefix(c, "{") && strings.HasSuffix(c, "}"):
+		// Given c="{id:[0-9]+}", then name="id" and pattern="[0-9]+"
+		inner := c[1 : len(c)-1]
+		if name := strings.TrimSuffix(inner, "..."); name != inner && name != "" {
+			return segment{text: c, name: name, wildcard: true}, nil
 		}
+		name, pattern := inner, "[^/]+"
+		if i := strings.IndexByte(inner, ':'); i >= 0 {
+			name, pattern = inner[:i], inner[i+1:]
+		}
+		return parseParam(c, name, pattern)
+	case strings.ContainsAny(c, "{}"):
+		return segment{}, fmt.Errorf("router: unexpected brace in %q, params are written {name} or {name:regex}", c)
 	}
+	return segment{text: c}, nil
+}
 
-	return leaf.append(path[1:])
+func parseParam(c, name, pattern string) (segment, error) {
+	if name == "" {
+		return segment{}, fmt.Errorf("router: missing name in param %q", c)
+	}
+	if pattern == "" {
+		return segment{}, fmt.Errorf("router: missing regex in param %q", c)
+	}
+	// The regex must match the whole segment: "[0-9]+" rejects "abc123def".
+	// Anchors already present in pattern are harmless, and ".*[0-9]+.*"
+	// restores substring matching.
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return segment{}, fmt.Errorf("router: invalid regex in param %q: %w", c, err)
+	}
+	return segment{text: c, name: name, regex: re}, nil
 }
 
-func (node *node) search(path []string, vars map[string]string) *node {
+// parsePath parses every component of path, so that an invalid route is
+// rejected before anything is appended to the trie.
+func parsePath(path string) ([]segment, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, fmt.Errorf("router: empty path, the root is registered as \"/\"")
+	}
+	components := split(path)
+	segments := make([]segment, len(components))
+	for i, c := range components {
+		if c == "" {
+			return nil, fmt.Errorf("router: empty segment in %q", path)
+		}
+		seg, err := parse(c)
+		if err != nil {
+			return nil, err
+		}
+		if seg.wildcard && i != len(components)-1 {
+			return nil, fmt.Errorf("router: wildcard %q must be the last segment of %q", c, path)
+		}
+		segments[i] = seg
+	}
+	return segments, nil
+}
+
+func (node *node) append(path []segment) *node {
 	if len(path) == 0 {
 		return node
 	}
+	seg := path[0]
+	if seg.wildcard {
+		if node.wildcard == nil {
+			node.wildcard = newNode()
+			node.wildcard.segment = seg.text
+			node.wildcardName = seg.name
+		}
+		return node.wildcard
+	}
+	if seg.regex != nil {
+		return node.appendParam(seg).append(path[1:])
+	}
+
+	// A static leaf spans the run of static segments nothing branches from,
+	// until a later route diverges within the run and splits it.
+	leaf, ok := node.leaves.get(seg.text)
+	if !ok {
+		label := []string{}
+		for _, seg := range path {
+			if seg.regex != nil || seg.wildcard {
+				break
+			}
+			label = append(label, seg.text)
+		}
+		leaf = newLeaf(label)
+		node.leaves.set(seg.text, leaf)
+	}
+	if m := matchLabel(leaf.label, path); m < len(leaf.label) {
+		leaf = node.split(seg.text, m)
+	}
+	return leaf.append(path[len(leaf.label):])
+}
+
+func newLeaf(label []string) *node {
+	leaf := newNode()
+	leaf.label = label
+	leaf.segment = strings.Join(label, "/")
+	return leaf
+}
+
+// matchLabel returns the number of components of label equal to the
+// static segments starting path.
+func matchLabel(label []string, path []segment) int {
+	m := 0
+	for m < len(label) && m < len(path) && path[m].regex == nil && !path[m].wildcard && path[m].text == label[m] {
+		m++
+	}
+	return m
+}
+
+// split replaces the leaf of key with a leaf spanning the first m components
+// of its label, whose only child spans the others. The leaf is copied, not
+// modified, it may be served.
+func (node *node) split(key string, m int) *node {
+	old, _ := node.leaves.get(key)
+	tail := *old
+	tail.label = old.label[m:]
+	tail.segment = strings.Join(tail.label, "/")
+	head := newLeaf(old.label[:m:m])
+	head.leaves.set(tail.label[0], &tail)
+	node.leaves.set(key, head)
+	return head
+}
+
+func (node *node) appendParam(seg segment) *node {
+	for _, p := range node.params {
+		if p.name == seg.name && p.regex.String() == seg.regex.String() {
+			return p.leaf
+		}
+	}
+	p := &param{text: seg.text, name: seg.name, regex: seg.regex, leaf: newNode()}
+	p.leaf.segment = seg.text
+	node.params = append(node.params, p)
+	return p.leaf
+}
+
+// find walks the trie like append without creating nodes. It returns the
+// node already registered for path, nil when path is new, or an error when a
+// segment is ambiguous with an existing sibling.
+func (node *node) find(path []segment) (*node, error) {
+	if node.mount != nil {
+		return nil, fmt.Errorf("router: path is under the mounted prefix %s", node.pattern)
+	}
+	if len(path) == 0 {
+		return node, nil
+	}
+	seg := path[0]
+	if seg.wildcard {
+		if node.wildcard != nil && node.wildcardName != seg.name {
+			return nil, fmt.Errorf("router: wildcard %q conflicts with existing *%s", seg.text, node.wildcardName)
+		}
+		return node.wildcard, nil
+	}
+	if seg.regex != nil {
+		for _, p := range node.params {
+			sameName, sameRegex := p.name == seg.name, p.regex.String() == seg.regex.String()
+			if sameName && sameRegex {
+				return p.leaf.find(path[1:])
+			}
+			if sameName || sameRegex {
+				return nil, fmt.Errorf("router: param %q conflicts with existing %q", seg.text, p.text)
+			}
+		}
+		return nil, nil
+	}
+	if leaf, ok := node.leaves.get(seg.text); ok && matchLabel(leaf.label, path) == len(leaf.label) {
+		return leaf.find(path[len(leaf.label):])
+	}
+	return nil, nil
+}
+
+// searchMode holds the router options changing how search compares segments.
+type searchMode struct {
+	fold     bool // lower-case segments before looking up static leaves
+	unescape bool // path is escaped, unescape static keys and captured vars
+}
+
+// search returns the node serving path and params with the vars it
+// captured appended. Candidates are tried in precedence order, static leaf,
+// params in registration order, then the wildcard, and search backtracks to
+// the next candidate when a branch does not lead to a route. A frame is
+// pushed for every node of the branch with params or a wildcard left to
+// try, on the goroutine stack up to 8 of them.
+func (node *node) search(path segments, params Params, mode searchMode) (*node, Params) {
+	base := len(params)
+	var stack [8]frame
+	frames := stack[:0]
+	n := node
+outer:
+	for {
+		if n.mount != nil {
+			return n, params
+		}
+		if path == "" {
+			if len(n.handlers) > 0 {
+				return n, params
+			}
+		} else {
+			if len(n.params) > 0 || n.wildcard != nil {
+				frames = append(frames, frame{node: n, path: path, n: len(params)})
+			}
+			seg, rest := path.next()
+			if leaf, ok := n.leaves.get(mode.key(seg)); ok {
+				if rest, ok := leaf.matches(rest, mode); ok {
+					n, path = leaf, rest
+					continue
+				}
+			}
+		}
+
+		// backtrack to the next candidate of the deepest frame
+		for len(frames) > 0 {
+			f := &frames[len(frames)-1]
+			params = params[:f.n] // drop the vars of the abandoned branch
+			seg, rest := f.path.next()
+			for f.next < len(f.node.params) {
+				p := f.node.params[f.next]
+				f.next++
+				if !p.regex.MatchString(seg) {
+					continue
+				}
+				value := seg
+				if mode.unescape {
+					value = unescape(value)
+				}
+				params = append(params, Var{p.name, value})
+				n, path = p.leaf, rest
+				continue outer
+			}
+			parent, path := f.node, f.path
+			frames = frames[:len(frames)-1]
+			if parent.wildcard != nil && len(parent.wildcard.handlers) > 0 {
+				value := string(path)
+				if mode.unescape {
+					value = unescape(value)
+				}
+				return parent.wildcard, append(params, Var{parent.wildcardName, value})
+			}
+		}
+		return nil, params[:base]
+	}
+}
+
+// frame is a node of the branch search is trying. next is the index of the
+// param tried next, the wildcard is tried after the params. n is the number
+// of vars captured up to the node.
+type frame struct {
+	node    *node
+	path    segments
+	n, next int
+}
 
-	leaf, pattern := node.getLeaf(path[0])
-	if leaf == nil {
-		return nil
+// key returns the form of a path segment static leaves are stored with.
+func (mode searchMode) key(s string) string {
+	if mode.unescape {
+		s = unescape(s)
 	}
+	if mode.fold {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// matches consumes the label of the static leaf, whose first component was
+// already looked up, from path. It returns the remaining segments and
+// whether the label matched.
+func (node *node) matches(path segments, mode searchMode) (segments, bool) {
+	for _, label := range node.label[1:] {
+		if path == "" {
+			return path, false
+		}
+		seg, rest := path.next()
+		if mode.key(seg) != label {
+			return path, false
+		}
+		path = rest
+	}
+	return path, true
+}
+
+// unescape decodes an escaped path segment, keeping it as is when malformed.
+func unescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
 
-	if pattern != nil {
-		vars[pattern[0]] = pattern[1]
+// remove deletes the handler of method on the node at path, then prunes the
+// nodes left empty on the way back up.
+func (node *node) remove(path []segment, method string) {
+	if len(path) == 0 {
+		delete(node.handlers, method)
+		delete(node.chains, method)
+		if len(node.handlers) == 0 {
+			node.pattern = ""
+		}
+		return
 	}
+	seg := path[0]
+	switch {
+	case seg.wildcard:
+		node.wildcard.remove(path[1:], method)
+		if node.wildcard.empty() {
+			node.wildcard, node.wildcardName = nil, ""
+		}
+	case seg.regex != nil:
+		for i, p := range node.params {
+			if p.name == seg.name && p.regex.String() == seg.regex.String() {
+				p.leaf.remove(path[1:], method)
+				if p.leaf.empty() {
+					node.params = append(node.params[:i:i], node.params[i+1:]...)
+				}
+				return
+			}
+		}
+	default:
+		leaf, _ := node.leaves.get(seg.text)
+		leaf.remove(path[len(leaf.label):], method)
+		if leaf.empty() {
+			node.leaves.del(seg.text)
+		} else if len(leaf.handlers) == 0 && len(leaf.params) == 0 && leaf.wildcard == nil && leaf.mount == nil && leaf.leaves.len() == 1 {
+			// nothing branches from leaf anymore, merge it with its child
+			for _, child := range leaf.leaves.all() {
+				merged := *child
+				merged.label = append(append([]string{}, leaf.label...), child.label...)
+				merged.segment = strings.Join(merged.label, "/")
+				node.leaves.set(seg.text, &merged)
+			}
+		}
+	}
+}
 
-	return leaf.search(path[1:], vars)
+// statics adds the nodes serving a path of static segments only to routes,
+// keyed by the path. prefix is the path of node, empty for the root, its
+// array is reused for the paths of the leaves.
+func (node *node) statics(prefix []byte, routes map[string]*node) {
+	if len(node.handlers) > 0 {
+		if len(prefix) == 0 {
+			routes["/"] = node
+		} else {
+			routes[string(prefix)] = node
+		}
+	}
+	for _, leaf := range node.leaves.all() {
+		leaf.statics(append(append(prefix, '/'), leaf.segment...), routes)
+	}
+}
+
+// count returns the number of nodes in the trie rooted at node.
+func (node *node) count() int {
+	n := 1
+	for _, child := range children(node) {
+		n += child.count()
+	}
+	return n
+}
+
+// size returns the approximate number of bytes held by the trie rooted at
+// node: the nodes, their children storage and handler maps, and the params.
+func (node *node) size() int {
+	n := int(unsafe.Sizeof(*node)) + cap(node.label)*int(unsafe.Sizeof("")) +
+		cap(node.leaves.small)*int(unsafe.Sizeof(leafEntry{})) +
+		mapSize(len(node.leaves.m), unsafe.Sizeof("")+unsafe.Sizeof(node)) +
+		2*mapSize(len(node.handlers), unsafe.Sizeof("")+unsafe.Sizeof(http.Handler(nil))) +
+		cap(node.params)*int(unsafe.Sizeof(node)) + len(node.params)*int(unsafe.Sizeof(param{}))
+	for _, child := range children(node) {
+		n += child.size()
+	}
+	return n
+}
+
+// mapSize approximates the bytes of a map of n entries of slot bytes, as
+// groups of 8 slots and a control word at most 7/8 full.
+func mapSize(n int, slot uintptr) int {
+	if n == 0 {
+		return 0
+	}
+	groups := 1
+	for groups*7 < n {
+		groups *= 2
+	}
+	return 48 + groups*(8+8*int(slot))
+}
+
+func (node *node) empty() bool {
+	return len(node.handlers) == 0 && node.leaves.len() == 0 && len(node.params) == 0 &&
+		node.wildcard == nil && node.mount == nil
+}
+
+func (node *node) methods() []string {
+	methods := make([]string, 0, len(node.handlers))
+	for method := range node.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
 }
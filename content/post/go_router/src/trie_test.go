@@ -0,0 +1,708 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWildcard(t *testing.T) {
+	router := NewRouter()
+	router.Get("/static/*filepath", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file: " + Vars(r)["filepath"]))
+	})
+	router.Get("/static/favicon.ico", reply("favicon"))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/static/site.css", http.StatusOK, "file: site.css"},
+		{"/static/css/vendor/site.css", http.StatusOK, "file: css/vendor/site.css"},
+		{"/static/favicon.ico", http.StatusOK, "favicon"},
+		// a wildcard needs at least one segment to capture
+		{"/static", http.StatusNotFound, "404 page not found\n"},
+		{"/static/", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
+
+func TestWildcardNotLast(t *testing.T) {
+	if err := NewRouter().Get("/static/*filepath/edit", reply("edit")); err == nil {
+		t.Error("expected an error for a wildcard that is not the last segment")
+	}
+}
+
+func TestStaticBeforeRegex(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book/:id:^[a-z]+$", reply("id"))
+	router.Get("/book/new", reply("new"))
+	router.Get("/book/:slug:^[a-z0-9]+$", reply("slug"))
+
+	tests := []struct {
+		path, body string
+	}{
+		{"/book/new", "new"},
+		{"/book/abc", "id"},    // both params match, the first registered wins
+		{"/book/abc1", "slug"}, // only the second param matches
+	}
+	for i := 0; i < 1000; i++ {
+		for _, tt := range tests {
+			if w := serve(router, http.MethodGet, tt.path); w.Body.String() != tt.body {
+				t.Fatalf("iteration %d: GET %s = %q, want %q", i, tt.path, w.Body.String(), tt.body)
+			}
+		}
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	router := NewRouter()
+	for _, path := range []string{
+		"/user/:id:[0-9+",
+		"/user/:id:",
+		"/user/:id:[0-9]+/post/:post:(",
+		"/user/:",
+		"/user/::[0-9]+",
+	} {
+		if err := router.Get(path, reply("user")); err == nil {
+			t.Errorf("Get(%q) = nil, want an error", path)
+		}
+	}
+	// nothing was half-registered by the failed calls
+	if n := router.routes.Load().trie.leaves.len(); n != 0 {
+		t.Errorf("trie has %d leaves, want 0", n)
+	}
+
+	if err := router.Get("/user/:id:^[0-9]+$", reply("user")); err != nil {
+		t.Fatalf("Get = %v, want nil", err)
+	}
+	if w := serve(router, http.MethodGet, "/user/42"); w.Body.String() != "user" {
+		t.Errorf("GET /user/42 = %q, want %q", w.Body.String(), "user")
+	}
+}
+
+func TestMustHandle(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustHandle to panic on an invalid regex")
+		}
+	}()
+	NewRouter().MustHandle("/user/:id:[0-9+", http.MethodGet, reply("user"))
+}
+
+func TestConflicts(t *testing.T) {
+	router := NewRouter()
+	router.Get("/home", reply("home"))
+	router.Get("/book/:id:^[0-9]+$", reply("id"))
+
+	for _, path := range []string{
+		"/home",
+		"/home/",
+		"/book/:id:^[0-9]+$",
+		"/book/:id:^[a-z]+$",  // same name, different regex
+		"/book/:num:^[0-9]+$", // same regex, different name
+	} {
+		if err := router.Get(path, reply("dup")); err == nil {
+			t.Errorf("Get(%q) = nil, want a conflict", path)
+		}
+	}
+
+	// another method or a distinct param is fine
+	if err := router.Post("/home", reply("post")); err != nil {
+		t.Errorf("Post(/home) = %v, want nil", err)
+	}
+	if err := router.Get("/book/:slug:^[a-z]+$", reply("slug")); err != nil {
+		t.Errorf("Get(/book/:slug) = %v, want nil", err)
+	}
+
+	if w := serve(router, http.MethodGet, "/home"); w.Body.String() != "home" {
+		t.Errorf("GET /home = %q, want the first handler", w.Body.String())
+	}
+}
+
+func TestOverride(t *testing.T) {
+	router := NewRouter(WithOverride())
+	router.Get("/home", reply("home"))
+	if err := router.Get("/home", reply("new home")); err != nil {
+		t.Fatalf("Get = %v, want nil", err)
+	}
+	if w := serve(router, http.MethodGet, "/home"); w.Body.String() != "new home" {
+		t.Errorf("GET /home = %q, want %q", w.Body.String(), "new home")
+	}
+}
+
+func TestBraceSyntax(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		vars := Vars(r)
+		w.Write([]byte(vars["id"] + " " + vars["page"] + " " + vars["path"]))
+	}
+
+	router := NewRouter()
+	router.Get("/book/{id:^[0-9]+$}/page/:page:^[0-9]+$", echo)
+	router.Get("/author/{id}", echo)
+	router.Get("/files/{path...}", echo)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/book/42/page/7", http.StatusOK, "42 7 "},
+		{"/book/abc/page/7", http.StatusNotFound, "404 page not found\n"},
+		{"/author/ana", http.StatusOK, "ana  "},
+		{"/files/a/b.txt", http.StatusOK, "  a/b.txt"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	// both syntaxes are the same param
+	if err := router.Get("/book/:id:^[0-9]+$/page/{page:^[0-9]+$}", echo); err == nil {
+		t.Error("expected a conflict between the two syntaxes of the same route")
+	}
+	if err := router.Get("/book/:num:^[0-9]+$", echo); err == nil {
+		t.Error("expected a conflict between {id:^[0-9]+$} and :num:^[0-9]+$")
+	}
+
+	for _, path := range []string{"/a{b", "/a}", "/{}", "/{:[0-9]+}", "/{id:}"} {
+		if err := router.Get(path, echo); err == nil {
+			t.Errorf("Get(%q) = nil, want an error", path)
+		}
+	}
+}
+
+func TestAnchoredRegex(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book/:id:[0-9]+", reply("book"))
+	router.Get("/pet/:kind:cat|dog", reply("pet"))
+	router.Get("/tag/:tag:^[a-z]+$", reply("tag"))
+	router.Get("/search/:q:.*go.*", reply("search"))
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/book/123", http.StatusOK},
+		{"/book/abc123def", http.StatusNotFound},
+		{"/pet/cat", http.StatusOK},
+		{"/pet/dog", http.StatusOK},
+		{"/pet/catdog", http.StatusNotFound},
+		{"/pet/bobcat", http.StatusNotFound},
+		{"/pet/doggo", http.StatusNotFound},
+		{"/tag/go", http.StatusOK},
+		{"/tag/go1", http.StatusNotFound},
+		{"/search/golang", http.StatusOK},
+		{"/search/lets-go", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+}
+
+func TestColonInRegex(t *testing.T) {
+	router := NewRouter()
+	router.Get("/time/:ts:[0-9]{2}:[0-9]{2}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Vars(r)["ts"]))
+	})
+	router.Get("/book/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Vars(r)["id"]))
+	})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/time/12:30", http.StatusOK, "12:30"},
+		{"/time/12", http.StatusNotFound, "404 page not found\n"},
+		{"/book/anything-goes", http.StatusOK, "anything-goes"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
+
+func TestBareParam(t *testing.T) {
+	echo := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + "=" + Vars(r)[name]))
+		}
+	}
+
+	router := NewRouter()
+	router.Get("/book/:isbn:[0-9]{13}", echo("isbn"))
+	router.Get("/book/:id", echo("id"))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/book/42", http.StatusOK, "id=42"},
+		{"/book/9780134190440", http.StatusOK, "isbn=9780134190440"},
+		{"/book/", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
+
+func TestBacktracking(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		vars := Vars(r)
+		w.Write([]byte(r.URL.Path + " dir=" + vars["dir"] + " file=" + vars["file"] + " rest=" + vars["rest"]))
+	}
+
+	router := NewRouter()
+	router.Get("/files/static/info", echo)
+	router.Get("/files/:dir:[a-z]+/download", echo)
+	router.Get("/files/static/:file:[a-z]+/raw", echo)
+	router.Get("/files/:dir:[a-z]+/:file:[a-z]+/meta", echo)
+	router.Get("/files/*rest", echo)
+
+	tests := []struct {
+		path, body string
+	}{
+		{"/files/static/info", "/files/static/info dir= file= rest="},
+		// one level: static "static" fails on "download"
+		{"/files/static/download", "/files/static/download dir=static file= rest="},
+		{"/files/static/logo/raw", "/files/static/logo/raw dir= file=logo rest="},
+		// two levels: static then static/:file fail before :dir/:file
+		{"/files/static/logo/meta", "/files/static/logo/meta dir=static file=logo rest="},
+		// every branch fails, file set by them is rolled back
+		{"/files/static/logo/other", "/files/static/logo/other dir= file= rest=static/logo/other"},
+	}
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Body.String() != tt.body {
+			t.Errorf("GET %s = %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	router := NewRouter()
+	router.Get("/files/static/info", reply("info"))
+	router.Get("/files/:dir:[a-z]+/download", reply("download"))
+	router.Get("/book/:id:[0-9]+/reviews/:review", reply("review"))
+	path := pathSegments("/book/42/reviews/7")
+
+	var buf [4]Var
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		router.routes.Load().trie.search(path, buf[:0], searchMode{})
+	}
+}
+
+// apiRoutes returns 500 routes of a REST API, 50 resources of 10 routes with
+// long static tails.
+func apiRoutes() []string {
+	var routes []string
+	for i := 0; i < 50; i++ {
+		res := fmt.Sprintf("/api/v1/resource%02d", i)
+		routes = append(routes,
+			res, res+"/:id", res+"/:id/members", res+"/:id/members/:member",
+			res+"/settings/notifications/email", res+"/settings/notifications/sms",
+			res+"/settings/billing/invoices", res+"/reports/daily/summary",
+			res+"/reports/weekly/summary", res+"/admin/audit/log/entries",
+		)
+	}
+	return routes
+}
+
+func BenchmarkSearchAPI(b *testing.B) {
+	router := NewRouter()
+	for _, route := range apiRoutes() {
+		router.Get(route, reply(route))
+	}
+	trie := router.routes.Load().trie
+	paths := []segments{
+		pathSegments("/api/v1/resource42/admin/audit/log/entries"),
+		pathSegments("/api/v1/resource07/settings/notifications/sms"),
+		pathSegments("/api/v1/resource13/42/members/7"),
+		pathSegments("/api/v1/resource49"),
+	}
+
+	var buf [4]Var
+	b.ReportAllocs()
+	b.ReportMetric(float64(trie.count()), "nodes")
+	for i := 0; i < b.N; i++ {
+		trie.search(paths[i%len(paths)], buf[:0], searchMode{})
+	}
+}
+
+// TestParamRegistrationOrder pins the tie-break between params matching the
+// same segment: the first registered wins, however specific the others are.
+func TestParamRegistrationOrder(t *testing.T) {
+	numFirst := NewRouter()
+	numFirst.Get("/v/:num:[0-9]+", reply("num"))
+	numFirst.Get(`/v/:word:\w+`, reply("word"))
+
+	wordFirst := NewRouter()
+	wordFirst.Get(`/v/:word:\w+`, reply("word"))
+	wordFirst.Get("/v/:num:[0-9]+", reply("num"))
+
+	tests := []struct {
+		router     *Router
+		path, body string
+	}{
+		{numFirst, "/v/42", "num"},
+		{numFirst, "/v/abc", "word"},
+		{wordFirst, "/v/42", "word"},
+		{wordFirst, "/v/abc", "word"},
+	}
+	for i := 0; i < 1000; i++ {
+		for _, tt := range tests {
+			if w := serve(tt.router, http.MethodGet, tt.path); w.Body.String() != tt.body {
+				t.Fatalf("iteration %d: GET %s = %q, want %q", i, tt.path, w.Body.String(), tt.body)
+			}
+		}
+	}
+}
+
+func TestPathCompression(t *testing.T) {
+	router := NewRouter()
+	router.Get("/api/v1/organizations/members/invitations", reply("invitations"))
+	trie := router.routes.Load().trie
+	if n := trie.count(); n != 2 {
+		t.Errorf("trie has %d nodes, want the root and one compressed leaf", n)
+	}
+	before := router.routes.Load()
+
+	// diverging within the label splits it, a route ending within it too
+	router.Get("/api/v1/organizations/teams", reply("teams"))
+	router.Get("/api/v1", reply("v1"))
+	router.Get("/api/v1/organizations/:org", reply("org"))
+	if n := router.routes.Load().trie.count(); n != 6 {
+		t.Errorf("trie has %d nodes after the splits, want 6", n)
+	}
+
+	tests := []struct {
+		path, body string
+	}{
+		{"/api/v1/organizations/members/invitations", "invitations"},
+		{"/api/v1/organizations/teams", "teams"},
+		{"/api/v1", "v1"},
+		{"/api/v1/organizations/acme", "org"},
+		{"/api/v1/organizations/members", "org"}, // backtracks from the static label
+		{"/api/v1/organizations/members/nope", "404 page not found\n"},
+		{"/api", "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Body.String() != tt.body {
+			t.Errorf("GET %s = %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+
+	// the table served before the splits is unchanged
+	if node, _ := before.trie.search(pathSegments("/api/v1/organizations/teams"), nil, searchMode{}); node != nil {
+		t.Error("the split modified the served trie")
+	}
+	if node, _ := before.trie.search(pathSegments("/api/v1/organizations/members/invitations"), nil, searchMode{}); node == nil {
+		t.Error("the split broke the served trie")
+	}
+
+	// removing the routes merges the labels again
+	router.Unhandle("/api/v1/organizations/teams", http.MethodGet)
+	router.Unhandle("/api/v1/organizations/:org", http.MethodGet)
+	router.Unhandle("/api/v1", http.MethodGet)
+	if n := router.routes.Load().trie.count(); n != 2 {
+		t.Errorf("trie has %d nodes after the removals, want 2", n)
+	}
+	if w := serve(router, http.MethodGet, "/api/v1/organizations/members/invitations"); w.Body.String() != "invitations" {
+		t.Errorf("GET invitations after the removals = %q", w.Body.String())
+	}
+	routes := router.Routes()
+	if len(routes) != 1 || routes[0].Pattern != "/api/v1/organizations/members/invitations" {
+		t.Errorf("Routes = %v, want the invitations route", routes)
+	}
+}
+
+func TestLeaves(t *testing.T) {
+	var ls leaves
+	keys := []string{"m", "c", "x", "a", "q", "e", "z", "b", "k", "f"}
+	nodes := map[string]*node{}
+	for i, key := range keys {
+		nodes[key] = newLeaf([]string{key})
+		ls.set(key, nodes[key])
+		if promoted := ls.m != nil; promoted != (i >= maxSmallLeaves) {
+			t.Fatalf("%d leaves promoted to a map = %t", i+1, promoted)
+		}
+		for _, key := range keys[:i+1] {
+			if leaf, ok := ls.get(key); !ok || leaf != nodes[key] {
+				t.Fatalf("%d leaves: get(%q) = %v, %t", i+1, key, leaf, ok)
+			}
+		}
+		if leaf, ok := ls.get("nope"); ok {
+			t.Fatalf("%d leaves: get(nope) = %v", i+1, leaf)
+		}
+		if i < maxSmallLeaves && !sort.SliceIsSorted(ls.small, func(i, j int) bool { return ls.small[i].key < ls.small[j].key }) {
+			t.Fatalf("small leaves are not sorted: %v", ls.small)
+		}
+	}
+	if ls.len() != len(keys) {
+		t.Errorf("len = %d, want %d", ls.len(), len(keys))
+	}
+
+	clone := ls.clone()
+	ls.set("a", nodes["b"])
+	for _, key := range keys[:3] {
+		ls.del(key)
+	}
+	if leaf, _ := clone.get("a"); leaf != nodes["a"] || clone.len() != len(keys) {
+		t.Error("modifying the leaves changed their clone")
+	}
+	if ls.m != nil || len(ls.small) != len(keys)-3 {
+		t.Fatalf("leaves after the deletions = %v, %v, want %d small ones", ls.small, ls.m, len(keys)-3)
+	}
+	for _, key := range keys[3:] {
+		if leaf, ok := ls.get(key); !ok || (key != "a" && leaf != nodes[key]) {
+			t.Errorf("get(%q) after the deletions = %v, %t", key, leaf, ok)
+		}
+	}
+	var got []string
+	for key := range ls.all() {
+		got = append(got, key)
+	}
+	if want := []string{"a", "b", "e", "f", "k", "q", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("all = %v, want %v", got, want)
+	}
+}
+
+// TestLeavesFanOut serves the children of a node while they are promoted
+// from a slice to a map and demoted again.
+func TestLeavesFanOut(t *testing.T) {
+	router := NewRouter()
+	router.Get("/dir", reply("dir"))
+	for i := 0; i < 20; i++ {
+		router.Get(fmt.Sprintf("/dir/file%02d", i), reply(fmt.Sprint(i)))
+		for j := 0; j <= i; j++ {
+			path := fmt.Sprintf("/dir/file%02d", j)
+			if w := serve(router, http.MethodGet, path); w.Body.String() != fmt.Sprint(j) {
+				t.Fatalf("%d children: GET %s = %q", i+1, path, w.Body.String())
+			}
+		}
+	}
+	dir, _ := router.routes.Load().trie.leaves.get("dir")
+	if dir.leaves.m == nil {
+		t.Error("20 children are not in a map")
+	}
+	if dir.handlers == nil || router.routes.Load().trie.handlers != nil {
+		t.Error("handlers maps are not allocated on the nodes with handlers only")
+	}
+
+	for i := 0; i < 17; i++ {
+		router.Unhandle(fmt.Sprintf("/dir/file%02d", i), http.MethodGet)
+	}
+	dir, _ = router.routes.Load().trie.leaves.get("dir")
+	if dir.leaves.m != nil || dir.leaves.len() != 3 {
+		t.Errorf("3 children are not in a slice: %v, %v", dir.leaves.small, dir.leaves.m)
+	}
+	for i := 0; i < 20; i++ {
+		path, want := fmt.Sprintf("/dir/file%02d", i), fmt.Sprint(i)
+		if i < 17 {
+			want = "404 page not found\n"
+		}
+		if w := serve(router, http.MethodGet, path); w.Body.String() != want {
+			t.Errorf("GET %s after the removals = %q, want %q", path, w.Body.String(), want)
+		}
+	}
+}
+
+// BenchmarkTrieMemory builds the trie of githubAPI, reporting its size as
+// measured by Stats next to the allocations.
+func BenchmarkTrieMemory(b *testing.B) {
+	var root *node
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		root = newNode()
+		for _, route := range githubAPI {
+			segments, err := parsePath(route.path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			root.append(segments).handle(route.method, nil, nil)
+		}
+	}
+	b.ReportMetric(float64(root.count()), "nodes")
+	b.ReportMetric(float64(root.size()), "trie-bytes")
+}
+
+// BenchmarkSearchFanOut looks up the children of a node with few and with
+// many static leaves.
+func BenchmarkSearchFanOut(b *testing.B) {
+	for _, n := range []int{4, maxSmallLeaves, 32} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			root := newNode()
+			paths := make([]segments, n)
+			for i := range paths {
+				path := fmt.Sprintf("/child%02d", i)
+				segments, _ := parsePath(path)
+				root.append(segments).handle(http.MethodGet, nil, nil)
+				paths[i] = pathSegments(path)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				root.search(paths[i%n], nil, searchMode{})
+			}
+		})
+	}
+}
+
+// searchRecursive is the recursive search the loop of search replaced, kept
+// as the reference of TestSearchDifferential.
+func (node *node) searchRecursive(path segments, params Params, mode searchMode) (*node, Params) {
+	if node.mount != nil {
+		return node, params
+	}
+	if path == "" {
+		if len(node.handlers) > 0 {
+			return node, params
+		}
+		return nil, params
+	}
+	seg, rest := path.next()
+
+	if leaf, ok := node.leaves.get(mode.key(seg)); ok {
+		if rest, ok := leaf.matches(rest, mode); ok {
+			if found, ps := leaf.searchRecursive(rest, params, mode); found != nil {
+				return found, ps
+			}
+		}
+	}
+
+	for _, p := range node.params {
+		if !p.regex.MatchString(seg) {
+			continue
+		}
+		value := seg
+		if mode.unescape {
+			value = unescape(value)
+		}
+		if found, ps := p.leaf.searchRecursive(rest, append(params, Var{p.name, value}), mode); found != nil {
+			return found, ps
+		}
+	}
+
+	if node.wildcard != nil && len(node.wildcard.handlers) > 0 {
+		value := string(path)
+		if mode.unescape {
+			value = unescape(value)
+		}
+		return node.wildcard, append(params, Var{node.wildcardName, value})
+	}
+	return nil, params
+}
+
+// TestSearchDifferential compares search with searchRecursive on random
+// route sets and request paths, the node and the vars must be the same.
+func TestSearchDifferential(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	segs := []string{"a", "b", "ab", ":x", ":n:[0-9]+", ":s:[a-b]+", "{y}"}
+	words := []string{"a", "b", "ab", "ba", "1", "42", "c", "A", "%61"}
+	matched := 0
+	for set := 0; set < 200; set++ {
+		opts := []Option{}
+		mode := searchMode{}
+		switch set % 3 {
+		case 1:
+			opts, mode = append(opts, WithCaseInsensitive()), searchMode{fold: true}
+		case 2:
+			opts, mode = append(opts, WithEncodedPath()), searchMode{unescape: true}
+		}
+		router := NewRouter(opts...)
+		for i := 0; i < 20; i++ {
+			var parts []string
+			for depth := rng.Intn(5); depth > 0; depth-- {
+				parts = append(parts, segs[rng.Intn(len(segs))])
+			}
+			if len(parts) > 0 && rng.Intn(4) == 0 {
+				parts = append(parts, "*w")
+			}
+			router.Get("/"+strings.Join(parts, "/"), reply("r")) // conflicts are skipped
+		}
+		trie := router.routes.Load().trie
+		for i := 0; i < 100; i++ {
+			var parts []string
+			for depth := rng.Intn(6); depth > 0; depth-- {
+				parts = append(parts, words[rng.Intn(len(words))])
+			}
+			path := pathSegments("/" + strings.Join(parts, "/"))
+			want, wantParams := trie.searchRecursive(path, Params{{"parent", "p"}}, mode)
+			got, gotParams := trie.search(path, Params{{"parent", "p"}}, mode)
+			if got != nil {
+				matched++
+			}
+			if got != want || fmt.Sprint(gotParams) != fmt.Sprint(wantParams) {
+				t.Fatalf("search %q in %v = %v %v, want %v %v", path, router.Routes(), got, gotParams, want, wantParams)
+			}
+		}
+	}
+	t.Logf("%d of 20000 paths matched", matched)
+
+	// more frames than the stack array holds
+	router := NewRouter()
+	router.Get("/"+strings.Repeat(":p/", 11)+"end", reply("end"))
+	router.Get("/"+strings.Repeat(":p/", 11)+":last", reply("last"))
+	path := pathSegments("/" + strings.Repeat("x/", 12))
+	got, params := router.routes.Load().trie.search(path, nil, searchMode{})
+	if want, _ := router.routes.Load().trie.searchRecursive(path, nil, searchMode{}); got != want || len(params) != 12 {
+		t.Errorf("search of 12 params = %v %v, want %v", got, params, want)
+	}
+}
+
+func BenchmarkSearchDeep(b *testing.B) {
+	router := NewRouter()
+	router.Get("/a/:b/c/:d/e/:f/g/:h/i/:j", reply("deep"))
+	router.Get("/a/:b/c/:d/e/:f/g/:h/i/static", reply("static"))
+	trie := router.routes.Load().trie
+	path := pathSegments("/a/1/c/2/e/3/g/4/i/5")
+
+	var buf [8]Var
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		trie.search(path, buf[:0], searchMode{})
+	}
+}
+
+// FuzzParse registers random patterns, Handle must return an error or add a
+// route served for the pattern taken as a path, never panic.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"/book/:id:[0-9]+", "/time/:ts:[0-9]{2}:[0-9]{2}", "/:", "/book/:", ":name", "/:id:",
+		"/{id}", "/{", "/{id", "/{:[0-9]}", "/*", "/*rest/more", "/{rest...}", "/a/:b:(", "",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, pattern string) {
+		router := NewRouter()
+		if err := router.Get(pattern, reply("r")); err != nil {
+			return
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = pattern
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	})
+}
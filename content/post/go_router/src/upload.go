@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// UploadOptions are the limits of HandleUpload, a zero limit is no limit.
+type UploadOptions struct {
+	// MaxPartSize is the size limit of every part, file or field.
+	MaxPartSize int64
+	// MaxTotalSize is the size limit of the whole body, part headers and
+	// skipped parts included.
+	MaxTotalSize int64
+	// MaxFiles is the number of file parts accepted.
+	MaxFiles int
+	// AllowedTypes are the media types of the files accepted, checked
+	// against the type sniffed from their first 512 bytes with
+	// http.DetectContentType rather than the Content-Type sent by the
+	// client. "image/*" accepts every image type. Empty accepts any file.
+	AllowedTypes []string
+}
+
+// UploadPart is a part of a multipart body, read from the body as the
+// handler reads it.
+type UploadPart struct {
+	io.Reader
+	FormName string
+	FileName string               // "" for a form field
+	Header   textproto.MIMEHeader // as sent by the client
+	// ContentType is the type sniffed from the content of a file, without
+	// its parameters.
+	ContentType string
+}
+
+var (
+	errUploadTooLarge = &HTTPError{Code: http.StatusRequestEntityTooLarge, Msg: "upload too large"}
+	errTooManyFiles   = &HTTPError{Code: http.StatusRequestEntityTooLarge, Msg: "too many files"}
+	errNotMultipart   = &HTTPError{Code: http.StatusUnsupportedMediaType, Msg: "not a multipart/form-data body"}
+)
+
+// HandleUpload calls fn with the parts of the multipart/form-data body of r
+// in order. Unlike ParseMultipartForm, nothing is buffered in memory or in
+// temporary files: fn streams the part, what it does not read is skipped.
+//
+// A limit of opts exceeded, even while fn reads a part, fails with a 413
+// HTTPError, and a file of a type not allowed or a body that is not
+// multipart with a 415, so that a HandleE handler can return the error as
+// is. An error of fn stops the upload and is returned.
+func HandleUpload(r *http.Request, opts UploadOptions, fn func(part UploadPart) error) error {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return errNotMultipart
+	}
+	body := &uploadReader{r: r.Body, limit: opts.MaxTotalSize}
+	parts := multipart.NewReader(body, params["boundary"])
+	files := 0
+	for {
+		p, err := parts.NextPart()
+		if body.exceeded {
+			return errUploadTooLarge
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &HTTPError{Code: http.StatusBadRequest, Msg: "malformed multipart body: " + err.Error()}
+		}
+
+		part := UploadPart{FormName: p.FormName(), FileName: p.FileName(), Header: p.Header}
+		content := &uploadReader{r: p, limit: opts.MaxPartSize}
+		part.Reader = content
+		if part.FileName != "" {
+			if files++; opts.MaxFiles > 0 && files > opts.MaxFiles {
+				return errTooManyFiles
+			}
+			sniff := make([]byte, 512)
+			n, err := io.ReadFull(content, sniff)
+			if content.exceeded || body.exceeded {
+				return errUploadTooLarge
+			}
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				return fmt.Errorf("router: reading %s: %w", part.FileName, err)
+			}
+			part.ContentType, _, _ = strings.Cut(http.DetectContentType(sniff[:n]), ";")
+			if !allowedType(opts.AllowedTypes, part.ContentType) {
+				return NewHTTPError(http.StatusUnsupportedMediaType, part.FileName+": "+part.ContentType+" not allowed")
+			}
+			part.Reader = io.MultiReader(bytes.NewReader(sniff[:n]), content)
+		}
+
+		err = fn(part)
+		if content.exceeded || body.exceeded {
+			return errUploadTooLarge // even when fn ignored the read error
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func allowedType(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, t := range allowed {
+		if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadReader fails with errUploadTooLarge past limit bytes, unless limit
+// is 0.
+type uploadReader struct {
+	r        io.Reader
+	n, limit int64
+	exceeded bool
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	if u.exceeded {
+		return 0, errUploadTooLarge
+	}
+	if u.limit > 0 && int64(len(p)) > u.limit-u.n+1 {
+		p = p[:u.limit-u.n+1] // one byte more tells the limit is exceeded
+	}
+	n, err := u.r.Read(p)
+	u.n += int64(n)
+	if u.limit > 0 && u.n > u.limit {
+		u.exceeded = true
+		return n - int(u.n-u.limit), errUploadTooLarge
+	}
+	if errors.Is(err, errUploadTooLarge) {
+		u.exceeded = true // the part has read past the limit of the body
+	}
+	return n, err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type uploadFile struct {
+	field, name, contentType string
+	data                     []byte
+}
+
+func newUpload(files ...uploadFile) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, f := range files {
+		header := map[string][]string{"Content-Disposition": {fmt.Sprintf(`form-data; name=%q`, f.field)}}
+		if f.name != "" {
+			header["Content-Disposition"][0] += fmt.Sprintf(`; filename=%q`, f.name)
+			header["Content-Type"] = []string{f.contentType}
+		}
+		part, _ := mw.CreatePart(header)
+		part.Write(f.data)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func pngImage() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+	return buf.Bytes()
+}
+
+func TestHandleUpload(t *testing.T) {
+	photo := pngImage()
+	r := newUpload(
+		uploadFile{field: "title", data: []byte("holidays")},
+		uploadFile{"photos", "beach.png", "image/png", photo},
+		uploadFile{"photos", "notes.txt", "text/plain", []byte("sunny")},
+	)
+	var got []string
+	err := HandleUpload(r, UploadOptions{MaxPartSize: 1 << 10, MaxFiles: 2, AllowedTypes: []string{"image/*", "text/plain"}}, func(part UploadPart) error {
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		got = append(got, fmt.Sprintf("%s %s %s %d", part.FormName, part.FileName, part.ContentType, len(data)))
+		if part.FileName == "beach.png" && !bytes.Equal(data, photo) {
+			t.Error("beach.png read back differs")
+		}
+		return nil
+	})
+	want := []string{"title   8", fmt.Sprintf("photos beach.png image/png %d", len(photo)), "photos notes.txt text/plain 5"}
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("HandleUpload = %v with parts %q, want %q", err, got, want)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = HandleUpload(newUpload(uploadFile{"a", "a.txt", "", []byte("a")}, uploadFile{"b", "b.txt", "", []byte("b")}), UploadOptions{}, func(part UploadPart) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("HandleUpload = %v after %d calls, want the error of fn after 1", err, calls)
+	}
+}
+
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestHandleUploadLimits(t *testing.T) {
+	// a part that never ends: only a streaming HandleUpload returns
+	stream := func() *http.Request {
+		head := "--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"big.txt\"\r\n\r\n"
+		r := httptest.NewRequest(http.MethodPost, "/upload", io.MultiReader(strings.NewReader(head), endless{}))
+		r.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+		return r
+	}
+	var read int64
+	readAll := func(part UploadPart) error {
+		n, err := io.Copy(io.Discard, part)
+		read += n
+		return err
+	}
+	ignoreErrors := func(part UploadPart) error {
+		io.Copy(io.Discard, part)
+		return nil
+	}
+	exe := append([]byte("MZ\x90\x00\x03\x00\x00\x00"), make([]byte, 600)...)
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		opts UploadOptions
+		fn   func(part UploadPart) error
+		code int
+	}{
+		{"part too large", stream(), UploadOptions{MaxPartSize: 100 << 10}, readAll, http.StatusRequestEntityTooLarge},
+		{"body too large", stream(), UploadOptions{MaxTotalSize: 100 << 10}, ignoreErrors, http.StatusRequestEntityTooLarge},
+		{"skipped part too large", stream(), UploadOptions{MaxTotalSize: 100 << 10}, func(UploadPart) error { return nil }, http.StatusRequestEntityTooLarge},
+		{"too many files", newUpload(uploadFile{"a", "a.txt", "", nil}, uploadFile{"b", "b.txt", "", nil}), UploadOptions{MaxFiles: 1}, readAll, http.StatusRequestEntityTooLarge},
+		{"disguised executable", newUpload(uploadFile{"f", "cat.png", "image/png", exe}), UploadOptions{AllowedTypes: []string{"image/png"}}, readAll, http.StatusUnsupportedMediaType},
+		{"not multipart", httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("{}")), UploadOptions{}, readAll, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		read = 0
+		err := HandleUpload(tt.r, tt.opts, tt.fn)
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.Code != tt.code {
+			t.Errorf("%s: HandleUpload = %v, want a %d", tt.name, err, tt.code)
+		}
+		if read > 100<<10 {
+			t.Errorf("%s: fn read %d bytes past the limit", tt.name, read)
+		}
+	}
+
+	router := NewRouter()
+	router.HandleE("/upload", http.MethodPost, func(w http.ResponseWriter, r *http.Request) error {
+		return HandleUpload(r, UploadOptions{MaxPartSize: 1 << 10}, readAll)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, stream())
+	if w.Code != http.StatusRequestEntityTooLarge || w.Body.String() != "upload too large\n" {
+		t.Errorf("upload through HandleE = %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HandleNamed is like Handle and also records the route under name, so its
+// path can be built with Router.URL.
+func (g *Group) HandleNamed(name, path, method string, h http.Handler, mws ...middleware) error {
+	h = g.wrap(h, mws)
+	g.router.mu.Lock()
+	defer g.router.mu.Unlock()
+
+	if _, ok := g.router.names[name]; ok {
+		return g.router.reject(fmt.Errorf("router: route name %q is already registered", name))
+	}
+	path = g.path(path)
+	err := g.router.reject(g.router.update(func(t *table) error {
+		return g.router.register(t, path, []string{method}, h)
+	}))
+	if err != nil {
+		return err
+	}
+	segments, _ := parsePath(path)
+	g.router.names[name] = segments
+	return nil
+}
+
+// URL builds the path of the route registered under name. pairs alternates
+// param names and values, e.g. URL("book.show", "id", "42"). Values must
+// satisfy the regex of their segment and are escaped, so a slash in a param
+// value is sent as %2F.
+func (router *Router) URL(name string, pairs ...string) (string, error) {
+	router.mu.Lock()
+	segments, ok := router.names[name]
+	router.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("router: no route named %q", name)
+	}
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("router: odd number of URL params for %q", name)
+	}
+	values := map[string]string{}
+	for i := 0; i < len(pairs); i += 2 {
+		values[pairs[i]] = pairs[i+1]
+	}
+
+	var b strings.Builder
+	for _, seg := range segments {
+		if seg.regex == nil && !seg.wildcard {
+			if seg.text != "" {
+				b.WriteString("/" + seg.text)
+			}
+			continue
+		}
+		v, ok := values[seg.name]
+		if !ok || v == "" {
+			return "", fmt.Errorf("router: missing param %q for route %q", seg.name, name)
+		}
+		if seg.wildcard {
+			for _, c := range strings.Split(v, "/") {
+				b.WriteString("/" + url.PathEscape(c))
+			}
+			continue
+		}
+		if !seg.regex.MatchString(v) {
+			return "", fmt.Errorf("router: param %q=%q does not match %s in route %q", seg.name, v, seg.regex, name)
+		}
+		b.WriteString("/" + url.PathEscape(v))
+	}
+	if b.Len() == 0 {
+		return "/", nil
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	router := NewRouter()
+	router.HandleNamed("home", "/", http.MethodGet, reply("home"))
+	router.HandleNamed("book.show", "/book/:id:^[0-9]+$", http.MethodGet, reply("book"))
+	router.Group("/users").HandleNamed("user.file", "/:name:.+/files/*path", http.MethodGet, reply("file"))
+
+	tests := []struct {
+		name  string
+		pairs []string
+		url   string
+		err   bool
+	}{
+		{"home", nil, "/", false},
+		{"book.show", []string{"id", "42"}, "/book/42", false},
+		{"user.file", []string{"name", "a/b c", "path", "docs/cv.pdf"}, "/users/a%2Fb%20c/files/docs/cv.pdf", false},
+		{"book.show", nil, "", true},                   // missing param
+		{"book.show", []string{"id", "abc"}, "", true}, // fails the regex
+		{"book.show", []string{"id"}, "", true},        // odd pairs
+		{"book.edit", []string{"id", "42"}, "", true},  // unknown name
+	}
+	for _, tt := range tests {
+		url, err := router.URL(tt.name, tt.pairs...)
+		if url != tt.url || (err != nil) != tt.err {
+			t.Errorf("URL(%q, %q) = %q, %v, want %q, error %t", tt.name, tt.pairs, url, err, tt.url, tt.err)
+		}
+	}
+
+	if err := router.HandleNamed("home", "/other", http.MethodGet, reply("other")); err == nil {
+		t.Error("expected an error for a duplicate route name")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ctxKey is the type of the context keys set by the router, so they cannot
+// collide with the keys of other packages.
+type ctxKey int
+
+const (
+	varsKey ctxKey = iota
+	jsonKey
+	requestIDKey
+	routeKey
+	userKey
+	claimsKey
+	nonceKey
+	csrfKey
+	proxiesKey
+	methodKey
+	rendererKey
+	notFoundKey
+	sessionKey
+	versionKey
+	variantKey
+	mountSpanKey
+)
+
+// Var is a param or wildcard captured from the request path.
+type Var struct {
+	Name, Value string
+}
+
+// Params are the vars captured from the request path, in path order. A var
+// captured twice, by a route repeating a name or by a mount prefix and the
+// mounted route, keeps both values and the last one wins.
+type Params []Var
+
+// Get returns the value captured for name, or "" when there is none.
+func (ps Params) Get(name string) string {
+	value, _ := ps.Lookup(name)
+	return value
+}
+
+// Lookup returns the value captured for name and whether there is one.
+func (ps Params) Lookup(name string) (string, bool) {
+	for i := len(ps) - 1; i >= 0; i-- {
+		if ps[i].Name == name {
+			return ps[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// RouteParams returns the vars captured from the request path, nil for a
+// static route or a request not served by a Router. The router does not
+// reuse them, a handler may keep them after the request.
+func RouteParams(r *http.Request) Params {
+	ps, _ := r.Context().Value(varsKey).(Params)
+	return ps
+}
+
+// Vars returns the params and wildcards captured from the request path. The
+// map is empty, not nil, for a static route or a request not served by a
+// Router. It is built from RouteParams on every call, a handler may keep or
+// modify it.
+func Vars(r *http.Request) map[string]string {
+	ps := RouteParams(r)
+	vars := make(map[string]string, len(ps))
+	for _, p := range ps {
+		vars[p.Name] = p.Value
+	}
+	return vars
+}
+
+// Param returns the value captured for name, or "" when there is none.
+func Param(r *http.Request, name string) string {
+	return RouteParams(r).Get(name)
+}
+
+// AllowedMethods returns the methods served by the route matching an OPTIONS
+// request, including the implicit HEAD and OPTIONS. It returns nil for other
+// methods and when no route matched.
+func AllowedMethods(r *http.Request) []string {
+	if r.Method != http.MethodOptions {
+		return nil
+	}
+	return RouteMethods(r)
+}
+
+// RoutePattern returns the pattern of the route matching r as registered,
+// such as "/book/:id:[0-9]+", prefixed by the patterns the router is mounted
+// on. It returns "" when no route matched, and outside of the router, where
+// r.Pattern may be set by another mux.
+func RoutePattern(r *http.Request) string {
+	if c, ok := r.Context().Value(routeKey).(*routeContext); ok && c.node != nil {
+		return c.pattern
+	}
+	return ""
+}
+
+// RouteMethods returns the methods served by the route matching r,
+// including the implicit HEAD and OPTIONS, or nil when no route matched.
+func RouteMethods(r *http.Request) []string {
+	if c, ok := r.Context().Value(routeKey).(*routeContext); ok && c.node != nil {
+		return c.router.allowed(c.node)
+	}
+	return nil
+}
+
+// routeContext is the context of a request matching a route. With a nil
+// node, it is the context of a request handed to a mounted router, pattern
+// being the mount prefix. The router is the one serving the request, which
+// answers for the node whichever router built it, see Swap.
+type routeContext struct {
+	context.Context
+	router  *Router
+	node    *node
+	pattern string
+	params  Params
+}
+
+// paramsContext is the routeContext of a route with vars, holding up to 4 of
+// them in buf so that the route and its vars take one allocation.
+type paramsContext struct {
+	routeContext
+	buf [4]Var
+}
+
+func (c *routeContext) Value(key any) any {
+	switch key {
+	case routeKey:
+		return c
+	case varsKey:
+		if c.params != nil {
+			return c.params
+		}
+	}
+	return c.Context.Value(key)
+}
+
+// joinPattern returns the pattern of a route of a router mounted on prefix.
+func joinPattern(prefix, pattern string) string {
+	if pattern == "/" {
+		return prefix
+	}
+	return strings.TrimSuffix(prefix, "/") + pattern
+}
+
+// ErrMissingVar is wrapped by the VarError of a var absent from the path,
+// typically answered with a 404 while a malformed var gets a 400.
+var ErrMissingVar = errors.New("missing var")
+
+// VarError reports a var or query key that is missing or cannot be
+// converted, Err is ErrMissingVar or the conversion error. Field is the
+// struct field being bound, if any.
+type VarError struct {
+	Field string
+	Name  string
+	Value string
+	Err   error
+}
+
+func (e *VarError) Error() string {
+	prefix := "router: "
+	if e.Field != "" {
+		prefix += "field " + e.Field + ": "
+	}
+	if e.Err == ErrMissingVar {
+		return fmt.Sprintf("%smissing %s", prefix, e.Name)
+	}
+	return fmt.Sprintf("%smalformed %s=%q: %v", prefix, e.Name, e.Value, e.Err)
+}
+
+func (e *VarError) Unwrap() error {
+	return e.Err
+}
+
+// lookup returns the value of name, or a VarError when it is missing.
+func lookup(r *http.Request, name string) (string, error) {
+	value, ok := RouteParams(r).Lookup(name)
+	if !ok {
+		return "", &VarError{Name: name, Err: ErrMissingVar}
+	}
+	return value, nil
+}
+
+func IntVar(r *http.Request, name string) (int, error) {
+	value, err := lookup(r, name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &VarError{Name: name, Value: value, Err: err}
+	}
+	return i, nil
+}
+
+// MustIntVar is IntVar for routes whose regex guarantees the var is an int,
+// it panics otherwise.
+func MustIntVar(r *http.Request, name string) int {
+	i, err := IntVar(r, name)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+func Int64Var(r *http.Request, name string) (int64, error) {
+	value, err := lookup(r, name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, &VarError{Name: name, Value: value, Err: err}
+	}
+	return i, nil
+}
+
+// BoolVar accepts the values of strconv.ParseBool.
+func BoolVar(r *http.Request, name string) (bool, error) {
+	value, err := lookup(r, name)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &VarError{Name: name, Value: value, Err: err}
+	}
+	return b, nil
+}
+
+// UUIDVar accepts a UUID in the 8-4-4-4-12 hex form, in any case, and returns
+// it lower-cased.
+func UUIDVar(r *http.Request, name string) (string, error) {
+	value, err := lookup(r, name)
+	if err != nil {
+		return "", err
+	}
+	if !isUUID(value) {
+		return "", &VarError{Name: name, Value: value, Err: errors.New("invalid UUID")}
+	}
+	return strings.ToLower(value), nil
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestVars(t *testing.T) {
+	router := NewRouter()
+	router.Get("/book/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Param(r, "id") + "," + Param(r, "missing")))
+	})
+	if w := serve(router, http.MethodGet, "/book/42"); w.Body.String() != "42," {
+		t.Errorf("GET /book/42 = %q, want %q", w.Body.String(), "42,")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/book/42", nil)
+	if vars := Vars(r); vars == nil || len(vars) != 0 {
+		t.Errorf("Vars outside the router = %#v, want an empty map", vars)
+	}
+
+	ctx := context.WithValue(r.Context(), "vars", map[string]string{"id": "leak"})
+	if got := Param(r.WithContext(ctx), "id"); got != "" {
+		t.Errorf("Param with a foreign \"vars\" key = %q, want \"\"", got)
+	}
+}
+
+// varsRequest returns a request carrying vars as if a Router had captured them.
+func varsRequest(vars map[string]string) *http.Request {
+	var params Params
+	for name, value := range vars {
+		params = append(params, Var{name, value})
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	return r.WithContext(context.WithValue(r.Context(), varsKey, params))
+}
+
+func TestTypedVars(t *testing.T) {
+	r := varsRequest(map[string]string{
+		"id":    "42",
+		"big":   "9223372036854775808",
+		"neg":   "-9223372036854775808",
+		"flag":  "true",
+		"lower": "3f2504e0-4f89-11d3-9a0c-0305e82c3301",
+		"upper": "3F2504E0-4F89-11D3-9A0C-0305E82C3301",
+		"short": "3f2504e0-4f89-11d3-9a0c-0305e82c330",
+	})
+
+	if i, err := IntVar(r, "id"); i != 42 || err != nil {
+		t.Errorf("IntVar(id) = %d, %v", i, err)
+	}
+	if MustIntVar(r, "id") != 42 {
+		t.Errorf("MustIntVar(id) != 42")
+	}
+	if _, err := IntVar(r, "big"); !errors.Is(err, strconv.ErrRange) || errors.Is(err, ErrMissingVar) {
+		t.Errorf("IntVar(big) error = %v, want a range error", err)
+	}
+	if i, err := Int64Var(r, "neg"); i != -9223372036854775808 || err != nil {
+		t.Errorf("Int64Var(neg) = %d, %v", i, err)
+	}
+	if b, err := BoolVar(r, "flag"); !b || err != nil {
+		t.Errorf("BoolVar(flag) = %v, %v", b, err)
+	}
+	if _, err := BoolVar(r, "id"); err == nil {
+		t.Errorf("BoolVar(id) succeeded")
+	}
+	for _, name := range []string{"lower", "upper"} {
+		if id, err := UUIDVar(r, name); id != "3f2504e0-4f89-11d3-9a0c-0305e82c3301" || err != nil {
+			t.Errorf("UUIDVar(%s) = %q, %v", name, id, err)
+		}
+	}
+	var varErr *VarError
+	if _, err := UUIDVar(r, "short"); !errors.As(err, &varErr) || varErr.Name != "short" || errors.Is(err, ErrMissingVar) {
+		t.Errorf("UUIDVar(short) error = %v, want a malformed VarError", err)
+	}
+
+	if _, err := IntVar(r, "missing"); !errors.Is(err, ErrMissingVar) {
+		t.Errorf("IntVar(missing) error = %v, want ErrMissingVar", err)
+	}
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrMissingVar) {
+			t.Errorf("MustIntVar(missing) panicked with %v", err)
+		}
+	}()
+	MustIntVar(r, "missing")
+}
+
+func TestStaticRouteVars(t *testing.T) {
+	router := NewRouter()
+	router.Get("/about", func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(varsKey) != nil {
+			t.Error("static route added vars to the context")
+		}
+		if vars := Vars(r); vars == nil || len(vars) != 0 {
+			t.Errorf("static route Vars = %#v, want an empty map", vars)
+		}
+	})
+	serve(router, http.MethodGet, "/about")
+}
+
+func TestStaticRouteContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "incoming")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v %s %v", r.Context().Value(key{}), RoutePattern(r), Vars(r))
+	}
+	router := NewRouter()
+	router.Get("/home", handler)
+	router.Get("/book/:id", handler)
+
+	tests := []struct {
+		path, body string
+	}{
+		{"/home", "incoming /home map[]"},
+		{"/book/42", "incoming /book/:id map[id:42]"},
+		{"/home", "incoming /home map[]"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil).WithContext(ctx))
+		if w.Body.String() != tt.body {
+			t.Errorf("GET %s = %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRouteParams(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v %v %s", RouteParams(r), Vars(r), Param(r, "id"))
+	}
+	users := NewRouter()
+	users.Get("/:id", handler)
+
+	router := NewRouter()
+	router.Get("/about", handler)
+	router.Get("/a/:id/b/:id", handler)
+	router.Mount("/users/:id", users)
+
+	tests := []struct {
+		path, body string
+	}{
+		{"/about", "[] map[] "},
+		{"/a/1/b/2", "[{id 1} {id 2}] map[id:2] 2"},   // a repeated name, the last wins
+		{"/users/1/2", "[{id 1} {id 2}] map[id:2] 2"}, // the mounted route wins over the prefix
+	}
+	for _, tt := range tests {
+		if w := serve(router, http.MethodGet, tt.path); w.Body.String() != tt.body {
+			t.Errorf("GET %s = %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+
+	routes := router.routes.Load()
+	r := httptest.NewRequest(http.MethodGet, "/a/1/b/2", nil)
+	var buf [4]Var
+	allocs := testing.AllocsPerRun(100, func() {
+		if node, params := router.match(routes, r, buf[:0]); node == nil || params.Get("id") != "2" {
+			t.Fatalf("match = %v, %v", node, params)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("matching a param route allocates %v times, want 0", allocs)
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	var seen []string
+	record := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = append(seen, fmt.Sprintf("%q %v", RoutePattern(r), RouteMethods(r)))
+			h.ServeHTTP(w, r)
+		})
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", RoutePattern(r), r.Pattern)
+	}
+
+	users := NewRouter(WithoutAutoOptions())
+	users.Get("/", handler)
+	users.Get("/:id:[0-9]+", handler)
+	users.Delete("/:id:[0-9]+", handler)
+	users.Use(record)
+
+	router := NewRouter()
+	router.Get("/book/:id:[0-9]+", handler)
+	router.Mount("/tenants/{tid}/users", users)
+	router.Use(record)
+
+	tests := []struct {
+		path, body, seen string
+	}{
+		{"/book/42", "/book/:id:[0-9]+ /book/:id:[0-9]+", `["/book/:id:[0-9]+" [GET HEAD OPTIONS]]`},
+		{"/nope", "404 page not found\n", `["" []]`},
+		{"/tenants/7/users", "/tenants/{tid}/users /tenants/{tid}/users", `["" [] "/tenants/{tid}/users" [GET HEAD]]`},
+		{"/tenants/7/users/42", "/tenants/{tid}/users/:id:[0-9]+ /tenants/{tid}/users/:id:[0-9]+", `["" [] "/tenants/{tid}/users/:id:[0-9]+" [DELETE GET HEAD]]`},
+		{"/tenants/7/users/bob", "404 page not found\n", `["" [] "" []]`},
+	}
+	for _, tt := range tests {
+		seen = nil
+		w := serve(router, http.MethodGet, tt.path)
+		if w.Body.String() != tt.body {
+			t.Errorf("GET %s = %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+		if got := fmt.Sprint(seen); got != tt.seen {
+			t.Errorf("GET %s middlewares saw %s, want %s", tt.path, got, tt.seen)
+		}
+	}
+}
+
+func TestStaticRouteMethods(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %v", RoutePattern(r), RouteMethods(r))
+	}
+	router := NewRouter()
+	router.Get("/about", handler)
+	router.Post("/about", handler)
+
+	w := serve(router, http.MethodGet, "/about")
+	if want := "/about [GET HEAD OPTIONS POST]"; w.Body.String() != want {
+		t.Errorf("GET /about = %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestRoutePatternAfterSwap(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q %v", RoutePattern(r), RouteMethods(r))
+	}
+	router := NewRouter(WithoutAutoOptions())
+	if err := router.Swap(func(fresh *Router) {
+		fresh.Get("/about", handler)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+	runtime.GC()
+	if w := serve(router, http.MethodGet, "/about"); w.Body.String() != `"/about" [GET HEAD]` {
+		t.Errorf("GET /about after Swap = %s, want %s", w.Body.String(), `"/about" [GET HEAD]`)
+	}
+}
+
+func TestRoutePatternUnderServeMux(t *testing.T) {
+	record := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q %v", RoutePattern(r), RouteMethods(r))
+	}
+	router := NewRouter()
+	router.Get("/api/about", record)
+	router.NotFound(http.HandlerFunc(record))
+	mux := http.NewServeMux()
+	mux.Handle("/api/", router)
+
+	tests := []struct {
+		path, body string
+	}{
+		{"/api/about", `"/api/about" [GET HEAD OPTIONS]`},
+		{"/api/nope", `"" []`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Body.String() != tt.body {
+			t.Errorf("GET %s = %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
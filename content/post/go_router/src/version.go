@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// VersionExtractor finds the API version a request asks for, see
+// Router.Version.
+type VersionExtractor struct {
+	// Extract returns the version r asks for, "" when it names none, and the
+	// path r is routed with, its version prefix removed.
+	Extract func(r *http.Request) (version, path string)
+	// Default is the version of the requests naming none.
+	Default string
+	// Status answers a version no route serves, 400 by default.
+	Status int
+}
+
+// HeaderVersion extracts the version from the request header name, like
+// X-API-Version: 2.
+func HeaderVersion(name, defaultVersion string) VersionExtractor {
+	return VersionExtractor{
+		Extract: func(r *http.Request) (string, string) {
+			return strings.TrimSpace(r.Header.Get(name)), r.URL.Path
+		},
+		Default: defaultVersion,
+	}
+}
+
+// MediaTypeVersion extracts the version from the vendor media type of the
+// Accept header, Accept: application/vnd.<vendor>.v2+json asking for the
+// version 2. A version no route serves gets a 406.
+func MediaTypeVersion(vendor, defaultVersion string) VersionExtractor {
+	prefix := "vnd." + strings.ToLower(vendor) + ".v"
+	return VersionExtractor{
+		Extract: func(r *http.Request) (string, string) {
+			ranges, _ := parseAccept(strings.Join(r.Header.Values("Accept"), ","))
+			for _, mr := range ranges {
+				if mr.typ == "application" && mr.q > 0 && strings.HasPrefix(mr.subtype, prefix) {
+					version, _, _ := strings.Cut(mr.subtype[len(prefix):], "+")
+					return version, r.URL.Path
+				}
+			}
+			return "", r.URL.Path
+		},
+		Default: defaultVersion,
+		Status:  http.StatusNotAcceptable,
+	}
+}
+
+// PathVersion extracts the version from the first segment of the path,
+// "/v2/widgets" asking for the version 2 of "/widgets". The routes are
+// registered without the prefix, which the handlers do not see in the
+// request path either.
+func PathVersion(defaultVersion string) VersionExtractor {
+	return VersionExtractor{
+		Extract: func(r *http.Request) (string, string) {
+			first, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			version, ok := strings.CutPrefix(first, "v")
+			if !ok || version == "" || strings.Trim(version, "0123456789.") != "" {
+				return "", r.URL.Path
+			}
+			return version, "/" + rest
+		},
+		Default: defaultVersion,
+	}
+}
+
+// Version makes the router resolve the API version of every request with
+// extractor before routing it. The routes registered with Versions serve
+// the versions they list, APIVersion returns the version of a request.
+func (router *Router) Version(extractor VersionExtractor) {
+	status := extractor.Status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	router.Pre(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version, path := extractor.Extract(r)
+			if version == "" {
+				version = extractor.Default
+			}
+			r = r.WithContext(context.WithValue(r.Context(), versionKey, apiVersion{version: version, status: status}))
+			if path != r.URL.Path {
+				u := *r.URL
+				prefix := strings.TrimSuffix(u.Path, path)
+				u.Path = path
+				if raw, ok := strings.CutPrefix(u.RawPath, prefix); ok {
+					u.RawPath = raw
+				} else {
+					u.RawPath = ""
+				}
+				r.URL = &u
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+}
+
+// apiVersion is the version of a request with the status answering it when
+// no route serves it.
+type apiVersion struct {
+	version string
+	status  int
+}
+
+func (v apiVersion) unknown() int {
+	if v.status == 0 {
+		return http.StatusBadRequest // Router.Version was not called
+	}
+	return v.status
+}
+
+// APIVersion returns the API version of r resolved by Router.Version, "" when
+// none was.
+func APIVersion(r *http.Request) string {
+	v, _ := r.Context().Value(versionKey).(apiVersion)
+	return v.version
+}
+
+// Versions returns a route middleware serving the route only to the
+// versions listed, see Router.Version. Several routes can be registered for
+// the same path and method with Versions of different versions. A version
+// none of them serves gets the Status of the extractor, listing the versions
+// served.
+func Versions(versions ...string) middleware {
+	if len(versions) == 0 {
+		panic("router: Versions without a version")
+	}
+	return func(h http.Handler) http.Handler {
+		return constraintOption(constrainedRoute{versions: versions}, h)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newVersionRouter(extractor VersionExtractor) *Router {
+	router := NewRouter()
+	router.Version(extractor)
+	router.Get("/widgets/:id", replyID("v1"), Versions("1"), appendVersion)
+	router.Get("/widgets/:id", replyID("v2"), Versions("2", "2.1"), appendVersion)
+	router.Get("/health", replyID("health"), appendVersion)
+	return router
+}
+
+// appendVersion appends the API version and the path routed to the answer.
+func appendVersion(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		io.WriteString(w, " "+APIVersion(r)+" "+r.URL.Path)
+	})
+}
+
+func TestVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor VersionExtractor
+		header    map[string]string
+		path      string
+		code      int
+		body      string
+	}{
+		{"header", HeaderVersion("X-API-Version", "1"), map[string]string{"X-API-Version": "2"}, "/widgets/7", http.StatusOK, "v2 7 2 /widgets/7"},
+		{"header default", HeaderVersion("X-API-Version", "1"), nil, "/widgets/7", http.StatusOK, "v1 7 1 /widgets/7"},
+		{"header unknown", HeaderVersion("X-API-Version", "1"), map[string]string{"X-API-Version": "3"}, "/widgets/7", http.StatusBadRequest, "supported versions: 1, 2, 2.1\n"},
+		{"header unversioned route", HeaderVersion("X-API-Version", "1"), map[string]string{"X-API-Version": "3"}, "/health", http.StatusOK, "health  3 /health"},
+
+		{"media type", MediaTypeVersion("myapp", "1"), map[string]string{"Accept": "application/vnd.myapp.v2+json"}, "/widgets/7", http.StatusOK, "v2 7 2 /widgets/7"},
+		{"media type minor", MediaTypeVersion("myapp", "1"), map[string]string{"Accept": "text/html, application/vnd.MyApp.v2.1+json;q=0.9"}, "/widgets/7", http.StatusOK, "v2 7 2.1 /widgets/7"},
+		{"media type default", MediaTypeVersion("myapp", "1"), map[string]string{"Accept": "application/json"}, "/widgets/7", http.StatusOK, "v1 7 1 /widgets/7"},
+		{"media type unknown", MediaTypeVersion("myapp", "1"), map[string]string{"Accept": "application/vnd.myapp.v9+json"}, "/widgets/7", http.StatusNotAcceptable, "supported versions: 1, 2, 2.1\n"},
+
+		{"path", PathVersion("1"), nil, "/v2/widgets/7", http.StatusOK, "v2 7 2 /widgets/7"},
+		{"path default", PathVersion("1"), nil, "/widgets/7", http.StatusOK, "v1 7 1 /widgets/7"},
+		{"path unknown", PathVersion("1"), nil, "/v3/widgets/7", http.StatusBadRequest, "supported versions: 1, 2, 2.1\n"},
+		{"path not a version", PathVersion("1"), nil, "/vx/widgets/7", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		router := newVersionRouter(tt.extractor)
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("%s: GET %s answered %d %q, want %d %q", tt.name, tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
+
+func TestVersionProblem(t *testing.T) {
+	router := newVersionRouter(PathVersion("1"))
+	r := httptest.NewRequest(http.MethodGet, "/v4/widgets/7", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	var problem struct {
+		Status   int
+		Versions []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || problem.Status != http.StatusBadRequest || len(problem.Versions) != 3 {
+		t.Errorf("unknown version problem = %q, %v", w.Body.String(), err)
+	}
+
+	if err := router.Get("/widgets/:id", func(w http.ResponseWriter, r *http.Request) {}, Versions("2")); err == nil {
+		t.Error("registered a second route serving the version 2")
+	}
+	if err := router.Get("/widgets/:id", func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Error("registered a route serving any version next to the versioned ones")
+	}
+}
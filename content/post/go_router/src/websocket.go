@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The opcodes of the WebSocket messages.
+const (
+	WSText   = 1
+	WSBinary = 2
+)
+
+// The close codes of RFC 6455, section 7.4.1.
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupportedData = 1003
+	WSCloseNoStatus        = 1005 // received for a close frame without code, never sent
+	WSCloseInvalidPayload  = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseTooBig          = 1009
+	WSCloseInternalError   = 1011
+)
+
+const (
+	wsContinuation = 0
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsCloseTimeout = 5 * time.Second
+)
+
+// WSCloseError is returned by ReadMessage once the client closed the
+// connection, with the code and reason of its close frame.
+type WSCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("router: websocket closed with %d %s", e.Code, e.Reason)
+}
+
+// WebSocket registers a GET route on path upgrading the requests to
+// WebSocket connections, served by fn. The connection is closed once fn
+// returns, with a normal close unless fn closed it. The subprotocols are
+// the ones supported, in order of preference: the first one the client
+// offers is chosen, none when it offers none of them. Extensions like
+// compression are not supported. The Origin header is not checked, a
+// CSRF-sensitive endpoint should check it with a middleware.
+func (g *Group) WebSocket(path string, fn func(conn *WSConn), subprotocols ...string) error {
+	return g.Get(path, func(w http.ResponseWriter, r *http.Request) {
+		if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+			w.Header().Set("Upgrade", "websocket")
+			http.Error(w, "websocket handshake expected", http.StatusUpgradeRequired)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+			return
+		}
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+			http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+			return
+		}
+		subprotocol := chooseSubprotocol(r.Header, subprotocols)
+
+		netConn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+			return
+		}
+		upgraded(w)
+		defer netConn.Close()
+		netConn.SetDeadline(time.Time{}) // the server ones are meant for the request
+
+		accept := sha1.Sum([]byte(key + wsGUID))
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+		brw.WriteString(base64.StdEncoding.EncodeToString(accept[:]))
+		if subprotocol != "" {
+			brw.WriteString("\r\nSec-WebSocket-Protocol: " + subprotocol)
+		}
+		brw.WriteString("\r\n\r\n")
+		if err := brw.Flush(); err != nil {
+			return
+		}
+
+		conn := &WSConn{conn: netConn, br: brw.Reader, r: r, subprotocol: subprotocol, readLimit: 1 << 20}
+		defer func() {
+			if err := recover(); err != nil {
+				conn.Close(WSCloseInternalError, "")
+				panic(err) // to the panic handler, which sees the 101 and aborts
+			}
+			conn.finish()
+		}()
+		fn(conn)
+	})
+}
+
+// headerHasToken reports whether the comma separated lists of the header
+// name hold token, in any case.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func chooseSubprotocol(header http.Header, supported []string) string {
+	for _, s := range supported {
+		if headerHasToken(header, "Sec-WebSocket-Protocol", s) {
+			return s
+		}
+	}
+	return ""
+}
+
+// WSConn is a WebSocket connection served by a WebSocket route. It answers
+// the pings of the client and its close handshake while reading. One
+// goroutine may read while others write.
+type WSConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	r           *http.Request
+	subprotocol string
+	readLimit   int64
+
+	writeMu  sync.Mutex
+	closeErr error // set once the close frame is sent, guarded by writeMu
+
+	closed bool // a close frame was received, or the connection failed
+}
+
+// Request returns the handshake request, with the route vars.
+func (c *WSConn) Request() *http.Request {
+	return c.r
+}
+
+// Subprotocol returns the subprotocol chosen, "" for none.
+func (c *WSConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// NetConn returns the underlying connection, to set deadlines.
+func (c *WSConn) NetConn() net.Conn {
+	return c.conn
+}
+
+// SetReadLimit sets the size limit of the messages read, 1 MiB by default.
+// A larger message closes the connection with WSCloseTooBig.
+func (c *WSConn) SetReadLimit(n int64) {
+	c.readLimit = n
+}
+
+// ReadMessage returns the next message, WSText or WSBinary, reassembled
+// from its fragments. Once the client closed the connection it returns a
+// *WSCloseError. A protocol violation by the client closes the connection
+// with the matching code and is returned.
+func (c *WSConn) ReadMessage() (opcode int, data []byte, err error) {
+	if c.closed {
+		return 0, nil, net.ErrClosed
+	}
+	for {
+		fin, op, payload, err := c.readFrame(c.readLimit - int64(len(data)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil && !errors.Is(err, net.ErrClosed) {
+				return 0, nil, err // no pong once closing
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return 0, nil, c.closing(payload)
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(WSCloseProtocolError, "continuation frame without a message")
+			}
+		case WSText, WSBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(WSCloseProtocolError, "new message inside a fragmented one")
+			}
+			opcode = op
+		default:
+			return 0, nil, c.fail(WSCloseProtocolError, fmt.Sprintf("unknown opcode %d", op))
+		}
+		data = append(data, payload...)
+		if fin {
+			if opcode == WSText && !utf8.Valid(data) {
+				return 0, nil, c.fail(WSCloseInvalidPayload, "text message not in UTF-8")
+			}
+			return opcode, data, nil
+		}
+	}
+}
+
+// readFrame reads a frame of a payload of up to limit bytes, control frames
+// being bounded by 125 bytes anyway, and unmasks it.
+func (c *WSConn) readFrame(limit int64) (fin bool, opcode int, payload []byte, err error) {
+	var head [14]byte
+	if _, err := io.ReadFull(c.br, head[:2]); err != nil {
+		c.closed = true
+		return false, 0, nil, err
+	}
+	fin, opcode = head[0]&0x80 != 0, int(head[0]&0x0f)
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "reserved bits set without extension")
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "unmasked client frame")
+	}
+	control := opcode >= wsClose
+	n := uint64(head[1] & 0x7f)
+	if control && (n > 125 || !fin) {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "fragmented or oversized control frame")
+	}
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(c.br, head[2:4]); err != nil {
+			c.closed = true
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(head[2:4]))
+	case 127:
+		if _, err := io.ReadFull(c.br, head[2:10]); err != nil {
+			c.closed = true
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(head[2:10])
+		if n>>63 != 0 {
+			return false, 0, nil, c.fail(WSCloseProtocolError, "invalid payload length")
+		}
+	}
+	if !control && n > uint64(max(limit, 0)) {
+		return false, 0, nil, c.fail(WSCloseTooBig, "message too big")
+	}
+	mask := head[10:14]
+	if _, err := io.ReadFull(c.br, mask); err != nil {
+		c.closed = true
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.closed = true
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return fin, opcode, payload, nil
+}
+
+// closing handles the close frame of the client: its code is echoed unless
+// the server closed first.
+func (c *WSConn) closing(payload []byte) error {
+	c.closed = true
+	closeErr := &WSCloseError{Code: WSCloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(WSCloseProtocolError, "truncated close code")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(WSCloseProtocolError, fmt.Sprintf("invalid close code %d", closeErr.Code))
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(WSCloseInvalidPayload, "close reason not in UTF-8")
+		}
+	}
+	code := closeErr.Code
+	if code == WSCloseNoStatus {
+		code = 0
+	}
+	c.sendClose(code, "")
+	return closeErr
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail closes the connection after a protocol violation of the client.
+func (c *WSConn) fail(code int, reason string) error {
+	c.closed = true
+	c.sendClose(code, reason)
+	c.conn.Close()
+	return errors.New("router: websocket: " + reason)
+}
+
+// WriteMessage sends data as a message of opcode, WSText or WSBinary, in one
+// frame.
+func (c *WSConn) WriteMessage(opcode int, data []byte) error {
+	if opcode != WSText && opcode != WSBinary {
+		return fmt.Errorf("router: websocket: cannot write opcode %d", opcode)
+	}
+	return c.writeFrame(opcode, data)
+}
+
+// Ping sends a ping, the pong of the client is read by ReadMessage.
+func (c *WSConn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("router: websocket: ping payload over 125 bytes")
+	}
+	return c.writeFrame(wsPing, data)
+}
+
+// Close starts the close handshake with code and reason: nothing can be
+// written anymore, ReadMessage returns the close of the client. The
+// connection is closed once the WebSocket handler returns, after the
+// client closed or a few seconds.
+func (c *WSConn) Close(code int, reason string) error {
+	return c.sendClose(code, reason)
+}
+
+func (c *WSConn) sendClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeErr != nil {
+		return c.closeErr
+	}
+	var payload []byte
+	if code != 0 {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason[:min(len(reason), 123)]...)
+	}
+	err := c.writeFrameLocked(wsClose, payload)
+	c.closeErr = net.ErrClosed
+	return err
+}
+
+func (c *WSConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeErr != nil {
+		return c.closeErr
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked writes a final, unmasked frame: the server never masks.
+func (c *WSConn) writeFrameLocked(opcode int, payload []byte) error {
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+// finish ends the connection once the handler returned: it closes it
+// normally unless it was closed, and waits for the close of the client.
+func (c *WSConn) finish() {
+	c.sendClose(WSCloseNormal, "")
+	c.conn.SetReadDeadline(time.Now().Add(wsCloseTimeout))
+	for !c.closed {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is a minimal client of the tests, masking its frames.
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+	res  *http.Response
+}
+
+func dialWS(t *testing.T, server *httptest.Server, path string, header map[string]string) *wsClient {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET " + path + " HTTP/1.1\r\nHost: test\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	for name, value := range header {
+		req += name + ": " + value + "\r\n"
+	}
+	conn.Write([]byte(req + "\r\n"))
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{conn: conn, br: br, res: res}
+}
+
+func (c *wsClient) write(fin bool, opcode byte, payload []byte) {
+	frame := []byte{opcode, 0x80}
+	if fin {
+		frame[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame[1] |= byte(n)
+	case n <= 0xffff:
+		frame[1] |= 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] |= 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+	c.conn.Write(frame)
+}
+
+// read returns the next frame of the server, which must not be masked.
+func (c *wsClient) read(t *testing.T) (opcode byte, payload []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("masked server frame")
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		io.ReadFull(c.br, b[:])
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		io.ReadFull(c.br, b[:])
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func (c *wsClient) readClose(t *testing.T) int {
+	opcode, payload := c.read(t)
+	if opcode != wsClose || len(payload) < 2 {
+		t.Fatalf("read opcode %d %q, want a close frame", opcode, payload)
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// logLines passes the lines logged to the test, once written.
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+type unwrapOnly struct{ http.ResponseWriter }
+
+func (w unwrapOnly) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func newWSServer(t *testing.T, fn func(conn *WSConn)) (*httptest.Server, logLines) {
+	log := make(logLines, 100)
+	router := NewRouter()
+	router.Use(Logger(log))
+	router.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(unwrapOnly{w}, r) // hides Hijack, reached through Unwrap
+		})
+	})
+	router.WebSocket("/ws/:room", fn, "chat.v2", "chat.v1")
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, log
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	ended := make(chan error, 1)
+	server, log := newWSServer(t, func(conn *WSConn) {
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				ended <- err
+				return
+			}
+			conn.WriteMessage(opcode, append([]byte(Param(conn.Request(), "room")+": "), data...))
+		}
+	})
+	c := dialWS(t, server, "/ws/lobby", map[string]string{"Sec-WebSocket-Protocol": "chat.v1, chat.v2"})
+	if c.res.StatusCode != http.StatusSwitchingProtocols ||
+		c.res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		c.res.Header.Get("Sec-WebSocket-Protocol") != "chat.v2" {
+		t.Fatalf("handshake answered %d %v", c.res.StatusCode, c.res.Header)
+	}
+
+	c.write(true, WSText, []byte("hello"))
+	if opcode, payload := c.read(t); opcode != WSText || string(payload) != "lobby: hello" {
+		t.Errorf("echo = %d %q", opcode, payload)
+	}
+	// a fragmented binary message with a ping in between
+	c.write(false, WSBinary, []byte{1, 2})
+	c.write(true, wsPing, []byte("are you there"))
+	c.write(true, wsContinuation, bytes.Repeat([]byte{3}, 300))
+	if opcode, payload := c.read(t); opcode != wsPong || string(payload) != "are you there" {
+		t.Errorf("pong = %d %q", opcode, payload)
+	}
+	if opcode, payload := c.read(t); opcode != WSBinary || len(payload) != len("lobby: ")+302 || payload[len(payload)-1] != 3 {
+		t.Errorf("binary echo = %d of %d bytes", opcode, len(payload))
+	}
+
+	c.write(true, wsClose, closePayload(WSCloseGoingAway, "bye"))
+	if code := c.readClose(t); code != WSCloseGoingAway {
+		t.Errorf("close echoed %d, want 1001", code)
+	}
+	var closeErr *WSCloseError
+	if err := <-ended; !errors.As(err, &closeErr) || closeErr.Code != WSCloseGoingAway || closeErr.Reason != "bye" {
+		t.Errorf("ReadMessage after the close = %v", err)
+	}
+	if _, err := c.br.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open after the close handshake: %v", err)
+	}
+	if line := <-log; !strings.Contains(line, " 101 ") {
+		t.Errorf("logged %q, want the 101", line)
+	}
+}
+
+func TestWebSocketServerClose(t *testing.T) {
+	done := make(chan error, 1)
+	server, _ := newWSServer(t, func(conn *WSConn) {
+		conn.Close(4000, "room closed")
+		done <- conn.WriteMessage(WSText, []byte("late"))
+	})
+	c := dialWS(t, server, "/ws/lobby", nil)
+	if c.res.Header.Get("Sec-WebSocket-Protocol") != "" {
+		t.Errorf("subprotocol %q chosen without an offer", c.res.Header.Get("Sec-WebSocket-Protocol"))
+	}
+	if opcode, payload := c.read(t); opcode != wsClose || string(payload) != string(closePayload(4000, "room closed")) {
+		t.Errorf("server close = %d %q", opcode, payload)
+	}
+	if err := <-done; err == nil {
+		t.Error("wrote a message after the close")
+	}
+	c.write(true, wsClose, closePayload(4000, ""))
+	if _, err := c.br.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open after the close handshake: %v", err)
+	}
+}
+
+func TestWebSocketViolations(t *testing.T) {
+	errs := make(chan error, 1)
+	server, _ := newWSServer(t, func(conn *WSConn) {
+		conn.SetReadLimit(1 << 10)
+		_, _, err := conn.ReadMessage()
+		errs <- err
+	})
+
+	tests := []struct {
+		name  string
+		write func(c *wsClient)
+		code  int
+	}{
+		{"oversized frame", func(c *wsClient) { c.write(true, WSBinary, make([]byte, 2<<10)) }, WSCloseTooBig},
+		{"oversized fragments", func(c *wsClient) {
+			c.write(false, WSBinary, make([]byte, 600))
+			c.write(true, wsContinuation, make([]byte, 600))
+		}, WSCloseTooBig},
+		{"unmasked frame", func(c *wsClient) { c.conn.Write([]byte{0x81, 2, 'h', 'i'}) }, WSCloseProtocolError},
+		{"invalid UTF-8", func(c *wsClient) { c.write(true, WSText, []byte{0xff, 0xfe}) }, WSCloseInvalidPayload},
+		{"continuation first", func(c *wsClient) { c.write(true, wsContinuation, []byte("x")) }, WSCloseProtocolError},
+		{"reserved opcode", func(c *wsClient) { c.write(true, 3, nil) }, WSCloseProtocolError},
+		{"fragmented ping", func(c *wsClient) { c.write(false, wsPing, nil) }, WSCloseProtocolError},
+		{"invalid close code", func(c *wsClient) { c.write(true, wsClose, closePayload(1005, "")) }, WSCloseProtocolError},
+	}
+	for _, tt := range tests {
+		c := dialWS(t, server, "/ws/lobby", nil)
+		tt.write(c)
+		if code := c.readClose(t); code != tt.code {
+			t.Errorf("%s: closed with %d, want %d", tt.name, code, tt.code)
+		}
+		if err := <-errs; err == nil {
+			t.Errorf("%s: ReadMessage succeeded", tt.name)
+		}
+		if _, err := c.br.ReadByte(); err != io.EOF {
+			t.Errorf("%s: connection still open: %v", tt.name, err)
+		}
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	router := NewRouter()
+	router.WebSocket("/ws", func(conn *WSConn) {})
+	tests := []struct {
+		header map[string]string
+		code   int
+	}{
+		{map[string]string{}, http.StatusUpgradeRequired},
+		{map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, http.StatusUpgradeRequired},
+		{map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+		{map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, http.StatusInternalServerError}, // the recorder cannot be hijacked
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("handshake %v = %d, want %d", tt.header, w.Code, tt.code)
+		}
+	}
+}